@@ -0,0 +1,458 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"html/template"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// buildCatalog lists every item of the given section listings (indexed like
+// sections; nil for failed sections).
+func buildCatalog(sections []Directory, listings [][]Video) []CatalogItem {
+	var cat []CatalogItem
+	for si, vids := range listings {
+		for i := range vids {
+			v := &vids[i]
+			it := listedItem(v)
+			ci := CatalogItem{
+				SectionID:      sections[si].Key,
+				RatingKey:      it.RatingKey,
+				Type:           it.Type,
+				Title:          it.Title,
+				Year:           it.Year,
+				ShowTitle:      it.ShowTitle,
+				Season:         it.Season,
+				Episode:        it.Episode,
+				BestResolution: "unknown",
+				Versions:       len(it.Versions),
+			}
+			if g := normalizeGuid(v.Guid); g != "" {
+				ci.IDs = append(ci.IDs, g)
+			}
+			ci.IDs = appendMissing(ci.IDs, it.ExternalIDs...)
+			for _, ver := range it.Versions {
+				ci.BestResolution = betterResolution(ci.BestResolution, normalizeResKey(ver))
+				ci.Bytes += versionBytes(ver)
+			}
+			cat = append(cat, ci)
+		}
+	}
+	return cat
+}
+
+// betterResolution returns the higher of two normalizeResKey values.
+func betterResolution(a, b string) string {
+	if resolutionScores[b] > resolutionScores[a] {
+		return b
+	}
+	return a
+}
+
+// FetchCatalog lists the sections selected by o and returns their catalog,
+// without the duplicate scan.
+func FetchCatalog(ctx context.Context, pc *Client, o Options) ([]CatalogItem, []ScanError, error) {
+	var errs []ScanError
+	sections, err := scanSections(ctx, pc, o, &errs)
+	if err != nil {
+		return nil, errs, err
+	}
+	all, err := fetchAllListings(ctx, pc, o, sections, &errs)
+	if err != nil {
+		return nil, errs, err
+	}
+	return buildCatalog(sections, all), errs, nil
+}
+
+// ServerCatalog is one server's input to Aggregate.
+type ServerCatalog struct {
+	Name  string
+	Items []CatalogItem
+}
+
+// AggregateReport is the cross-server title × server matrix.
+type AggregateReport struct {
+	Generated  time.Time         `json:"generated"`
+	Servers    []AggregateSource `json:"servers"`
+	MinServers int               `json:"min_servers"`
+	Titles     []AggregateTitle  `json:"titles"`
+}
+
+// AggregateSource is one server that was (or failed to be) aggregated.
+type AggregateSource struct {
+	Name  string `json:"name"`
+	Items int    `json:"items"`
+	Error string `json:"error,omitempty"`
+}
+
+// AggregateTitle is one title and the servers that have it.
+type AggregateTitle struct {
+	Type      string                   `json:"type,omitempty"`
+	Title     string                   `json:"title"`
+	Year      int                      `json:"year,omitempty"`
+	ShowTitle string                   `json:"show_title,omitempty"`
+	Season    int                      `json:"season,omitempty"`
+	Episode   int                      `json:"episode,omitempty"`
+	MatchedOn []string                 `json:"matched_on"`
+	Servers   map[string]AggregateCell `json:"servers"` // by server name
+}
+
+// AggregateCell is what one server holds of a title.
+type AggregateCell struct {
+	BestResolution string `json:"best_resolution"`
+	Copies         int    `json:"copies"` // items (not versions) matching the title
+	Versions       int    `json:"versions"`
+	Bytes          int64  `json:"bytes"`
+}
+
+// Aggregate joins the catalogs of several servers on GUIDs and external IDs
+// and returns the titles present on at least minServers servers, sorted by
+// title.
+func Aggregate(cats []ServerCatalog, minServers int) AggregateReport {
+	type ref struct{ srv, item int }
+	var (
+		refs []ref
+		keys [][]string
+	)
+	rep := AggregateReport{MinServers: minServers}
+	for si, c := range cats {
+		rep.Servers = append(rep.Servers, AggregateSource{Name: c.Name, Items: len(c.Items)})
+		for ii, it := range c.Items {
+			refs = append(refs, ref{si, ii})
+			keys = append(keys, it.IDs)
+		}
+	}
+
+	for _, g := range joinByKeys(keys) {
+		t := AggregateTitle{MatchedOn: g.shared, Servers: map[string]AggregateCell{}}
+		for n, i := range g.members {
+			name, it := cats[refs[i].srv].Name, cats[refs[i].srv].Items[refs[i].item]
+			if n == 0 {
+				t.Type, t.Title, t.Year = it.Type, it.Title, it.Year
+				t.ShowTitle, t.Season, t.Episode = it.ShowTitle, it.Season, it.Episode
+			}
+			c, ok := t.Servers[name]
+			if !ok {
+				c.BestResolution = "unknown"
+			}
+			c.BestResolution = betterResolution(c.BestResolution, it.BestResolution)
+			c.Copies++
+			c.Versions += it.Versions
+			c.Bytes += it.Bytes
+			t.Servers[name] = c
+		}
+		if len(t.Servers) >= minServers {
+			rep.Titles = append(rep.Titles, t)
+		}
+	}
+
+	sort.SliceStable(rep.Titles, func(a, b int) bool {
+		return strings.ToLower(rep.Titles[a].label()) < strings.ToLower(rep.Titles[b].label())
+	})
+	rep.Generated = time.Now().UTC().Truncate(time.Second)
+	return rep
+}
+
+func (t AggregateTitle) label() string {
+	return itemLabel(Item{Type: t.Type, Title: t.Title, Year: t.Year, ShowTitle: t.ShowTitle, Season: t.Season, Episode: t.Episode})
+}
+
+// readCatalogReport loads a goPlexr JSON report written with -catalog.
+func readCatalogReport(name, path string) (ServerCatalog, error) {
+	var out Output
+	if err := readJSONFile(path, &out); err != nil {
+		return ServerCatalog{}, err
+	}
+	if out.Catalog == nil {
+		return ServerCatalog{}, fmt.Errorf("%s: report has no catalog; scan with -catalog", path)
+	}
+	return ServerCatalog{Name: name, Items: out.Catalog}, nil
+}
+
+// reportArgs turns "name=report.json" / "report.json" arguments into
+// (name, path) pairs; the name defaults to the file name without extension.
+func reportArgs(args []string) (names, paths []string) {
+	for _, a := range args {
+		name, path, ok := strings.Cut(a, "=")
+		if !ok {
+			path = a
+			name = strings.TrimSuffix(filepath.Base(a), filepath.Ext(a))
+		}
+		names, paths = append(names, name), append(paths, path)
+	}
+	return names, paths
+}
+
+// indexReports lists the JSON reports of a `multi` index.json.
+func indexReports(indexPath string) (names, paths []string, skipped []AggregateSource, err error) {
+	var idx MultiIndex
+	if err := readJSONFile(indexPath, &idx); err != nil {
+		return nil, nil, nil, err
+	}
+	dir := filepath.Dir(indexPath)
+	for _, s := range idx.Servers {
+		if s.JSON == "" {
+			skipped = append(skipped, AggregateSource{Name: s.Name, Error: "no report (" + s.Status + ")"})
+			continue
+		}
+		names, paths = append(names, s.Name), append(paths, filepath.Join(dir, s.JSON))
+	}
+	return names, paths, skipped, nil
+}
+
+// liveCatalogs fetches the catalog of every server in a multi config.
+func liveCatalogs(ctx context.Context, c *MultiConfig, logw io.Writer) ([]ServerCatalog, []AggregateSource) {
+	parallel := c.Parallel
+	if parallel == 0 {
+		parallel = 4
+	}
+	timeout := time.Duration(c.ServerTimeout)
+	if timeout == 0 {
+		timeout = 10 * time.Minute
+	}
+
+	var mu sync.Mutex
+	cats := make([]ServerCatalog, len(c.Servers))
+	errs := make([]error, len(c.Servers))
+	_ = runPool(ctx, parallel, len(c.Servers), func(ctx context.Context, i int) {
+		s := c.Servers[i]
+		o := c.options(s)
+		cats[i].Name = s.Name
+		if err := resolveToken(ctx, &o); err != nil {
+			errs[i] = err
+			return
+		}
+		if o.BaseURL == "" || o.Token == "" {
+			errs[i] = errors.New("missing url or token")
+			return
+		}
+		pc, err := NewClient(o)
+		if err != nil {
+			errs[i] = err
+			return
+		}
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+		items, scanErrs, err := FetchCatalog(ctx, pc, o)
+		cats[i].Items, errs[i] = items, err
+		if err == nil && len(scanErrs) > 0 {
+			mu.Lock()
+			fmt.Fprintf(logw, "WARN: %q: %d request(s) failed; catalog is incomplete\n", s.Name, len(scanErrs))
+			mu.Unlock()
+		}
+	})
+
+	var ok []ServerCatalog
+	var failed []AggregateSource
+	for i := range cats {
+		if errs[i] == nil && ctx.Err() == nil {
+			ok = append(ok, cats[i])
+			continue
+		}
+		err := errs[i]
+		if err == nil {
+			err = ctx.Err()
+		}
+		fmt.Fprintf(logw, "ERROR: %q: %v\n", cats[i].Name, err)
+		failed = append(failed, AggregateSource{Name: cats[i].Name, Error: err.Error()})
+	}
+	return ok, failed
+}
+
+// renderAggregateHTML writes the title × server matrix as a standalone page.
+func renderAggregateHTML(rep AggregateReport, filename string) error {
+	var names []string
+	for _, s := range rep.Servers {
+		if s.Error == "" {
+			names = append(names, s.Name)
+		}
+	}
+	funcs := template.FuncMap{
+		"bytesHuman": BytesHuman,
+		"comma":      func(i any) string { return CommaAny(i) },
+		"label":      func(t AggregateTitle) string { return t.label() },
+		"cell": func(t AggregateTitle, name string) *AggregateCell {
+			if c, ok := t.Servers[name]; ok {
+				return &c
+			}
+			return nil
+		},
+		"resLabel": func(r string) string {
+			if r == "2160" {
+				return "4K"
+			}
+			if r == "unknown" {
+				return "?"
+			}
+			return r + "p"
+		},
+	}
+	const tpl = `<!doctype html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>PLEX Super Duper Report: Shared Titles</title>
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<style>
+body{margin:0;font-family:ui-sans-serif,system-ui,-apple-system,Segoe UI,Roboto,Helvetica,Arial;background:#0f172a;color:#e5e7eb}
+.container{margin:0 auto;padding:24px}
+h1{font-size:28px;margin:0 0 8px}
+.muted{color:#94a3b8}
+.small{font-size:12px}
+table{border-collapse:collapse;margin-top:16px;background:#111827;border:1px solid #1f2937}
+th,td{text-align:left;padding:6px 8px;border-bottom:1px solid #1f2937;vertical-align:top;white-space:nowrap}
+th{position:sticky;top:0;background:#111827}
+.chip{padding:2px 8px;background:#1f2937;border:1px solid #1f2937;border-radius:999px;font-size:12px}
+.chip.r2160{border-color:#10b981;color:#d1fae5}
+.chip.r1080{border-color:#38bdf8;color:#e0f2fe}
+.chip.bad{border-color:#ef4444;color:#fee2e2}
+</style>
+</head>
+<body>
+<div class="container">
+  <h1>PLEX Super Duper Report: Shared Titles</h1>
+  <div class="muted small">Generated: {{ .Rep.Generated.Format "2006-01-02 15:04:05 MST" }} &nbsp;•&nbsp; {{ comma (len .Rep.Titles) }} titles on {{ .Rep.MinServers }}+ servers</div>
+  {{ range .Rep.Servers }}{{ if .Error }}<div class="small" style="margin-top:6px"><span class="chip bad">{{ .Name }}: {{ .Error }}</span></div>{{ end }}{{ end }}
+  <table>
+    <thead><tr><th>Title</th>{{ range .Names }}<th>{{ . }}</th>{{ end }}</tr></thead>
+    <tbody>
+      {{ range $t := .Rep.Titles }}
+      <tr>
+        <td title="{{ range $t.MatchedOn }}{{ . }} {{ end }}">{{ label $t }}</td>
+        {{ range $n := $.Names }}
+        <td>{{ with cell $t $n }}<span class="chip r{{ .BestResolution }}">{{ resLabel .BestResolution }}</span> <span class="muted small">{{ bytesHuman .Bytes }}{{ if gt .Copies 1 }} · {{ .Copies }} copies{{ end }}</span>{{ end }}</td>
+        {{ end }}
+      </tr>
+      {{ end }}
+    </tbody>
+  </table>
+  <div class="muted small" style="margin-top:24px">Report generated by <strong>goPlexr</strong>.</div>
+</div>
+</body>
+</html>`
+
+	t, err := template.New("aggregate").Funcs(funcs).Parse(tpl)
+	if err != nil {
+		return err
+	}
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return t.Execute(f, struct {
+		Rep   AggregateReport
+		Names []string
+	}{rep, names})
+}
+
+// runAggregate implements `goPlexr aggregate`.
+func runAggregate(args []string) int {
+	var (
+		configPath string
+		indexPath  string
+		jsonOut    string
+		htmlOut    string
+		minServers int
+	)
+	fs := flag.NewFlagSet("aggregate", flag.ContinueOnError)
+	fs.StringVar(&configPath, "config", "", "Multi-server JSON config: fetch each server's catalog live")
+	fs.StringVar(&indexPath, "index", "", "index.json of a 'goPlexr multi' run whose reports were scanned with catalog enabled")
+	fs.StringVar(&jsonOut, "json-out", "", "Write the aggregate report as JSON to this file (default: stdout)")
+	fs.StringVar(&htmlOut, "html-out", "", "Write the title × server matrix as HTML to this file")
+	fs.IntVar(&minServers, "min-servers", 2, "Only list titles found on at least this many servers")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage:")
+		fmt.Fprintln(os.Stderr, "  goPlexr aggregate [options] [name=]report.json ...   # reports scanned with -catalog")
+		fmt.Fprintln(os.Stderr, "  goPlexr aggregate [options] -index reports/index.json")
+		fmt.Fprintln(os.Stderr, "  goPlexr aggregate [options] -config servers.json      # live")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	sources := 0
+	for _, set := range []bool{configPath != "", indexPath != "", fs.NArg() > 0} {
+		if set {
+			sources++
+		}
+	}
+	if sources != 1 || minServers < 1 {
+		fmt.Fprintln(os.Stderr, "ERROR: give exactly one of -config, -index or report files (and -min-servers >= 1).")
+		fs.Usage()
+		return exitUsage
+	}
+
+	var (
+		cats   []ServerCatalog
+		failed []AggregateSource
+	)
+	if configPath != "" {
+		c, err := LoadMultiConfig(configPath)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "ERROR:", err)
+			return exitUsage
+		}
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		cats, failed = liveCatalogs(ctx, c, os.Stderr)
+	} else {
+		names, paths := reportArgs(fs.Args())
+		if indexPath != "" {
+			var err error
+			names, paths, failed, err = indexReports(indexPath)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "FATAL:", err)
+				return exitFatal
+			}
+		}
+		seen := map[string]bool{}
+		for i := range paths {
+			if seen[names[i]] {
+				fmt.Fprintf(os.Stderr, "ERROR: server name %q used twice; name reports with name=path.\n", names[i])
+				return exitUsage
+			}
+			seen[names[i]] = true
+			sc, err := readCatalogReport(names[i], paths[i])
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "FATAL:", err)
+				return exitFatal
+			}
+			cats = append(cats, sc)
+		}
+	}
+
+	rep := Aggregate(cats, minServers)
+	rep.Servers = append(rep.Servers, failed...)
+
+	if jsonOut != "" {
+		if err := writeJSONFile(jsonOut, rep, true); err != nil {
+			fmt.Fprintln(os.Stderr, "FATAL:", err)
+			return exitFatal
+		}
+	} else if err := writeJSON(os.Stdout, rep, true); err != nil {
+		fmt.Fprintln(os.Stderr, "FATAL:", err)
+		return exitFatal
+	}
+	if htmlOut != "" {
+		if err := renderAggregateHTML(rep, htmlOut); err != nil {
+			fmt.Fprintln(os.Stderr, "FATAL:", err)
+			return exitFatal
+		}
+	}
+	if len(failed) > 0 {
+		fmt.Fprintf(os.Stderr, "WARN: %d server(s) missing from the aggregate\n", len(failed))
+		return exitPartial
+	}
+	return 0
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestBuildCatalog(t *testing.T) {
+	sections, listings := crossLibFixture()
+	cat := buildCatalog(sections, listings)
+	if len(cat) != 6 {
+		t.Fatalf("catalog = %d items, want 6", len(cat))
+	}
+	heat := cat[0]
+	if heat.SectionID != "1" || heat.Title != "Heat" || heat.BestResolution != "1080" || heat.Bytes != 1000 || heat.Versions != 1 {
+		t.Errorf("heat = %+v", heat)
+	}
+	if !reflect.DeepEqual(heat.IDs, []string{"plex://movie/heat", "imdb://tt0113277"}) {
+		t.Errorf("ids = %v", heat.IDs)
+	}
+	if up := cat[5]; up.IDs != nil || up.BestResolution != "2160" {
+		t.Errorf("local item = %+v", up)
+	}
+}
+
+func TestAggregate(t *testing.T) {
+	alice := ServerCatalog{Name: "alice", Items: []CatalogItem{
+		{Title: "Heat", Year: 1995, IDs: []string{"plex://movie/heat", "imdb://tt0113277"}, BestResolution: "1080", Versions: 1, Bytes: 10},
+		{Title: "Heat", Year: 1995, IDs: []string{"imdb://tt0113277"}, BestResolution: "2160", Versions: 2, Bytes: 50},
+		{Title: "Alien", Year: 1979, IDs: []string{"plex://movie/alien"}, BestResolution: "720", Versions: 1, Bytes: 5},
+		{Title: "Local", IDs: nil, BestResolution: "480"},
+	}}
+	bob := ServerCatalog{Name: "bob", Items: []CatalogItem{
+		{Title: "Heat", Year: 1995, IDs: []string{"plex://movie/heat"}, BestResolution: "720", Versions: 1, Bytes: 7},
+		{Title: "Up", Year: 2009, IDs: []string{"plex://movie/up"}, BestResolution: "1080", Versions: 1, Bytes: 8},
+	}}
+	carol := ServerCatalog{Name: "carol", Items: []CatalogItem{
+		{Title: "Alien", Year: 1979, IDs: []string{"plex://movie/alien"}, BestResolution: "unknown", Versions: 1, Bytes: 3},
+	}}
+
+	rep := Aggregate([]ServerCatalog{alice, bob, carol}, 2)
+	if len(rep.Titles) != 2 {
+		t.Fatalf("titles = %+v, want Alien and Heat", rep.Titles)
+	}
+	alien, heat := rep.Titles[0], rep.Titles[1]
+	if alien.Title != "Alien" || len(alien.Servers) != 2 || alien.Servers["carol"].BestResolution != "unknown" {
+		t.Errorf("alien = %+v", alien)
+	}
+	want := map[string]AggregateCell{
+		"alice": {BestResolution: "2160", Copies: 2, Versions: 3, Bytes: 60},
+		"bob":   {BestResolution: "720", Copies: 1, Versions: 1, Bytes: 7},
+	}
+	if heat.Title != "Heat" || !reflect.DeepEqual(heat.Servers, want) {
+		t.Errorf("heat servers = %+v, want %+v", heat.Servers, want)
+	}
+	if got := len(Aggregate([]ServerCatalog{alice, bob, carol}, 3).Titles); got != 0 {
+		t.Errorf("min-servers 3: %d titles, want 0", got)
+	}
+	if rep.Servers[0].Name != "alice" || rep.Servers[0].Items != 4 {
+		t.Errorf("sources = %+v", rep.Servers)
+	}
+}
+
+func TestRunAggregate_Reports(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, out Output) string {
+		fn := filepath.Join(dir, name)
+		if err := writeJSONFile(fn, out, false); err != nil {
+			t.Fatal(err)
+		}
+		return fn
+	}
+	heat := CatalogItem{Title: "Heat", Year: 1995, IDs: []string{"plex://movie/heat"}, BestResolution: "1080"}
+	a := write("alice.json", Output{Catalog: []CatalogItem{heat}})
+	b := write("b.json", Output{Catalog: []CatalogItem{heat}})
+	noCat := write("nocat.json", Output{})
+
+	jsonOut, htmlOut := filepath.Join(dir, "agg.json"), filepath.Join(dir, "agg.html")
+	if rc := runAggregate([]string{"-json-out", jsonOut, "-html-out", htmlOut, a, "bob=" + b}); rc != 0 {
+		t.Fatalf("rc = %d", rc)
+	}
+	var rep AggregateReport
+	if err := readJSONFile(jsonOut, &rep); err != nil {
+		t.Fatal(err)
+	}
+	if len(rep.Titles) != 1 || len(rep.Titles[0].Servers) != 2 {
+		t.Fatalf("report = %+v", rep)
+	}
+	if _, ok := rep.Titles[0].Servers["bob"]; !ok {
+		t.Errorf("name=path argument not used: %+v", rep.Titles[0].Servers)
+	}
+	page, _ := os.ReadFile(htmlOut)
+	for _, want := range []string{"<th>alice</th>", "<th>bob</th>", "Heat (1995)", "1080p"} {
+		if !strings.Contains(string(page), want) {
+			t.Errorf("HTML missing %q", want)
+		}
+	}
+
+	if rc := runAggregate([]string{"-json-out", jsonOut, a, noCat}); rc != exitFatal {
+		t.Errorf("report without catalog: rc = %d, want %d", rc, exitFatal)
+	}
+	if rc := runAggregate([]string{"-index", "x.json", a}); rc != exitUsage {
+		t.Errorf("two sources: rc = %d, want %d", rc, exitUsage)
+	}
+}
@@ -4,13 +4,16 @@ import (
 	"context"
 	"crypto/tls"
 	"encoding/xml"
+	"errors"
 	"fmt"
 	"io"
 	"net"
 	"net/http"
 	"net/url"
 	"os"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 )
 
@@ -18,24 +21,45 @@ import (
 type mediaContainer struct {
 	XMLName   xml.Name    `xml:"MediaContainer"`
 	Size      int         `xml:"size,attr"`
+	TotalSize int         `xml:"totalSize,attr"` // when paging with X-Plex-Container-*
 	Directory []Directory `xml:"Directory"`
 	Video     []Video     `xml:"Video"`
 }
 
 type Directory struct {
-	Key   string `xml:"key,attr"`
-	Type  string `xml:"type,attr"`  // "movie", "show"
-	Title string `xml:"title,attr"` // e.g., "Movies"
+	Key      string     `xml:"key,attr"`
+	Type     string     `xml:"type,attr"`  // "movie", "show"
+	Title    string     `xml:"title,attr"` // e.g., "Movies"
+	Location []Location `xml:"Location"`   // the section's folders (Plex paths)
+}
+
+// Location is a folder of a library section.
+type Location struct {
+	Path string `xml:"path,attr"`
 }
 
 type Video struct {
 	RatingKey        string  `xml:"ratingKey,attr"`
 	Key              string  `xml:"key,attr"`
+	Type             string  `xml:"type,attr"` // "movie", "episode"
 	LibrarySectionID string  `xml:"librarySectionID,attr"`
 	Title            string  `xml:"title,attr"`
 	Year             int     `xml:"year,attr"`
 	Guid             string  `xml:"guid,attr"`
+	EditionTitle     string  `xml:"editionTitle,attr"` // e.g. "Director's Cut"
 	Media            []Media `xml:"Media"`
+	Guids            []Guid  `xml:"Guid"` // external IDs, with includeGuids=1
+
+	// Episode hierarchy (type="episode" only)
+	GrandparentRatingKey string `xml:"grandparentRatingKey,attr"` // show
+	GrandparentTitle     string `xml:"grandparentTitle,attr"`
+	ParentIndex          int    `xml:"parentIndex,attr"` // season number
+	Index                int    `xml:"index,attr"`       // episode number
+}
+
+// Guid is an external ID of an item, e.g. "imdb://tt0111161" or "tmdb://278".
+type Guid struct {
+	ID string `xml:"id,attr"`
 }
 
 type Media struct {
@@ -52,28 +76,67 @@ type Media struct {
 }
 
 type Part struct {
-	ID            string `xml:"id,attr"`
-	File          string `xml:"file,attr"`
-	Size          int64  `xml:"size,attr"`
-	Duration      int    `xml:"duration,attr"`
-	ExistsInt     int    `xml:"exists,attr"`     // with checkFiles=1
-	AccessibleInt int    `xml:"accessible,attr"` // with checkFiles=1
+	ID            string   `xml:"id,attr"`
+	File          string   `xml:"file,attr"`
+	Size          int64    `xml:"size,attr"`
+	Duration      int      `xml:"duration,attr"`
+	ExistsInt     int      `xml:"exists,attr"`     // with checkFiles=1
+	AccessibleInt int      `xml:"accessible,attr"` // with checkFiles=1
+	Stream        []Stream `xml:"Stream"`          // deep fetch only
+}
+
+// Stream is a video, audio or subtitle track of a part.
+type Stream struct {
+	ID                   string `xml:"id,attr"`
+	StreamType           int    `xml:"streamType,attr"` // 1 video, 2 audio, 3 subtitle
+	Codec                string `xml:"codec,attr"`
+	Profile              string `xml:"profile,attr"`
+	Default              int    `xml:"default,attr"`
+	Language             string `xml:"language,attr"`
+	LanguageCode         string `xml:"languageCode,attr"` // ISO 639-2, e.g. "eng"
+	Title                string `xml:"title,attr"`
+	DisplayTitle         string `xml:"displayTitle,attr"`
+	ExtendedDisplayTitle string `xml:"extendedDisplayTitle,attr"`
+	Key                  string `xml:"key,attr"` // external (sidecar) subtitles only
+
+	// Video
+	BitDepth       int    `xml:"bitDepth,attr"`
+	ColorTrc       string `xml:"colorTrc,attr"` // e.g. "smpte2084" (PQ), "arib-std-b67" (HLG), "bt709"
+	ColorPrimaries string `xml:"colorPrimaries,attr"`
+	DOVIPresent    int    `xml:"DOVIPresent,attr"`
+	DOVIProfile    int    `xml:"DOVIProfile,attr"`
+
+	// Audio
+	Channels           int    `xml:"channels,attr"`
+	AudioChannelLayout string `xml:"audioChannelLayout,attr"`
+
+	// Subtitle
+	Forced          int `xml:"forced,attr"`
+	HearingImpaired int `xml:"hearingImpaired,attr"`
 }
 
 // Client
 type Client struct {
-	base    *url.URL
-	token   string
-	http    *http.Client
-	verbose bool
-	timeout time.Duration
+	base         *url.URL
+	token        string
+	tokenInQuery bool // send the token in the URL instead of the X-Plex-Token header
+	http         *http.Client
+	verbose      bool
+	timeout      time.Duration
+	pageSize     int
+
+	retries      int
+	retryMaxWait time.Duration
+	retried      atomic.Int64 // retries performed, for the summary
 }
 
 // NewClient creates a Plex API client with the given options.
 func NewClient(o Options) (*Client, error) {
+	registerSecret(o.Token)
 	u, err := url.Parse(o.BaseURL)
 	if err != nil {
-		return nil, fmt.Errorf("parse base url: %w", err)
+		// url.Error quotes the whole URL, which may carry credentials
+		return nil, fmt.Errorf("parse base url: %s", redact(err.Error()))
 	}
 	tr := &http.Transport{
 		Proxy: http.ProxyFromEnvironment,
@@ -89,57 +152,244 @@ func NewClient(o Options) (*Client, error) {
 		ExpectContinueTimeout: 1 * time.Second,
 	}
 	return &Client{
-		base:  u,
-		token: o.Token,
+		base:         u,
+		token:        o.Token,
+		tokenInQuery: o.TokenInQuery,
 		http: &http.Client{
-			Transport: tr,
-			Timeout:   o.Timeout,
+			Transport:     tr,
+			Timeout:       o.Timeout,
+			CheckRedirect: dropTokenOffHost,
 		},
-		verbose: o.Verbose,
-		timeout: o.Timeout,
+		verbose:      o.Verbose,
+		timeout:      o.Timeout,
+		pageSize:     o.PageSize,
+		retries:      o.Retries,
+		retryMaxWait: o.RetryMaxWait,
 	}, nil
 }
 
-// buildURL constructs a full URL with the given path and query parameters, adding the auth token.
+// buildURL constructs a full URL with the given path and query parameters.
+// The token is only added with -token-in-query; otherwise do sends it as a header.
 func (c *Client) buildURL(path string, q url.Values) string {
 	u := *c.base
 	u.Path = strings.TrimRight(c.base.Path, "/") + path
 	if q == nil {
 		q = url.Values{}
 	}
-	q.Set("X-Plex-Token", c.token)
+	if c.tokenInQuery {
+		q.Set("X-Plex-Token", c.token)
+	}
 	u.RawQuery = q.Encode()
 	return u.String()
 }
 
+// dropTokenOffHost keeps the X-Plex-Token header from following a redirect
+// to another host (net/http only strips its own auth headers).
+func dropTokenOffHost(req *http.Request, via []*http.Request) error {
+	if len(via) >= 10 {
+		return errors.New("stopped after 10 redirects")
+	}
+	if req.URL.Host != via[0].URL.Host {
+		req.Header.Del("X-Plex-Token")
+	}
+	return nil
+}
+
 // getXML performs a GET request to the given URL and decodes the XML response into a mediaContainer.
+// Transient failures (network errors, 429 and 5xx, a body cut off partway) are
+// retried up to c.retries times.
 func (c *Client) getXML(ctx context.Context, rawURL string) (*mediaContainer, error) {
+	var mc *mediaContainer
+	err := c.withRetry(ctx, func() error {
+		resp, err := c.do(ctx, http.MethodGet, rawURL)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		var m mediaContainer
+		if err := xml.NewDecoder(resp.Body).Decode(&m); err != nil {
+			rerr := &RequestError{Endpoint: endpointOf(rawURL), StatusCode: resp.StatusCode, Err: fmt.Errorf("decode xml: %w", err)}
+			if retryableRead(err) && ctx.Err() == nil {
+				return &transientError{err: rerr}
+			}
+			return rerr
+		}
+		mc = &m
+		return nil
+	})
+	return mc, err
+}
+
+// send performs a request whose response body we don't need (PUT, DELETE, refresh triggers),
+// with the same retry behavior as getXML.
+func (c *Client) send(ctx context.Context, method, rawURL string) error {
+	return c.withRetry(ctx, func() error {
+		resp, err := c.do(ctx, method, rawURL)
+		if err != nil {
+			return err
+		}
+		return discard(resp)
+	})
+}
+
+// discard reads and closes a response body whose content isn't needed, so
+// the connection can be reused.
+func discard(resp *http.Response) error {
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	return resp.Body.Close()
+}
+
+// withRetry runs fn until it succeeds, fails permanently, or retries run out.
+// Only errors wrapped in *transientError are retried; the unwrapped error is returned.
+func (c *Client) withRetry(ctx context.Context, fn func() error) error {
+	for attempt := 0; ; attempt++ {
+		err := fn()
+		var te *transientError
+		if err == nil || !errors.As(err, &te) {
+			return err
+		}
+		if attempt >= c.retries || ctx.Err() != nil {
+			return te.err
+		}
+
+		wait := retryDelay(attempt, te.after, c.retryMaxWait)
+		c.retried.Add(1)
+		if c.verbose {
+			fmt.Fprintf(os.Stderr, "RETRY %d/%d in %s: %v\n", attempt+1, c.retries, wait.Round(time.Millisecond), te.err)
+		}
+		t := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+}
+
+// do performs a single request with the Plex client headers and returns the
+// response for a 2xx/3xx status. Failures are returned as *RequestError;
+// retryable ones are additionally wrapped in *transientError.
+func (c *Client) do(ctx context.Context, method, rawURL string) (*http.Response, error) {
 	if c.verbose {
-		fmt.Fprintln(os.Stderr, "GET", rawURL)
+		fmt.Fprintln(os.Stderr, method, redactURL(rawURL))
 	}
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	endpoint := endpointOf(rawURL)
+	req, err := http.NewRequestWithContext(ctx, method, rawURL, nil)
 	if err != nil {
-		return nil, err
+		return nil, &RequestError{Endpoint: endpoint, Err: err}
 	}
 	req.Header.Set("Accept", "application/xml")
 	req.Header.Set("X-Plex-Product", "goPlexr")
 	req.Header.Set("X-Plex-Version", "1.3")
 	req.Header.Set("X-Plex-Client-Identifier", "goPlexr-"+shortHost())
+	if !c.tokenInQuery {
+		req.Header.Set("X-Plex-Token", c.token)
+	}
 
 	resp, err := c.http.Do(req)
 	if err != nil {
-		return nil, err
+		// *url.Error repeats the full URL (token included); keep only the cause
+		var ue *url.Error
+		if errors.As(err, &ue) {
+			err = ue.Err
+		}
+		rerr := &RequestError{Endpoint: endpoint, Err: err}
+		if ctx.Err() != nil {
+			return nil, rerr
+		}
+		return nil, &transientError{err: rerr}
 	}
-	defer resp.Body.Close()
 	if resp.StatusCode >= 400 {
+		defer resp.Body.Close()
 		b, _ := io.ReadAll(io.LimitReader(resp.Body, 8<<10))
-		return nil, fmt.Errorf("plex http %d: %s", resp.StatusCode, strings.TrimSpace(string(b)))
+		rerr := &RequestError{
+			Endpoint:   endpoint,
+			StatusCode: resp.StatusCode,
+			Err:        fmt.Errorf("plex http %d: %s", resp.StatusCode, redact(strings.TrimSpace(string(b)))),
+		}
+		if retryableStatus(resp.StatusCode) {
+			return nil, &transientError{err: rerr, after: parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())}
+		}
+		return nil, rerr
+	}
+	return resp, nil
+}
+
+// RequestError is a failed Plex request. Endpoint is the URL path only, so
+// it is safe to log and store in reports.
+type RequestError struct {
+	Endpoint   string
+	StatusCode int // 0 if no HTTP response was received
+	Err        error
+}
+
+func (e *RequestError) Error() string { return e.Endpoint + ": " + redact(e.Err.Error()) }
+func (e *RequestError) Unwrap() error { return e.Err }
+
+// endpointOf returns the path of rawURL without query string or host.
+func endpointOf(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return u.Path
+}
+
+// getPaged calls fn for each page of a listing endpoint, using
+// X-Plex-Container-Start/Size and the container's totalSize to walk the
+// results. With a page size of 0 the whole listing is fetched at once.
+func (c *Client) getPaged(ctx context.Context, path string, q url.Values, fn func(*mediaContainer)) error {
+	if c.pageSize <= 0 {
+		mc, err := c.getXML(ctx, c.buildURL(path, q))
+		if err != nil {
+			return err
+		}
+		fn(mc)
+		return nil
 	}
-	var mc mediaContainer
-	if err := xml.NewDecoder(resp.Body).Decode(&mc); err != nil {
-		return nil, fmt.Errorf("decode xml: %w", err)
+
+	first := ""
+	for start := 0; ; {
+		pq := url.Values{}
+		for k, v := range q {
+			pq[k] = v
+		}
+		pq.Set("X-Plex-Container-Start", strconv.Itoa(start))
+		pq.Set("X-Plex-Container-Size", strconv.Itoa(c.pageSize))
+		mc, err := c.getXML(ctx, c.buildURL(path, pq))
+		if err != nil {
+			return err
+		}
+		// A server that ignores paging and omits totalSize sends the whole
+		// listing again for every page; it was all in the first one.
+		key := pageKey(mc)
+		if start == 0 {
+			first = key
+		} else if key != "" && key == first {
+			return nil
+		}
+		fn(mc)
+
+		n := len(mc.Video) + len(mc.Directory)
+		start += n
+		// Stop on an empty page, when totalSize says we're done, or when the
+		// server ignored paging (no totalSize) and sent a short page.
+		if n == 0 || (mc.TotalSize > 0 && start >= mc.TotalSize) || (mc.TotalSize == 0 && n < c.pageSize) {
+			return nil
+		}
 	}
-	return &mc, nil
+}
+
+// pageKey identifies a page of a listing by its first item.
+func pageKey(mc *mediaContainer) string {
+	switch {
+	case len(mc.Video) > 0:
+		return mc.Video[0].RatingKey
+	case len(mc.Directory) > 0:
+		return mc.Directory[0].Key
+	}
+	return ""
 }
 
 // shortHost returns a shortened hostname for use in the X-Plex-Client-Identifier header.
@@ -167,20 +417,46 @@ func (c *Client) DiscoverSections(ctx context.Context, includeShows bool) ([]Dir
 	return out, nil
 }
 
+// Plex metadata type for episodes. Show sections list <Directory> shows by
+// default, so we ask for the episodes directly to get at their Media.
+const plexTypeEpisode = "4"
+
 // FetchDuplicatesForSection fetches all items in the given section ID and returns those with multiple versions.
-func (c *Client) FetchDuplicatesForSection(ctx context.Context, id string) ([]Video, error) {
+// For show sections the items are episodes.
+func (c *Client) FetchDuplicatesForSection(ctx context.Context, id, secType string) ([]Video, error) {
 	q := url.Values{}
 	q.Set("duplicate", "1")
-	u := c.buildURL("/library/sections/"+id+"/all", q)
-	mc, err := c.getXML(ctx, u)
+	if secType == "show" {
+		q.Set("type", plexTypeEpisode)
+	}
+	var vids []Video
+	err := c.getPaged(ctx, "/library/sections/"+id+"/all", q, func(mc *mediaContainer) {
+		for _, v := range mc.Video {
+			if len(v.Media) > 1 {
+				vids = append(vids, v)
+			}
+		}
+	})
 	if err != nil {
 		return nil, err
 	}
+	return vids, nil
+}
+
+// FetchAllItems lists every item in a section (episodes for show sections),
+// with their external IDs and Media/Part info from the listing.
+func (c *Client) FetchAllItems(ctx context.Context, id, secType string) ([]Video, error) {
+	q := url.Values{}
+	q.Set("includeGuids", "1")
+	if secType == "show" {
+		q.Set("type", plexTypeEpisode)
+	}
 	var vids []Video
-	for _, v := range mc.Video {
-		if len(v.Media) > 1 {
-			vids = append(vids, v)
-		}
+	err := c.getPaged(ctx, "/library/sections/"+id+"/all", q, func(mc *mediaContainer) {
+		vids = append(vids, mc.Video...)
+	})
+	if err != nil {
+		return nil, err
 	}
 	return vids, nil
 }
@@ -198,12 +474,38 @@ func (c *Client) DeepFetchItem(ctx context.Context, ratingKey string, verify boo
 		return nil, err
 	}
 	if len(mc.Video) == 0 {
-		return nil, fmt.Errorf("no video for ratingKey %s", ratingKey)
+		return nil, &RequestError{Endpoint: endpointOf(u), Err: fmt.Errorf("no video for ratingKey %s", ratingKey)}
 	}
 	return &mc.Video[0], nil
 }
 
-// BaseURL returns the server base URL string exactly as configured.
+// Retries returns how many request retries the client has performed so far.
+func (c *Client) Retries() int64 {
+	return c.retried.Load()
+}
+
+// DeleteMedia removes one version (Media) of an item, including its files.
+// The server must have "Allow media deletion" enabled. A 404 on a retry
+// counts as success: the earlier attempt deleted it and only its response
+// was lost.
+func (c *Client) DeleteMedia(ctx context.Context, ratingKey, mediaID string) error {
+	u := c.buildURL("/library/metadata/"+ratingKey+"/media/"+mediaID, nil)
+	retry := false
+	return c.withRetry(ctx, func() error {
+		resp, err := c.do(ctx, http.MethodDelete, u)
+		if err != nil {
+			if retry && isNotFound(err) {
+				return nil
+			}
+			retry = true
+			return err
+		}
+		return discard(resp)
+	})
+}
+
+// BaseURL returns the server base URL as configured, minus any credentials
+// (user info or an X-Plex-Token parameter), so it can go into reports.
 func (c *Client) BaseURL() string {
-	return c.base.String()
+	return redactURL(c.base.String())
 }
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// pagedSectionHandler serves `total` duplicate items, honoring X-Plex-Container-Start/Size.
+func pagedSectionHandler(t *testing.T, total int, requests *int) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		*requests++
+		q := r.URL.Query()
+		start, _ := strconv.Atoi(q.Get("X-Plex-Container-Start"))
+		size, err := strconv.Atoi(q.Get("X-Plex-Container-Size"))
+		if err != nil {
+			t.Errorf("missing X-Plex-Container-Size: %q", r.URL.RawQuery)
+			size = total
+		}
+		var b strings.Builder
+		end := min(start+size, total)
+		fmt.Fprintf(&b, `<MediaContainer size="%d" totalSize="%d" offset="%d">`, max(end-start, 0), total, start)
+		for i := start; i < end; i++ {
+			fmt.Fprintf(&b, `<Video ratingKey="%d" title="M%d"><Media id="a%d"/><Media id="b%d"/></Video>`, i, i, i, i)
+		}
+		b.WriteString(`</MediaContainer>`)
+		_, _ = w.Write([]byte(b.String()))
+	}
+}
+
+func TestFetchDuplicatesForSection_Paged(t *testing.T) {
+	requests := 0
+	ts := httptest.NewServer(pagedSectionHandler(t, 7, &requests))
+	defer ts.Close()
+
+	pc, err := NewClient(Options{BaseURL: ts.URL, Token: "fake", Timeout: 5 * time.Second, PageSize: 3})
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	vids, err := pc.FetchDuplicatesForSection(context.Background(), "1", "movie")
+	if err != nil {
+		t.Fatalf("FetchDuplicatesForSection: %v", err)
+	}
+	if len(vids) != 7 {
+		t.Fatalf("expected 7 items across pages, got %d", len(vids))
+	}
+	for i, v := range vids {
+		if v.RatingKey != strconv.Itoa(i) {
+			t.Fatalf("item %d out of order: ratingKey %s", i, v.RatingKey)
+		}
+	}
+	if requests != 3 {
+		t.Fatalf("expected 3 page requests, got %d", requests)
+	}
+}
+
+func TestFetchAllItems_ServerIgnoresPaging(t *testing.T) {
+	requests := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests > 5 {
+			_, _ = w.Write([]byte(`<MediaContainer size="0"></MediaContainer>`)) // don't hang the test
+			return
+		}
+		var b strings.Builder
+		b.WriteString(`<MediaContainer size="5">`)
+		for i := 0; i < 5; i++ {
+			fmt.Fprintf(&b, `<Video ratingKey="%d" title="M%d"/>`, i, i)
+		}
+		b.WriteString(`</MediaContainer>`)
+		_, _ = w.Write([]byte(b.String()))
+	}))
+	defer ts.Close()
+
+	pc, err := NewClient(Options{BaseURL: ts.URL, Token: "fake", Timeout: 5 * time.Second, PageSize: 3})
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	vids, err := pc.FetchAllItems(context.Background(), "1", "movie")
+	if err != nil {
+		t.Fatalf("FetchAllItems: %v", err)
+	}
+	if len(vids) != 5 || requests != 2 {
+		t.Fatalf("got %d items in %d requests, want 5 in 2", len(vids), requests)
+	}
+}
+
+func TestGetXML_RetriesTransient(t *testing.T) {
+	calls := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls <= 2 {
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "busy", http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = w.Write([]byte(sectionsXML))
+	}))
+	defer ts.Close()
+
+	pc, err := NewClient(Options{BaseURL: ts.URL, Token: "fake", Timeout: 5 * time.Second, Retries: 3, RetryMaxWait: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	secs, err := pc.DiscoverSections(context.Background(), true)
+	if err != nil {
+		t.Fatalf("DiscoverSections: %v", err)
+	}
+	if len(secs) != 2 {
+		t.Fatalf("expected 2 sections after retries, got %d", len(secs))
+	}
+	if pc.Retries() != 2 {
+		t.Fatalf("expected 2 retries counted, got %d", pc.Retries())
+	}
+}
+
+func TestGetXML_RetriesCutOffBody(t *testing.T) {
+	calls := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			// headers and half the body, then the connection drops
+			w.Header().Set("Content-Length", strconv.Itoa(len(sectionsXML)))
+			w.WriteHeader(http.StatusOK)
+			_, _ = w.Write([]byte(sectionsXML[:len(sectionsXML)/2]))
+			w.(http.Flusher).Flush()
+			conn, _, err := w.(http.Hijacker).Hijack()
+			if err != nil {
+				t.Errorf("hijack: %v", err)
+				return
+			}
+			_ = conn.Close()
+			return
+		}
+		_, _ = w.Write([]byte(sectionsXML))
+	}))
+	defer ts.Close()
+
+	pc, err := NewClient(Options{BaseURL: ts.URL, Token: "fake", Timeout: 5 * time.Second, Retries: 3, RetryMaxWait: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	secs, err := pc.DiscoverSections(context.Background(), true)
+	if err != nil {
+		t.Fatalf("DiscoverSections: %v", err)
+	}
+	if len(secs) != 2 || calls != 2 || pc.Retries() != 1 {
+		t.Fatalf("got %d sections in %d calls / %d retries, want 2 in 2 / 1", len(secs), calls, pc.Retries())
+	}
+}
+
+func TestGetXML_NoRetryOnBadXML(t *testing.T) {
+	calls := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		_, _ = w.Write([]byte(`<MediaContainer><Directory></MediaContainer>`))
+	}))
+	defer ts.Close()
+
+	pc, err := NewClient(Options{BaseURL: ts.URL, Token: "fake", Timeout: 5 * time.Second, Retries: 3, RetryMaxWait: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	if _, err := pc.DiscoverSections(context.Background(), false); err == nil || calls != 1 {
+		t.Fatalf("expected one failed attempt for malformed XML, got %d calls, err %v", calls, err)
+	}
+}
+
+func TestGetXML_NoRetryOnClientError(t *testing.T) {
+	calls := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+	}))
+	defer ts.Close()
+
+	pc, err := NewClient(Options{BaseURL: ts.URL, Token: "bad", Timeout: 5 * time.Second, Retries: 3})
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	if _, err := pc.DiscoverSections(context.Background(), false); err == nil {
+		t.Fatalf("expected error for 401")
+	}
+	if calls != 1 || pc.Retries() != 0 {
+		t.Fatalf("expected a single attempt for 401, got %d calls / %d retries", calls, pc.Retries())
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	cases := []struct {
+		in   string
+		want time.Duration
+	}{
+		{"", 0},
+		{"7", 7 * time.Second},
+		{"-1", 0},
+		{"Mon, 01 Jan 2024 12:00:30 GMT", 30 * time.Second},
+		{"garbage", 0},
+	}
+	for _, c := range cases {
+		if got := parseRetryAfter(c.in, now); got != c.want {
+			t.Errorf("parseRetryAfter(%q) = %s, want %s", c.in, got, c.want)
+		}
+	}
+}
+
+func TestClient_TokenPlacement(t *testing.T) {
+	var header, query string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header, query = r.Header.Get("X-Plex-Token"), r.URL.Query().Get("X-Plex-Token")
+		_, _ = w.Write([]byte(`<MediaContainer size="0"></MediaContainer>`))
+	}))
+	defer ts.Close()
+
+	for _, inQuery := range []bool{false, true} {
+		pc, err := NewClient(Options{BaseURL: ts.URL, Token: "tok", Timeout: 5 * time.Second, TokenInQuery: inQuery})
+		if err != nil {
+			t.Fatalf("NewClient: %v", err)
+		}
+		if _, err := pc.DiscoverSections(context.Background(), false); err != nil {
+			t.Fatalf("DiscoverSections: %v", err)
+		}
+		if inQuery && (query != "tok" || header != "") {
+			t.Errorf("token-in-query: header = %q, query = %q", header, query)
+		}
+		if !inQuery && (header != "tok" || query != "") {
+			t.Errorf("default: header = %q, query = %q", header, query)
+		}
+	}
+}
+
+func TestClient_TokenNotSentOffHost(t *testing.T) {
+	var leaked string
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		leaked = r.Header.Get("X-Plex-Token")
+		_, _ = w.Write([]byte(`<MediaContainer size="0"></MediaContainer>`))
+	}))
+	defer other.Close()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, other.URL+r.URL.Path, http.StatusFound) // other port, other host
+	}))
+	defer ts.Close()
+
+	pc, err := NewClient(Options{BaseURL: ts.URL, Token: "tok", Timeout: 5 * time.Second})
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	if _, err := pc.DiscoverSections(context.Background(), false); err != nil {
+		t.Fatalf("DiscoverSections: %v", err)
+	}
+	if leaked != "" {
+		t.Errorf("token sent to redirect target on another host")
+	}
+}
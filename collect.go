@@ -2,38 +2,32 @@ package main
 
 import (
 	"context"
+	"errors"
+	"sort"
 	"strings"
 	"unicode"
 )
 
 // Run performs the main collection logic and returns the results.
 func RunCollection(ctx context.Context, pc *Client, o Options) (Output, error) {
-	// --- discover sections ---
-	var (
-		sections []Directory
-		err      error
-	)
+	policy, err := BuildPolicy(o)
+	if err != nil {
+		return Output{}, err
+	}
+	pathMap, err := ParsePathMap(o.PathMap)
+	if err != nil {
+		return Output{}, err
+	}
+	scoreModel, err := ParseScoreWeights(o.ScoreWeights)
+	if err != nil {
+		return Output{}, err
+	}
 
-	if o.SectionsCSV != "" {
-		for _, s := range strings.Split(o.SectionsCSV, ",") {
-			s = strings.TrimSpace(s)
-			if s == "" {
-				continue
-			}
-			sections = append(sections, Directory{
-				Key:   s,
-				Type:  "movie",
-				Title: "(manual " + s + ")",
-			})
-		}
-	} else {
-		sections, err = pc.DiscoverSections(ctx, o.IncludeShows)
-		if err != nil {
-			return Output{}, err
-		}
-		if len(sections) == 0 {
-			return Output{}, ErrNoSections
-		}
+	// --- discover sections ---
+	var scanErrs []ScanError
+	sections, err := scanSections(ctx, pc, o, &scanErrs)
+	if err != nil {
+		return Output{}, err
 	}
 
 	// --- collect and summarize ---
@@ -45,16 +39,47 @@ func RunCollection(ctx context.Context, pc *Client, o Options) (Output, error) {
 	totalItems := 0
 	totalVersions := 0
 	totalGhosts := 0
+	totalMismatches := 0
 	totalVariantsExcluded := 0
+	var totalReclaimable int64
+	storage := newStorageTally()
 
 	var libSummaries []LibrarySummary
 
-	for _, sec := range sections {
-		vids, err := pc.FetchDuplicatesForSection(ctx, sec.Key)
-		if err != nil {
-			// Skip this library on error; continue with others
+	// fetch all section listings up front, in parallel
+	listings := make([][]Video, len(sections))
+	listErrs := make([]error, len(sections))
+	err = runPool(ctx, o.Concurrency, len(sections), func(ctx context.Context, i int) {
+		listings[i], listErrs[i] = pc.FetchDuplicatesForSection(ctx, sections[i].Key, sections[i].Type)
+	})
+	if err != nil {
+		return Output{}, err
+	}
+
+	for si, sec := range sections {
+		if listErrs[si] != nil {
+			// Record and skip this library; continue with others
+			scanErrs = append(scanErrs, newScanError(sec.Key, "", listErrs[si]))
 			continue
 		}
+		vids := listings[si]
+
+		// deep fetch for parts and verification flags (if enabled)
+		deep := make([]*Video, len(vids))
+		if o.Deep {
+			deepErrs := make([]error, len(vids))
+			err = runPool(ctx, o.Concurrency, len(vids), func(ctx context.Context, i int) {
+				deep[i], deepErrs[i] = pc.DeepFetchItem(ctx, vids[i].RatingKey, o.Verify && !o.LocalVerify)
+			})
+			if err != nil {
+				return Output{}, err
+			}
+			for i, e := range deepErrs {
+				if e != nil {
+					scanErrs = append(scanErrs, newScanError(sec.Key, vids[i].RatingKey, e))
+				}
+			}
+		}
 
 		sectionRes := SectionResult{
 			SectionID:    sec.Key,
@@ -66,86 +91,65 @@ func RunCollection(ctx context.Context, pc *Client, o Options) (Output, error) {
 		secItemsWithGhosts := 0
 		secTotalVersions := 0
 		secVariantsExcluded := 0
+		var secReclaimable int64
+		secStorage := newStorageTally()
 
-		for _, v := range vids {
-			// deep fetch for parts and verification flags (if enabled)
-			var vv *Video
-			if o.Deep {
-				vv, err = pc.DeepFetchItem(ctx, v.RatingKey, o.Verify)
-				if err != nil {
-					vv = &v
-				}
-			} else {
+		for vi, v := range vids {
+			// fall back to the shallow listing entry if not deep fetched
+			vv := deep[vi]
+			if vv == nil {
 				vv = &v
 			}
 
-			item := Item{
-				RatingKey: vv.RatingKey,
-				Title:     fallback(vv.Title, v.Title),
-				Year:      vv.Year,
-				Guid:      vv.Guid,
-			}
+			item := newItem(vv, &v)
 
-			itemGhosts := 0
+			itemGhosts, itemMismatches := 0, 0
+			// without a deep fetch there's nothing to say whether the files exist
+			unverified := o.Verify && deep[vi] == nil
 
 			for _, m := range vv.Media {
-				ver := Version{
-					ID:              m.ID,
-					Container:       m.Container,
-					VideoCodec:      m.VideoCodec,
-					AudioCodec:      m.AudioCodec,
-					VideoResolution: m.VideoResolution,
-					Bitrate:         m.Bitrate,
-					Width:           m.Width,
-					Height:          m.Height,
+				ver := newVersion(m, fallback(vv.EditionTitle, v.EditionTitle))
+				if unverified {
+					for pi := range ver.Parts {
+						ver.Parts[pi].Unverified = true
+					}
+				} else if o.LocalVerify {
+					for pi := range ver.Parts {
+						verifyLocal(&ver.Parts[pi], pathMap)
+					}
 				}
 
-				// build parts first, and detect if this entire version is in an Extras folder
-				versionGhosts := 0
+				// detect ghost parts, and if this entire version is in an Extras folder
+				versionGhosts, versionMismatches := 0, 0
 				versionIsExtra := false
-				for _, p := range m.Part {
+				for _, p := range ver.Parts {
 					if o.IgnoreExtras && isExtraPath(p.File) {
 						versionIsExtra = true
 					}
-
-					exists := p.ExistsInt == 1
-					accessible := p.AccessibleInt == 1
-					verified := exists && accessible
-
-					ver.Parts = append(ver.Parts, PartOut{
-						ID:             p.ID,
-						File:           p.File,
-						Size:           p.Size,
-						Duration:       p.Duration,
-						VerifiedOnDisk: verified,
-						Exists:         exists,
-						Accessible:     accessible,
-					})
-
-					if o.Verify && !verified {
+					if partIsGhost(p, o.Verify) {
 						versionGhosts++
 					}
+					if p.SizeMismatch {
+						versionMismatches++
+					}
 				}
 
 				// If ignoring extras and this version lives under Extras/Featurettes store it and skip it
 				if o.IgnoreExtras && versionIsExtra {
 					// Record this dropped version as an ignored Extra
+					extra := newItem(vv, &v)
+					extra.Versions = []Version{ver}
 					ignored = append(ignored, IgnoredItem{
 						SectionID:    sec.Key,
 						SectionTitle: sec.Title,
-						Reason:       "extra_version",
-						Item: Item{
-							RatingKey: vv.RatingKey,
-							Title:     fallback(vv.Title, v.Title),
-							Year:      vv.Year,
-							Guid:      vv.Guid,
-							Versions:  []Version{ver},
-						},
+						Reason:       reasonExtraVersion,
+						Item:         extra,
 					})
 					continue // skip adding this version to the item
 				}
 
 				itemGhosts += versionGhosts
+				itemMismatches += versionMismatches
 				item.Versions = append(item.Versions, ver)
 			}
 
@@ -155,27 +159,42 @@ func RunCollection(ctx context.Context, pc *Client, o Options) (Output, error) {
 				continue
 			}
 
-			// Ignore EXACT 4K+HD pair (only that case)
-			if shouldExcludeAs4kHdPair(item, o.DupPolicy) {
+			// Ignore intentional sets of versions (e.g. exact 4K+HD pair) per policy
+			d := decideItem(policy, &item, o.EditionAware)
+			item.Flags = d.Flags
+			if d.Ignore {
 				secVariantsExcluded++
 				ignored = append(ignored, IgnoredItem{
 					SectionID:    sec.Key,
 					SectionTitle: sec.Title,
-					Reason:       "4k+hd_pair",
+					Reason:       d.Reason,
+					Rule:         d.Rule,
 					Item:         item,
 				})
 				continue
 			}
 
+			item.Recommendation = recommendItem(&item, scoreModel, o.Verify)
+			if item.Recommendation != nil {
+				secReclaimable += item.Recommendation.ReclaimableBytes
+			}
+
 			// Count only kept items
 			secTotalVersions += len(item.Versions)
 			if itemGhosts > 0 {
 				secItemsWithGhosts++
 			}
 			secGhostParts += itemGhosts
+			totalMismatches += itemMismatches
+			secStorage.add(item, sec.Location, o.Verify)
+			storage.add(item, sec.Location, o.Verify)
 			sectionRes.Items = append(sectionRes.Items, item)
 		}
 
+		if sec.Type == "show" {
+			sectionRes.Shows = groupEpisodes(sectionRes.Items)
+		}
+
 		libSummaries = append(libSummaries, LibrarySummary{
 			SectionID:        sec.Key,
 			SectionTitle:     sec.Title,
@@ -185,63 +204,266 @@ func RunCollection(ctx context.Context, pc *Client, o Options) (Output, error) {
 			GhostParts:       secGhostParts,
 			ItemsWithGhosts:  secItemsWithGhosts,
 			VariantsExcluded: secVariantsExcluded,
+			ReclaimableBytes: secReclaimable,
+			Storage:          secStorage.result(),
 		})
 
 		totalItems += len(sectionRes.Items)
 		totalVersions += secTotalVersions
 		totalGhosts += secGhostParts
 		totalVariantsExcluded += secVariantsExcluded
+		totalReclaimable += secReclaimable
 
 		out.Sections = append(out.Sections, sectionRes)
 	}
 
+	// full listings for split and cross-library duplicates, the catalog, orphans and hashing
+	var refs []hashRef
+	if o.SplitDups || o.CrossLibrary || o.Catalog || o.Orphans || o.Hash {
+		all, err := fetchAllListings(ctx, pc, o, sections, &scanErrs)
+		if err != nil {
+			return Output{}, err
+		}
+		if o.SplitDups {
+			for i, sec := range sections {
+				if all[i] != nil {
+					out.SplitDuplicates = append(out.SplitDuplicates, findSplitDuplicates(sec, all[i], o.EditionAware)...)
+				}
+			}
+		}
+		if o.CrossLibrary {
+			out.CrossLibrary = findCrossLibrary(sections, all, policy, scoreModel, o.EditionAware)
+		}
+		if o.Catalog {
+			out.Catalog = buildCatalog(sections, all)
+		}
+		if o.Orphans {
+			out.Orphans = findOrphans(ctx, sections, all, pathMap, &scanErrs)
+		}
+		if o.Hash {
+			for i, sec := range sections {
+				refs = append(refs, partRefs(sec, all[i], pathMap)...)
+			}
+			out.Identical, err = hashIdentical(ctx, o, refs, &scanErrs)
+			if err != nil {
+				return Output{}, err
+			}
+			markIdentical(out.Identical, outputItems(&out, ignored)...)
+		}
+	}
+	crossLibraryGroups := 0
+	for _, g := range out.CrossLibrary {
+		if !g.Ignored {
+			crossLibraryGroups++
+		}
+	}
+
+	if o.FixGhosts {
+		out.GhostCleanup = cleanupGhosts(ctx, pc, o, pathMap, sections, out.Sections, ignored, &scanErrs)
+	}
+
+	var orphanBytes, identicalBytes int64
+	for _, f := range out.Orphans {
+		orphanBytes += f.Size
+	}
+	for _, g := range out.Identical {
+		identicalBytes += g.RedundantBytes
+	}
+
 	out.TotalItems = totalItems
 	out.TotalVersions = totalVersions
 	out.TotalGhosts = totalGhosts
 	out.Summary = Summary{
 		VerificationPerformed: o.Verify,
+		LocalVerification:     o.Verify && o.LocalVerify,
+		SizeMismatchParts:     totalMismatches,
+		OrphanFiles:           len(out.Orphans),
+		OrphanBytes:           orphanBytes,
+		IdenticalGroups:       len(out.Identical),
+		IdenticalBytes:        identicalBytes,
 		TotalLibraries:        len(out.Sections),
 		TotalDuplicateItems:   totalItems,
 		TotalGhostParts:       totalGhosts,
 		DuplicatePolicy:       o.DupPolicy,
+		PolicyFile:            o.PolicyFile,
+		ReclaimableBytes:      totalReclaimable,
 		VariantItemsExcluded:  totalVariantsExcluded,
+		SplitDuplicateGroups:  len(out.SplitDuplicates),
+		CrossLibraryGroups:    crossLibraryGroups,
+		HTTPRetries:           pc.Retries(),
+		Degraded:              len(scanErrs) > 0,
+		Storage:               storage.result(),
 		Libraries:             libSummaries,
 	}
 	out.Ignored = ignored
+	out.Errors = scanErrs
+	out.hashRefs = refs
 
 	return out, nil
 }
 
-// ErrNoSections is returned when no movie/show sections are found.
-var ErrNoSections = &noSectionsErr{}
+// scanSections returns the sections to scan: the -sections list, or every
+// movie (and with -include-shows, show) section on the server.
+func scanSections(ctx context.Context, pc *Client, o Options, errs *[]ScanError) ([]Directory, error) {
+	if o.SectionsCSV != "" {
+		sections, lookupErr := manualSections(ctx, pc, o.SectionsCSV)
+		if lookupErr != nil {
+			*errs = append(*errs, newScanError("", "", lookupErr))
+		}
+		return sections, nil
+	}
+	sections, err := pc.DiscoverSections(ctx, o.IncludeShows)
+	if err != nil {
+		return nil, err
+	}
+	if len(sections) == 0 {
+		return nil, ErrNoSections
+	}
+	return sections, nil
+}
 
-type noSectionsErr struct{}
+// manualSections turns the -sections list into Directory entries. Titles and
+// types are looked up on the server so show sections get scanned as shows; if
+// the lookup fails each ID is treated as a movie section and the lookup error
+// is returned alongside the sections.
+func manualSections(ctx context.Context, pc *Client, csv string) ([]Directory, error) {
+	known := map[string]Directory{}
+	all, err := pc.DiscoverSections(ctx, true)
+	for _, d := range all {
+		known[d.Key] = d
+	}
 
-func (*noSectionsErr) Error() string { return "no movie/show sections found" }
+	var sections []Directory
+	for _, s := range strings.Split(csv, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		if d, ok := known[s]; ok {
+			sections = append(sections, d)
+			continue
+		}
+		sections = append(sections, Directory{
+			Key:   s,
+			Type:  "movie",
+			Title: "(manual " + s + ")",
+		})
+	}
+	return sections, err
+}
 
-// Exclude when there are exactly two versions and they are:
-// one 4K (2160) + one HD-ish (1080 or 720, including mislabels).
-func shouldExcludeAs4kHdPair(it Item, policy string) bool {
-	if strings.ToLower(policy) != "ignore-4k-1080" {
-		return false // 'plex' or anything else: keep Plex behavior
+// newScanError describes a failed request for the report.
+func newScanError(sectionID, ratingKey string, err error) ScanError {
+	se := ScanError{
+		SectionID: sectionID,
+		RatingKey: ratingKey,
+		Message:   redact(err.Error()),
 	}
-	if len(it.Versions) != 2 {
-		return false
+	var re *RequestError
+	if errors.As(err, &re) {
+		se.Endpoint = re.Endpoint
+		se.HTTPStatus = re.StatusCode
+		se.Message = redact(re.Err.Error())
+	}
+	return se
+}
+
+// newItem builds the output Item header (no versions) from a fetched video,
+// using the shallow listing entry to fill in anything the deep fetch lacks.
+func newItem(vv, v *Video) Item {
+	it := Item{
+		RatingKey: vv.RatingKey,
+		Type:      fallback(vv.Type, v.Type),
+		Title:     fallback(vv.Title, v.Title),
+		Year:      vv.Year,
+		Guid:      vv.Guid,
 	}
-	v1, v2 := it.Versions[0], it.Versions[1]
-	is4k1 := normalizeResKey(v1) == "2160"
-	is4k2 := normalizeResKey(v2) == "2160"
+	if it.Type == "episode" {
+		it.ShowRatingKey = fallback(vv.GrandparentRatingKey, v.GrandparentRatingKey)
+		it.ShowTitle = fallback(vv.GrandparentTitle, v.GrandparentTitle)
+		it.Season = fallback(vv.ParentIndex, v.ParentIndex)
+		it.Episode = fallback(vv.Index, v.Index)
+	}
+	return it
+}
 
-	// One must be 4K, the other must be HD-ish
-	if is4k1 && isHDVersion(v2) {
-		return true
+// newVersion converts a Plex Media element (and its parts) to an output
+// Version. editionTitle is the item's, if Plex has one.
+func newVersion(m Media, editionTitle string) Version {
+	ver := Version{
+		ID:              m.ID,
+		Container:       m.Container,
+		VideoCodec:      m.VideoCodec,
+		AudioCodec:      m.AudioCodec,
+		VideoResolution: m.VideoResolution,
+		Bitrate:         m.Bitrate,
+		Width:           m.Width,
+		Height:          m.Height,
 	}
-	if is4k2 && isHDVersion(v1) {
-		return true
+	for _, p := range m.Part {
+		exists := p.ExistsInt == 1
+		accessible := p.AccessibleInt == 1
+		ver.Parts = append(ver.Parts, PartOut{
+			ID:             p.ID,
+			File:           p.File,
+			Size:           p.Size,
+			Duration:       p.Duration,
+			VerifiedOnDisk: exists && accessible,
+			Exists:         exists,
+			Accessible:     accessible,
+		})
 	}
-	return false
+	newStreams(&ver, m)
+	ver.Edition = detectEdition(editionTitle, ver)
+	return ver
 }
 
+// groupEpisodes groups duplicate episodes by show and season, sorted by show
+// title then season number. Episodes keep their order within a season.
+func groupEpisodes(items []Item) []ShowGroup {
+	var shows []ShowGroup
+	idx := map[string]int{}
+	for _, it := range items {
+		key := fallback(it.ShowRatingKey, it.ShowTitle)
+		i, ok := idx[key]
+		if !ok {
+			i = len(shows)
+			idx[key] = i
+			shows = append(shows, ShowGroup{RatingKey: it.ShowRatingKey, Title: it.ShowTitle})
+		}
+		sg := &shows[i]
+		j := -1
+		for k := range sg.Seasons {
+			if sg.Seasons[k].Season == it.Season {
+				j = k
+				break
+			}
+		}
+		if j == -1 {
+			sg.Seasons = append(sg.Seasons, SeasonGroup{Season: it.Season})
+			j = len(sg.Seasons) - 1
+		}
+		sg.Seasons[j].Episodes = append(sg.Seasons[j].Episodes, it.RatingKey)
+	}
+
+	sort.SliceStable(shows, func(a, b int) bool {
+		return strings.ToLower(shows[a].Title) < strings.ToLower(shows[b].Title)
+	})
+	for i := range shows {
+		sort.SliceStable(shows[i].Seasons, func(a, b int) bool {
+			return shows[i].Seasons[a].Season < shows[i].Seasons[b].Season
+		})
+	}
+	return shows
+}
+
+// ErrNoSections is returned when no movie/show sections are found.
+var ErrNoSections = &noSectionsErr{}
+
+type noSectionsErr struct{}
+
+func (*noSectionsErr) Error() string { return "no movie/show sections found" }
+
 // isHDVersion returns true for 1080/720, including common mislabels.
 // It avoids classifying typical SD (720x480, 720x576) as HD.
 func isHDVersion(v Version) bool {
@@ -107,3 +107,118 @@ func TestCollectRun_WithMockPlex(t *testing.T) {
 		t.Fatalf("expected duplicate policy to be preserved in summary")
 	}
 }
+
+// show section listing with one duplicate episode (type=4 listing)
+const showEpisodesXML = `<?xml version="1.0"?>
+<MediaContainer>
+  <Video ratingKey="200" type="episode" title="Pilot" grandparentRatingKey="20" grandparentTitle="Some Show" parentIndex="1" index="1">
+    <Media id="m3" videoResolution="1080" container="mkv" width="1920" height="1080">
+      <Part id="p3" file="/tv/Some Show/S01E01.mkv" size="1000" exists="1" accessible="1" />
+    </Media>
+    <Media id="m4" videoResolution="1080" container="mp4" width="1920" height="1080">
+      <Part id="p4" file="/tv/Some Show/S01E01 copy.mp4" size="900" exists="1" accessible="1" />
+    </Media>
+  </Video>
+</MediaContainer>`
+
+func TestCollectRun_ShowSectionEpisodes(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/library/sections", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(sectionsXML))
+	})
+	mux.HandleFunc("/library/sections/2/all", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("type") != "4" {
+			t.Errorf("expected episode listing (type=4), got %q", r.URL.RawQuery)
+		}
+		_, _ = w.Write([]byte(showEpisodesXML))
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	o := Options{
+		BaseURL:     ts.URL,
+		Token:       "fake",
+		SectionsCSV: "2",
+		DupPolicy:   "ignore-4k-1080",
+		Timeout:     5 * time.Second,
+	}
+	pc, err := NewClient(o)
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	out, err := RunCollection(context.Background(), pc, o)
+	if err != nil {
+		t.Fatalf("RunCollection: %v", err)
+	}
+
+	if len(out.Sections) != 1 || out.Sections[0].Type != "show" || out.Sections[0].SectionTitle != "Shows" {
+		t.Fatalf("expected manual section 2 resolved to the Shows library, got %+v", out.Sections)
+	}
+	sec := out.Sections[0]
+	if len(sec.Items) != 1 {
+		t.Fatalf("expected 1 duplicate episode, got %d", len(sec.Items))
+	}
+	it := sec.Items[0]
+	if it.Type != "episode" || it.ShowTitle != "Some Show" || it.Season != 1 || it.Episode != 1 {
+		t.Fatalf("episode fields not populated: %+v", it)
+	}
+	if len(sec.Shows) != 1 || len(sec.Shows[0].Seasons) != 1 || sec.Shows[0].Seasons[0].Episodes[0] != "200" {
+		t.Fatalf("expected episode grouped under show/season, got %+v", sec.Shows)
+	}
+}
+
+func TestCollectRun_RecordsErrors(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/library/sections", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`<MediaContainer>
+  <Directory key="1" type="movie" title="Movies" />
+  <Directory key="3" type="movie" title="Broken" />
+</MediaContainer>`))
+	})
+	mux.HandleFunc("/library/sections/1/all", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(duplicatesXML))
+	})
+	mux.HandleFunc("/library/sections/3/all", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "nope", http.StatusNotFound)
+	})
+	mux.HandleFunc("/library/metadata/100", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "boom", http.StatusInternalServerError)
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	o := Options{
+		BaseURL:   ts.URL,
+		Token:     "fake",
+		Deep:      true,
+		Verify:    true,
+		DupPolicy: "plex",
+		Timeout:   5 * time.Second,
+	}
+	pc, err := NewClient(o)
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	out, err := RunCollection(context.Background(), pc, o)
+	if err != nil {
+		t.Fatalf("RunCollection: %v", err)
+	}
+
+	if !out.Summary.Degraded {
+		t.Fatalf("expected degraded summary")
+	}
+	if len(out.Errors) != 2 {
+		t.Fatalf("expected 2 errors (section + item), got %+v", out.Errors)
+	}
+	itemErr, secErr := out.Errors[0], out.Errors[1] // in section order
+	if secErr.SectionID != "3" || secErr.HTTPStatus != 404 || secErr.Endpoint != "/library/sections/3/all" {
+		t.Fatalf("unexpected section error: %+v", secErr)
+	}
+	if itemErr.SectionID != "1" || itemErr.RatingKey != "100" || itemErr.HTTPStatus != 500 {
+		t.Fatalf("unexpected item error: %+v", itemErr)
+	}
+	// item still reported from shallow data
+	if out.TotalItems != 1 {
+		t.Fatalf("expected the shallow item to be kept, got %d items", out.TotalItems)
+	}
+}
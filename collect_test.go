@@ -33,19 +33,19 @@ func TestShouldExcludeAs4k1080Pair(t *testing.T) {
 			{VideoResolution: "1080"},
 		},
 	}
-	if !shouldExcludeAs4kHdPair(it, "ignore-4k-1080") {
+	if !policyIgnores(t, "ignore-4k-1080", it) {
 		t.Fatalf("expected exclusion for exact 4k+1080 pair")
 	}
 
 	// if an extra version exists, do not exclude
 	it.Versions = append(it.Versions, Version{VideoResolution: "720"})
-	if shouldExcludeAs4kHdPair(it, "ignore-4k-1080") {
+	if policyIgnores(t, "ignore-4k-1080", it) {
 		t.Fatalf("did not expect exclusion when extra versions present")
 	}
 
 	// non-matching policy should not exclude
 	it.Versions = []Version{{VideoResolution: "2160"}, {VideoResolution: "1080"}}
-	if shouldExcludeAs4kHdPair(it, "plex") {
+	if policyIgnores(t, "plex", it) {
 		t.Fatalf("did not expect exclusion under 'plex' policy")
 	}
 }
@@ -57,7 +57,7 @@ func TestShouldExcludeAs4kHdPair_Mislabeled720(t *testing.T) {
 			{Width: 720, Height: 388, VideoResolution: "sd"}, // mislabel
 		},
 	}
-	if !shouldExcludeAs4kHdPair(item, "ignore-4k-1080") {
+	if !policyIgnores(t, "ignore-4k-1080", item) {
 		t.Fatalf("expected true for 4k + sd(720x388) pair")
 	}
 
@@ -68,7 +68,17 @@ func TestShouldExcludeAs4kHdPair_Mislabeled720(t *testing.T) {
 			{Width: 720, Height: 480, VideoResolution: "sd"},
 		},
 	}
-	if shouldExcludeAs4kHdPair(itemBad, "ignore-4k-1080") {
+	if policyIgnores(t, "ignore-4k-1080", itemBad) {
 		t.Fatalf("expected false for 4k + sd(720x480)")
 	}
 }
+
+// policyIgnores reports whether the named -dup-policy value ignores it.
+func policyIgnores(t *testing.T, policy string, it Item) bool {
+	t.Helper()
+	ps, err := ParsePolicies(policy)
+	if err != nil {
+		t.Fatalf("ParsePolicies(%q): %v", policy, err)
+	}
+	return ps.Decide(it).Ignore
+}
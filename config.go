@@ -0,0 +1,494 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Settings files hold flag values, so cron lines don't have to. A file is a
+// small subset of TOML: top-level `key = value` pairs apply to every run and
+// [profiles.NAME] tables override them when selected with -profile (or
+// `profile = "NAME"` at the top level). Keys are flag names; values are
+// strings, integers, booleans or arrays (joined with commas):
+//
+//	url = "http://plex:32400"
+//	include-shows = true
+//	dup-policy = ["ignore-4k-1080", "ignore-3d-2d"]
+//
+//	[profiles.nightly]
+//	quiet = true
+//	html-out = "/srv/reports/plex.html"
+//
+// Precedence: command-line flag > GOPLEXR_* environment variable > profile
+// > top-level value > flag default.
+
+// configFileName is looked up in $XDG_CONFIG_HOME/goplexr (or ~/.config/goplexr).
+const configFileName = "config.toml"
+
+// flagAliases maps short alias flags to the flag they share a variable with.
+var flagAliases = map[string]string{"V": "verbose", "v": "version"}
+
+// tokenFlags are the token sources. They act as one setting: the highest
+// level (flag, environment, settings file) that gives any of them wins, so
+// -token-file isn't combined with a PLEX_TOKEN or a token in the file.
+var tokenFlags = []string{"token", "token-file", "token-cmd"}
+
+// legacyEnv are environment variables read before GOPLEXR_* existed.
+var legacyEnv = map[string]string{"url": "PLEX_URL", "token": "PLEX_TOKEN"}
+
+// envName is the environment variable for a flag: GOPLEXR_INCLUDE_SHOWS for -include-shows.
+func envName(flagName string) string {
+	return "GOPLEXR_" + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// defaultConfigPath returns $XDG_CONFIG_HOME/goplexr/config.toml, falling
+// back to ~/.config when XDG_CONFIG_HOME is unset.
+func defaultConfigPath() string {
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		dir = filepath.Join(home, ".config")
+	}
+	return filepath.Join(dir, "goplexr", configFileName)
+}
+
+// addConfigFlags defines -config and -profile.
+func addConfigFlags(fs *flag.FlagSet, path, profile *string) {
+	fs.StringVar(path, "config", "", "Settings file (default $XDG_CONFIG_HOME/goplexr/"+configFileName+" if it exists). Env: GOPLEXR_CONFIG")
+	fs.StringVar(profile, "profile", "", "Profile from the settings file to apply. Env: GOPLEXR_PROFILE")
+}
+
+// applySettings fills every flag of fs that was not given on the command
+// line from its GOPLEXR_* variable, then from the settings file (profile
+// first, then top-level values). Call it after fs.Parse. Keys in the file
+// that are valid flags of another command are ignored; unknown keys are errors.
+// The token sources are resolved together (see tokenFlags).
+func applySettings(fs *flag.FlagSet, path, profile string) error {
+	explicit := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+		if to, ok := flagAliases[f.Name]; ok {
+			explicit[to] = true
+		}
+	})
+
+	values, err := loadSettings(path, profile)
+	if err != nil {
+		return err
+	}
+
+	tokenFlag, tokenEnv := false, false
+	for _, name := range tokenFlags {
+		v, _, env := envValue(name)
+		tokenFlag, tokenEnv = tokenFlag || explicit[name], tokenEnv || env && v != ""
+	}
+
+	var errs []error
+	fs.VisitAll(func(f *flag.Flag) {
+		if explicit[f.Name] || f.Name == "config" || f.Name == "profile" || flagAliases[f.Name] != "" {
+			return
+		}
+		isToken := slices.Contains(tokenFlags, f.Name)
+		if isToken && tokenFlag {
+			return
+		}
+		if v, src, ok := envValue(f.Name); ok {
+			if err := fs.Set(f.Name, v); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", src, err))
+			}
+			return
+		}
+		if isToken && tokenEnv {
+			return
+		}
+		if v, ok := values[f.Name]; ok {
+			if err := fs.Set(f.Name, v.value); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %s: %w", v.where, f.Name, err))
+			}
+		}
+	})
+	return errors.Join(errs...)
+}
+
+// envValue returns the GOPLEXR_* (or legacy PLEX_*) value for a flag.
+func envValue(flagName string) (value, source string, ok bool) {
+	if v, ok := os.LookupEnv(envName(flagName)); ok {
+		return v, envName(flagName), true
+	}
+	if legacy, ok := legacyEnv[flagName]; ok {
+		if v, ok := os.LookupEnv(legacy); ok {
+			return v, legacy, true
+		}
+	}
+	return "", "", false
+}
+
+// settingValue is a value from the settings file and where it came from.
+type settingValue struct {
+	value string
+	where string // "file:line"
+}
+
+// loadSettings reads the settings file and returns the values for profile
+// (top-level values overridden by the profile's). path and profile fall back
+// to GOPLEXR_CONFIG / GOPLEXR_PROFILE. A missing default file is not an
+// error; a missing explicit one is.
+func loadSettings(path, profile string) (map[string]settingValue, error) {
+	if profile == "" {
+		profile = os.Getenv("GOPLEXR_PROFILE")
+	}
+	path, explicit := settingsPath(path)
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		if !explicit && errors.Is(err, os.ErrNotExist) {
+			if profile != "" {
+				return nil, fmt.Errorf("profile %q requested but there is no settings file (%s)", profile, path)
+			}
+			return nil, nil
+		}
+		return nil, err
+	}
+	doc, err := parseTOML(path, string(b))
+	if err != nil {
+		return nil, err
+	}
+
+	known := knownSettingKeys()
+	for table, kv := range doc {
+		for k, v := range kv {
+			if !known[k] {
+				return nil, fmt.Errorf("%s: unknown setting %q in %s", v.where, k, tableName(table))
+			}
+		}
+	}
+
+	values := map[string]settingValue{}
+	for k, v := range doc[""] {
+		if k == "profile" {
+			if profile == "" {
+				profile = v.value
+			}
+			continue
+		}
+		values[k] = v
+	}
+	if profile != "" {
+		p, ok := doc["profiles."+profile]
+		if !ok {
+			return nil, fmt.Errorf("%s: no [profiles.%s] (have: %s)", path, profile, strings.Join(profileNames(doc), ", "))
+		}
+		// a token source in the profile replaces the top-level ones, as a
+		// profile's own value would
+		for _, name := range tokenFlags {
+			if _, ok := p[name]; ok {
+				for _, n := range tokenFlags {
+					delete(values, n)
+				}
+				break
+			}
+		}
+		for k, v := range p {
+			values[k] = v
+		}
+	}
+	return values, nil
+}
+
+// settingsPath returns the settings file to use: path, else GOPLEXR_CONFIG,
+// else the default location. explicit reports whether it was asked for.
+func settingsPath(path string) (string, bool) {
+	if path == "" {
+		path = os.Getenv("GOPLEXR_CONFIG")
+	}
+	if path != "" {
+		return path, true
+	}
+	return defaultConfigPath(), false
+}
+
+// knownSettingKeys are the flag names of every command that reads settings,
+// plus the top-level "profile" selector.
+func knownSettingKeys() map[string]bool {
+	var o Options
+	fs := flag.NewFlagSet("", flag.ContinueOnError)
+	addConnFlags(fs, &o)
+	addScanFlags(fs, &o)
+	addOutputFlags(fs, &o)
+	known := map[string]bool{"profile": true}
+	fs.VisitAll(func(f *flag.Flag) {
+		if flagAliases[f.Name] == "" {
+			known[f.Name] = true
+		}
+	})
+	return known
+}
+
+func tableName(t string) string {
+	if t == "" {
+		return "top level"
+	}
+	return "[" + t + "]"
+}
+
+func profileNames(doc map[string]map[string]settingValue) []string {
+	var names []string
+	for t := range doc {
+		if name, ok := strings.CutPrefix(t, "profiles."); ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// parseTOML parses the TOML subset described above into table -> key ->
+// value. Underscores in keys are read as dashes (include_shows = include-shows).
+func parseTOML(filename, src string) (map[string]map[string]settingValue, error) {
+	doc := map[string]map[string]settingValue{"": {}}
+	table := ""
+	for n, line := range strings.Split(src, "\n") {
+		where := fmt.Sprintf("%s:%d", filename, n+1)
+		line = strings.TrimSpace(stripComment(line))
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "[") {
+			if !strings.HasSuffix(line, "]") || strings.HasPrefix(line, "[[") {
+				return nil, fmt.Errorf("%s: bad table header %q", where, line)
+			}
+			table = strings.TrimSpace(line[1 : len(line)-1])
+			if name, ok := strings.CutPrefix(table, "profiles."); !ok || name == "" {
+				return nil, fmt.Errorf("%s: unknown table [%s] (use [profiles.NAME])", where, table)
+			}
+			if _, dup := doc[table]; dup {
+				return nil, fmt.Errorf("%s: table [%s] defined twice", where, table)
+			}
+			doc[table] = map[string]settingValue{}
+			continue
+		}
+
+		k, raw, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("%s: expected key = value", where)
+		}
+		k = strings.ReplaceAll(strings.Trim(strings.TrimSpace(k), `"`), "_", "-")
+		if k == "" {
+			return nil, fmt.Errorf("%s: empty key", where)
+		}
+		v, err := parseTOMLValue(strings.TrimSpace(raw))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s: %w", where, k, err)
+		}
+		if _, dup := doc[table][k]; dup {
+			return nil, fmt.Errorf("%s: %s set twice", where, k)
+		}
+		doc[table][k] = settingValue{value: v, where: where}
+	}
+	return doc, nil
+}
+
+// stripComment removes a trailing # comment that is not inside a string.
+func stripComment(line string) string {
+	inStr := byte(0)
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case inStr != 0 && c == '\\' && inStr == '"':
+			i++
+		case inStr != 0 && c == inStr:
+			inStr = 0
+		case inStr == 0 && (c == '"' || c == '\''):
+			inStr = c
+		case inStr == 0 && c == '#':
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// parseTOMLValue returns a value in flag syntax.
+func parseTOMLValue(raw string) (string, error) {
+	switch {
+	case raw == "":
+		return "", errors.New("missing value")
+	case raw[0] == '"':
+		s, err := strconv.Unquote(raw)
+		if err != nil {
+			return "", fmt.Errorf("bad string %s", raw)
+		}
+		return s, nil
+	case raw[0] == '\'':
+		if len(raw) < 2 || raw[len(raw)-1] != '\'' || strings.Contains(raw[1:len(raw)-1], "'") {
+			return "", fmt.Errorf("bad literal string %s", raw)
+		}
+		return raw[1 : len(raw)-1], nil
+	case raw[0] == '[':
+		if raw[len(raw)-1] != ']' {
+			return "", errors.New("arrays must be on one line")
+		}
+		var items []string
+		for _, el := range splitTOMLArray(raw[1 : len(raw)-1]) {
+			v, err := parseTOMLValue(el)
+			if err != nil {
+				return "", err
+			}
+			items = append(items, v)
+		}
+		return strings.Join(items, ","), nil
+	case raw == "true" || raw == "false":
+		return raw, nil
+	}
+	if _, err := strconv.ParseFloat(strings.ReplaceAll(raw, "_", ""), 64); err == nil {
+		return strings.ReplaceAll(raw, "_", ""), nil
+	}
+	return "", fmt.Errorf("unsupported value %s (quote strings)", raw)
+}
+
+// splitTOMLArray splits the inside of a one-line array at top-level commas.
+func splitTOMLArray(s string) []string {
+	var (
+		out   []string
+		start int
+		inStr byte
+	)
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case inStr == '"' && c == '\\':
+			i++
+		case inStr != 0 && c == inStr:
+			inStr = 0
+		case inStr == 0 && (c == '"' || c == '\''):
+			inStr = c
+		case inStr == 0 && c == ',':
+			out = append(out, strings.TrimSpace(s[start:i]))
+			start = i + 1
+		}
+	}
+	if last := strings.TrimSpace(s[start:]); last != "" {
+		out = append(out, last)
+	}
+	return out
+}
+
+// saveSettings sets keys in the settings file at path (top level, or
+// [profiles.PROFILE]) and deletes the keys in unset from the same table,
+// creating the file if needed. Other lines, comments included, are kept.
+// The file is written with mode 0600 since it may hold a token.
+func saveSettings(path, profile string, set map[string]string, unset ...string) error {
+	b, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	src := strings.TrimRight(string(b), "\n")
+	if _, err := parseTOML(path, src); err != nil {
+		return err
+	}
+	target := ""
+	if profile != "" {
+		target = "profiles." + profile
+	}
+	drop := map[string]bool{}
+	for _, k := range unset {
+		drop[k] = true
+	}
+
+	var lines []string
+	if src != "" {
+		lines = strings.Split(src, "\n")
+	}
+	done := map[string]bool{}
+	table, found, insertAt := "", target == "", -1
+	var out []string
+	for _, line := range lines {
+		trimmed := strings.TrimSpace(stripComment(line))
+		if strings.HasPrefix(trimmed, "[") {
+			if table == target && insertAt < 0 {
+				insertAt = lastContentLine(out) + 1
+			}
+			table = strings.TrimSpace(strings.Trim(trimmed, "[]"))
+			found = found || table == target
+			out = append(out, line)
+			continue
+		}
+		if k, _, ok := strings.Cut(trimmed, "="); ok && table == target {
+			k = strings.ReplaceAll(strings.Trim(strings.TrimSpace(k), `"`), "_", "-")
+			if v, ok := set[k]; ok {
+				out = append(out, k+" = "+strconv.Quote(v))
+				done[k] = true
+				continue
+			}
+			if drop[k] {
+				continue
+			}
+		}
+		out = append(out, line)
+	}
+	if table == target && insertAt < 0 {
+		insertAt = lastContentLine(out) + 1
+	}
+
+	var add []string
+	for k, v := range set {
+		if !done[k] {
+			add = append(add, k+" = "+strconv.Quote(v))
+		}
+	}
+	sort.Strings(add)
+	switch {
+	case !found:
+		if len(out) > 0 {
+			out = append(out, "")
+		}
+		out = append(out, "["+target+"]")
+		out = append(out, add...)
+	case len(add) > 0:
+		out = append(out[:insertAt], append(add, out[insertAt:]...)...)
+	}
+
+	text := strings.Join(out, "\n") + "\n"
+	if _, err := parseTOML(path, text); err != nil {
+		return fmt.Errorf("updated settings would not parse: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".config-*.toml")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.WriteString(text); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// lastContentLine returns the index of the last non-blank, non-comment line
+// of lines, or -1. New keys go right after it, so they stay in their table.
+func lastContentLine(lines []string) int {
+	for i := len(lines) - 1; i >= 0; i-- {
+		if strings.TrimSpace(stripComment(lines[i])) != "" {
+			return i
+		}
+	}
+	return -1
+}
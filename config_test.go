@@ -0,0 +1,235 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseTOML(t *testing.T) {
+	src := `# goPlexr settings
+url = "http://plex:32400" # trailing comment
+include_shows = true
+concurrency = 8
+dup-policy = ["ignore-4k-1080", 'ignore-3d-2d']
+html-out = "C:\\reports\\#1.html"
+
+[profiles.nightly]
+quiet = true
+timeout = "45s"
+`
+	doc, err := parseTOML("config.toml", src)
+	if err != nil {
+		t.Fatalf("parseTOML: %v", err)
+	}
+	want := map[string]string{
+		"url":           "http://plex:32400",
+		"include-shows": "true",
+		"concurrency":   "8",
+		"dup-policy":    "ignore-4k-1080,ignore-3d-2d",
+		"html-out":      `C:\reports\#1.html`,
+	}
+	for k, v := range want {
+		if got := doc[""][k].value; got != v {
+			t.Errorf("%s = %q, want %q", k, got, v)
+		}
+	}
+	if got := doc["profiles.nightly"]["timeout"]; got.value != "45s" || got.where != "config.toml:10" {
+		t.Errorf("profile timeout = %+v", got)
+	}
+}
+
+func TestParseTOML_Errors(t *testing.T) {
+	cases := map[string]string{
+		"no value":        "url =",
+		"bare string":     "url = http://plex",
+		"no equals":       "url",
+		"unknown table":   "[servers]",
+		"empty profile":   "[profiles.]",
+		"array of tables": "[[profiles.a]]",
+		"duplicate key":   "quiet = true\nquiet = false",
+		"duplicate table": "[profiles.a]\n[profiles.a]",
+		"multiline array": "sections = [1,\n2]",
+	}
+	for name, src := range cases {
+		if _, err := parseTOML("c.toml", src); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+// unsetenv unsets environment variables for the duration of the test.
+func unsetenv(t *testing.T, keys ...string) {
+	t.Helper()
+	for _, k := range keys {
+		t.Setenv(k, "") // restores the old value after the test
+		os.Unsetenv(k)
+	}
+}
+
+// newTestFlags returns a flag set like Parse's, parsed from args.
+func newTestFlags(t *testing.T, args ...string) (*flag.FlagSet, *Options, string, string) {
+	t.Helper()
+	var o Options
+	var configPath, profile string
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	addConfigFlags(fs, &configPath, &profile)
+	addConnFlags(fs, &o)
+	addScanFlags(fs, &o)
+	addOutputFlags(fs, &o)
+	if err := fs.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	return fs, &o, configPath, profile
+}
+
+func TestApplySettings_Precedence(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", dir)
+	unsetenv(t, "GOPLEXR_CONFIG", "GOPLEXR_PROFILE", "PLEX_URL", "PLEX_TOKEN")
+	cfg := filepath.Join(dir, "goplexr", configFileName)
+	if err := os.MkdirAll(filepath.Dir(cfg), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	err := os.WriteFile(cfg, []byte(`
+url = "http://top:32400"
+token = "top-token"
+concurrency = 2
+page-size = 100
+timeout = "5s"
+
+[profiles.nightly]
+url = "http://profile:32400"
+concurrency = 3
+include-shows = true
+`), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("GOPLEXR_CONCURRENCY", "6")
+	t.Setenv("GOPLEXR_PAGE_SIZE", "200")
+	t.Setenv("PLEX_TOKEN", "legacy-token")
+
+	fs, o, path, profile := newTestFlags(t, "-profile", "nightly", "-page-size", "300", "-V")
+	if err := applySettings(fs, path, profile); err != nil {
+		t.Fatalf("applySettings: %v", err)
+	}
+	if o.PageSize != 300 {
+		t.Errorf("flag should win: page-size = %d", o.PageSize)
+	}
+	if o.Concurrency != 6 {
+		t.Errorf("env should beat profile: concurrency = %d", o.Concurrency)
+	}
+	if o.BaseURL != "http://profile:32400" || !o.IncludeShows {
+		t.Errorf("profile should beat top level: url = %s, include-shows = %v", o.BaseURL, o.IncludeShows)
+	}
+	if o.Token != "legacy-token" {
+		t.Errorf("PLEX_TOKEN should beat the file: token = %s", o.Token)
+	}
+	if o.Timeout != 5*time.Second {
+		t.Errorf("top-level value should beat default: timeout = %s", o.Timeout)
+	}
+	if o.Retries != 2 || !o.Verbose {
+		t.Errorf("defaults/aliases: retries = %d, verbose = %v", o.Retries, o.Verbose)
+	}
+}
+
+func TestApplySettings_Errors(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", dir)
+	unsetenv(t, "GOPLEXR_CONFIG", "GOPLEXR_PROFILE")
+	write := func(body string) string {
+		fn := filepath.Join(t.TempDir(), "c.toml")
+		if err := os.WriteFile(fn, []byte(body), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return fn
+	}
+
+	// no default file: fine without a profile, an error with one
+	fs, _, _, _ := newTestFlags(t)
+	if err := applySettings(fs, "", ""); err != nil {
+		t.Errorf("no settings file: %v", err)
+	}
+	fs, _, _, _ = newTestFlags(t)
+	if err := applySettings(fs, "", "nightly"); err == nil {
+		t.Errorf("expected an error for a profile without a settings file")
+	}
+
+	cases := map[string]struct{ body, profile string }{
+		"unknown key":     {"colour = true", ""},
+		"missing profile": {"url = \"x\"\n[profiles.a]\n", "b"},
+		"bad value":       {"concurrency = \"many\"", ""},
+	}
+	for name, c := range cases {
+		fs, _, _, _ := newTestFlags(t)
+		err := applySettings(fs, write(c.body), c.profile)
+		if err == nil {
+			t.Errorf("%s: expected an error", name)
+		} else if name == "bad value" && !strings.Contains(err.Error(), "c.toml:1") {
+			t.Errorf("%s: error should name the line: %v", name, err)
+		}
+	}
+
+	t.Setenv("GOPLEXR_TIMEOUT", "soon")
+	fs, _, _, _ = newTestFlags(t)
+	if err := applySettings(fs, "", ""); err == nil || !strings.Contains(err.Error(), "GOPLEXR_TIMEOUT") {
+		t.Errorf("bad env value: %v", err)
+	}
+}
+
+func TestApplySettings_DefaultProfile(t *testing.T) {
+	unsetenv(t, "GOPLEXR_PROFILE")
+	fn := filepath.Join(t.TempDir(), "c.toml")
+	_ = os.WriteFile(fn, []byte("profile = \"b\"\n[profiles.a]\nretries = 1\n[profiles.b]\nretries = 5\n"), 0o600)
+
+	fs, o, _, _ := newTestFlags(t)
+	if err := applySettings(fs, fn, ""); err != nil || o.Retries != 5 {
+		t.Errorf("default profile: retries = %d, err = %v", o.Retries, err)
+	}
+	fs, o, _, _ = newTestFlags(t)
+	if err := applySettings(fs, fn, "a"); err != nil || o.Retries != 1 {
+		t.Errorf("-profile should override the file's profile: retries = %d, err = %v", o.Retries, err)
+	}
+}
+
+func TestApplySettings_TokenSources(t *testing.T) {
+	unsetenv(t, "GOPLEXR_CONFIG", "GOPLEXR_PROFILE", "GOPLEXR_TOKEN", "GOPLEXR_TOKEN_FILE", "GOPLEXR_TOKEN_CMD", "PLEX_TOKEN")
+	fn := filepath.Join(t.TempDir(), "c.toml")
+	_ = os.WriteFile(fn, []byte("token = \"file-token\"\n"), 0o600)
+
+	// a flag beats the environment and the file, even as a different source
+	t.Setenv("PLEX_TOKEN", "env-token")
+	fs, o, _, _ := newTestFlags(t, "-token-file", "/run/secrets/plex")
+	if err := applySettings(fs, fn, ""); err != nil {
+		t.Fatal(err)
+	}
+	if o.Token != "" || o.TokenFile != "/run/secrets/plex" || checkTokenSources(*o) != nil {
+		t.Errorf("flag: token = %q, token-file = %q", o.Token, o.TokenFile)
+	}
+
+	// the environment beats the file
+	unsetenv(t, "PLEX_TOKEN")
+	t.Setenv("GOPLEXR_TOKEN_CMD", "pass plex")
+	fs, o, _, _ = newTestFlags(t)
+	if err := applySettings(fs, fn, ""); err != nil {
+		t.Fatal(err)
+	}
+	if o.Token != "" || o.TokenCmd != "pass plex" || checkTokenSources(*o) != nil {
+		t.Errorf("env: token = %q, token-cmd = %q", o.Token, o.TokenCmd)
+	}
+
+	// two sources at the same level are still an error
+	unsetenv(t, "GOPLEXR_TOKEN_CMD")
+	fs, o, _, _ = newTestFlags(t, "-token", "abcd", "-token-cmd", "pass plex")
+	if err := applySettings(fs, fn, ""); err != nil {
+		t.Fatal(err)
+	}
+	if checkTokenSources(*o) == nil {
+		t.Errorf("two token flags accepted")
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"sort"
+	"strings"
+)
+
+// Cross-library duplicates are copies of one title in different sections
+// (e.g. "Movies" and "4K Movies"). Each section is scanned in isolation, so
+// they're found by joining the full listings of all scanned sections on
+// GUIDs and external IDs. Titles aren't used here: across libraries a
+// title+year match is too often a remake or a different cut.
+
+// findCrossLibrary joins the listings (indexed like sections; nil for failed
+// sections) and returns the groups that span two or more sections. Each
+// group's versions are run through the policy and keep-best scoring as one
+// item, so e.g. ignore-4k-1080 treats a 4K library + HD library pair as
+// intentional. With editionAware, only versions of the same edition count
+// against each other.
+func findCrossLibrary(sections []Directory, listings [][]Video, policy Policy, m ScoreModel, editionAware bool) []CrossLibraryGroup {
+	type ref struct{ sec, vid int }
+	var (
+		refs []ref
+		keys [][]string
+	)
+	for si, vids := range listings {
+		for vi := range vids {
+			var ks []string
+			if g := normalizeGuid(vids[vi].Guid); g != "" {
+				ks = append(ks, g)
+			}
+			ks = append(ks, externalIDs(&vids[vi])...)
+			refs = append(refs, ref{si, vi})
+			keys = append(keys, ks)
+		}
+	}
+
+	var groups []CrossLibraryGroup
+	for _, kg := range joinByKeys(keys) {
+		secs := map[string]bool{}
+		for _, i := range kg.members {
+			secs[sections[refs[i].sec].Key] = true
+		}
+		if len(secs) < 2 {
+			continue // a split duplicate within one section, not cross-library
+		}
+
+		g := CrossLibraryGroup{MatchedOn: kg.shared}
+		for n, i := range kg.members {
+			sec, v := sections[refs[i].sec], &listings[refs[i].sec][refs[i].vid]
+			g.Sections = append(g.Sections, CrossLibrarySection{
+				SectionID:    sec.Key,
+				SectionTitle: sec.Title,
+				RatingKey:    v.RatingKey,
+			})
+			it := listedItem(v)
+			if n == 0 {
+				g.Item = it
+				g.Item.Versions = nil
+			}
+			g.Item.ExternalIDs = appendMissing(g.Item.ExternalIDs, it.ExternalIDs...)
+			for _, ver := range it.Versions {
+				ver.SectionID, ver.Library = sec.Key, sec.Title
+				g.Item.Versions = append(g.Item.Versions, ver)
+			}
+		}
+
+		d := decideItem(policy, &g.Item, editionAware)
+		g.Item.Flags = d.Flags
+		if d.Ignore {
+			g.Ignored, g.Reason, g.Rule = true, d.Reason, d.Rule
+		} else {
+			// listings carry no checkFiles info, so there's nothing to verify
+			g.Item.Recommendation = recommendItem(&g.Item, m, false)
+		}
+		groups = append(groups, g)
+	}
+
+	sort.SliceStable(groups, func(a, b int) bool {
+		return strings.ToLower(itemLabel(groups[a].Item)) < strings.ToLower(itemLabel(groups[b].Item))
+	})
+	return groups
+}
+
+// appendMissing appends the values not already in s.
+func appendMissing(s []string, vals ...string) []string {
+	for _, v := range vals {
+		found := false
+		for _, x := range s {
+			if x == v {
+				found = true
+				break
+			}
+		}
+		if !found {
+			s = append(s, v)
+		}
+	}
+	return s
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func crossLibFixture() ([]Directory, [][]Video) {
+	sections := []Directory{{Key: "1", Title: "Movies"}, {Key: "3", Title: "4K Movies"}}
+	listings := [][]Video{
+		{
+			{RatingKey: "10", Type: "movie", Title: "Heat", Year: 1995, Guid: "plex://movie/heat", Guids: []Guid{{ID: "imdb://tt0113277"}},
+				Media: []Media{{ID: "m10", VideoResolution: "1080", Width: 1920, Height: 1080, Part: []Part{{ID: "p10", File: "/movies/Heat.mkv", Size: 1000}}}}},
+			{RatingKey: "11", Type: "movie", Title: "Alien", Year: 1979, Guid: "plex://movie/alien",
+				Media: []Media{{ID: "m11", VideoResolution: "1080", Width: 1920, Height: 1080, Part: []Part{{ID: "p11", File: "/movies/Alien.mkv", Size: 1000}}}}},
+			{RatingKey: "12", Type: "movie", Title: "Up", Year: 2009, Guid: "plex://movie/up",
+				Media: []Media{{ID: "m12", VideoResolution: "1080", Width: 1920, Height: 1080, Part: []Part{{ID: "p12", File: "/movies/Up.mkv", Size: 1000}}}}},
+		},
+		{
+			// matched by another agent, joined via imdb id
+			{RatingKey: "30", Type: "movie", Title: "Heat", Year: 1995, Guid: "com.plexapp.agents.themoviedb://949?lang=en", Guids: []Guid{{ID: "imdb://tt0113277"}},
+				Media: []Media{{ID: "m30", VideoResolution: "4k", Width: 3840, Height: 2160, Part: []Part{{ID: "p30", File: "/4k/Heat.mkv", Size: 5000}}}}},
+			{RatingKey: "31", Type: "movie", Title: "Alien", Year: 1979, Guid: "plex://movie/alien",
+				Media: []Media{{ID: "m31", VideoResolution: "720", Width: 1280, Height: 720, Part: []Part{{ID: "p31", File: "/4k/Alien.mkv", Size: 700}}}}},
+			{RatingKey: "32", Type: "movie", Title: "Up", Year: 2009, Guid: "local://32",
+				Media: []Media{{ID: "m32", VideoResolution: "4k", Width: 3840, Height: 2160, Part: []Part{{ID: "p32", File: "/4k/Up.mkv", Size: 5000}}}}},
+		},
+	}
+	return sections, listings
+}
+
+func TestFindCrossLibrary(t *testing.T) {
+	sections, listings := crossLibFixture()
+	policy, _ := ParsePolicies("plex")
+	groups := findCrossLibrary(sections, listings, policy, DefaultScoreModel, true)
+	if len(groups) != 2 {
+		t.Fatalf("groups = %d, want 2 (Up has no shared GUID): %+v", len(groups), groups)
+	}
+
+	alien, heat := groups[0], groups[1]
+	if heat.Item.Title != "Heat" || !reflect.DeepEqual(heat.MatchedOn, []string{"imdb://tt0113277"}) {
+		t.Errorf("heat group = %+v", heat)
+	}
+	wantSecs := []CrossLibrarySection{{"1", "Movies", "10"}, {"3", "4K Movies", "30"}}
+	if !reflect.DeepEqual(heat.Sections, wantSecs) {
+		t.Errorf("sections = %+v, want %+v", heat.Sections, wantSecs)
+	}
+	if v := heat.Item.Versions[1]; v.Library != "4K Movies" || v.SectionID != "3" {
+		t.Errorf("version library = %q/%q", v.SectionID, v.Library)
+	}
+	if heat.Item.Recommendation == nil || heat.Item.Recommendation.Keep != "m30" {
+		t.Errorf("expected the 4K copy to be kept: %+v", heat.Item.Recommendation)
+	}
+
+	if alien.Item.Title != "Alien" || alien.Ignored {
+		t.Errorf("alien group = %+v", alien)
+	}
+}
+
+func TestFindCrossLibrary_PolicyIgnores4kHdLibraries(t *testing.T) {
+	sections, listings := crossLibFixture()
+	policy, _ := ParsePolicies("ignore-4k-1080")
+	groups := findCrossLibrary(sections, listings, policy, DefaultScoreModel, true)
+
+	byTitle := map[string]CrossLibraryGroup{}
+	for _, g := range groups {
+		byTitle[g.Item.Title] = g
+	}
+	if g := byTitle["Heat"]; !g.Ignored || g.Reason != "4k+hd_pair" || g.Item.Recommendation != nil {
+		t.Errorf("4K library + HD library pair should be ignored: %+v", g)
+	}
+	if g := byTitle["Alien"]; g.Ignored {
+		t.Errorf("1080+720 pair should not be ignored: %+v", g)
+	}
+}
+
+func TestRuleLibraryMatcher(t *testing.T) {
+	r := Rule{Name: "uhd-library", Action: ruleActionIgnore, Versions: []VersionMatcher{
+		{Library: []string{"4k movies"}},
+		{Library: []string{"1"}},
+	}}
+	it := Item{Versions: []Version{
+		{SectionID: "1", Library: "Movies"},
+		{SectionID: "3", Library: "4K Movies"},
+	}}
+	if !r.Match(it) {
+		t.Errorf("library matcher should match by title and by ID")
+	}
+	it.Versions[1].Library = "Kids"
+	if r.Match(it) {
+		t.Errorf("library matcher matched the wrong library")
+	}
+}
+
+func TestCollectRun_CrossLibrary(t *testing.T) {
+	const movies = `<MediaContainer size="1" totalSize="1">
+  <Video ratingKey="10" type="movie" title="Heat" year="1995" guid="plex://movie/heat">
+    <Media id="m10" videoResolution="1080" width="1920" height="1080"><Part id="p10" file="/movies/Heat.mkv" size="1000" /></Media>
+  </Video>
+</MediaContainer>`
+	const uhd = `<MediaContainer size="1" totalSize="1">
+  <Video ratingKey="30" type="movie" title="Heat" year="1995" guid="plex://movie/heat">
+    <Media id="m30" videoResolution="1080" width="1920" height="800"><Part id="p30" file="/4k/Heat.mkv" size="3000" /></Media>
+  </Video>
+</MediaContainer>`
+	empty := `<MediaContainer size="0" totalSize="0"></MediaContainer>`
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/library/sections", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`<MediaContainer><Directory key="1" type="movie" title="Movies" /><Directory key="3" type="movie" title="4K Movies" /></MediaContainer>`))
+	})
+	serve := func(body string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Query().Get("duplicate") == "1" {
+				_, _ = w.Write([]byte(empty))
+				return
+			}
+			_, _ = w.Write([]byte(body))
+		}
+	}
+	mux.HandleFunc("/library/sections/1/all", serve(movies))
+	mux.HandleFunc("/library/sections/3/all", serve(uhd))
+
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	o := Options{BaseURL: ts.URL, Token: "fake", CrossLibrary: true, DupPolicy: "ignore-4k-1080", Timeout: 5 * time.Second}
+	pc, err := NewClient(o)
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	out, err := RunCollection(context.Background(), pc, o)
+	if err != nil {
+		t.Fatalf("RunCollection: %v", err)
+	}
+	if len(out.CrossLibrary) != 1 || out.Summary.CrossLibraryGroups != 1 {
+		t.Fatalf("cross-library = %d groups (%d counted), want 1", len(out.CrossLibrary), out.Summary.CrossLibraryGroups)
+	}
+	g := out.CrossLibrary[0]
+	if g.Ignored || len(g.Item.Versions) != 2 || g.Item.Recommendation.Keep != "m30" {
+		t.Errorf("unexpected group: %+v", g)
+	}
+	if len(out.SplitDuplicates) != 0 {
+		t.Errorf("split duplicates reported without -split-dups")
+	}
+}
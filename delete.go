@@ -0,0 +1,342 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// DeletePlan is the reviewed list of versions to delete. It is signed so
+// that `delete -confirm` executes exactly what the dry run printed.
+type DeletePlan struct {
+	Server    string         `json:"server"`
+	Created   time.Time      `json:"created"`
+	Source    string         `json:"source,omitempty"` // report the plan was built from
+	Actions   []DeleteAction `json:"actions"`
+	Signature string         `json:"signature,omitempty"`
+}
+
+// DeleteAction removes one version (Plex Media) of one item.
+type DeleteAction struct {
+	SectionID string   `json:"section_id"`
+	RatingKey string   `json:"rating_key"`
+	Title     string   `json:"title"`
+	MediaID   string   `json:"media_id"`
+	Keep      string   `json:"keep"` // the version kept instead (must still be there)
+	Files     []string `json:"files"`
+	Bytes     int64    `json:"bytes"`
+	Reasons   []string `json:"reasons,omitempty"`
+}
+
+// ErrPlanSignature is returned when a plan was edited after it was written,
+// or was signed for a different token.
+var ErrPlanSignature = errors.New("plan signature mismatch: the plan was modified or belongs to another server/token; rebuild it")
+
+// BuildDeletePlan collects the keep-best removal candidates from a report.
+// If rule is set, only items flagged by that -policy-file rule are included;
+// if sectionID is set, only that library.
+func BuildDeletePlan(out Output, rule, sectionID string) DeletePlan {
+	plan := DeletePlan{Server: out.Server, Created: time.Now().UTC().Truncate(time.Second)}
+	for _, sec := range out.Sections {
+		if sectionID != "" && sec.SectionID != sectionID {
+			continue
+		}
+		for _, it := range sec.Items {
+			if it.Recommendation == nil || (rule != "" && !hasFlag(it, rule)) {
+				continue
+			}
+			for _, c := range it.Recommendation.CandidatesForRemoval {
+				v, ok := findVersion(it, c.VersionID)
+				if !ok || c.VersionID == "" {
+					continue
+				}
+				a := DeleteAction{
+					SectionID: sec.SectionID,
+					RatingKey: it.RatingKey,
+					Title:     itemLabel(it),
+					MediaID:   c.VersionID,
+					Keep:      it.Recommendation.Keep,
+					Bytes:     c.Bytes,
+					Reasons:   c.Reasons,
+				}
+				for _, p := range v.Parts {
+					a.Files = append(a.Files, p.File)
+				}
+				plan.Actions = append(plan.Actions, a)
+			}
+		}
+	}
+	return plan
+}
+
+// Sign sets the plan's HMAC-SHA256 signature, keyed with the Plex token.
+func (p *DeletePlan) Sign(token string) error {
+	sig, err := p.mac(token)
+	if err != nil {
+		return err
+	}
+	p.Signature = sig
+	return nil
+}
+
+// Verify checks the plan's signature against token.
+func (p *DeletePlan) Verify(token string) error {
+	want, err := p.mac(token)
+	if err != nil {
+		return err
+	}
+	if !hmac.Equal([]byte(want), []byte(p.Signature)) {
+		return ErrPlanSignature
+	}
+	return nil
+}
+
+// mac signs the plan's JSON encoding without the signature field.
+func (p *DeletePlan) mac(token string) (string, error) {
+	unsigned := *p
+	unsigned.Signature = ""
+	b, err := json.Marshal(unsigned)
+	if err != nil {
+		return "", err
+	}
+	m := hmac.New(sha256.New, []byte(token))
+	m.Write(b)
+	return hex.EncodeToString(m.Sum(nil)), nil
+}
+
+// TotalBytes sums the size of every action.
+func (p *DeletePlan) TotalBytes() int64 {
+	var n int64
+	for _, a := range p.Actions {
+		n += a.Bytes
+	}
+	return n
+}
+
+// Print writes the plan in a human-readable form.
+func (p *DeletePlan) Print(w io.Writer) {
+	fmt.Fprintf(w, "Deletion plan for %s (%d versions, %s)\n", p.Server, len(p.Actions), BytesHuman(p.TotalBytes()))
+	for _, a := range p.Actions {
+		fmt.Fprintf(w, "  DELETE %-40s section=%s ratingKey=%s media=%s keep=%s %s\n", a.Title, a.SectionID, a.RatingKey, a.MediaID, a.Keep, BytesHuman(a.Bytes))
+		for _, f := range a.Files {
+			fmt.Fprintf(w, "         %s\n", f)
+		}
+		if len(a.Reasons) > 0 {
+			fmt.Fprintf(w, "         why: %s\n", strings.Join(a.Reasons, "; "))
+		}
+	}
+}
+
+// ExecuteDeletePlan deletes every action in order. Before each delete the item
+// is re-fetched to make sure the version still exists, is not the item's last
+// one and the version kept instead is still there with its files. It returns the number of versions deleted and the failures.
+func ExecuteDeletePlan(ctx context.Context, pc *Client, plan DeletePlan, log io.Writer) (int, []ScanError) {
+	var (
+		done int
+		errs []ScanError
+	)
+	for _, a := range plan.Actions {
+		if err := ctx.Err(); err != nil {
+			errs = append(errs, newScanError(a.SectionID, a.RatingKey, err))
+			break
+		}
+		if err := checkDeletable(ctx, pc, a); err != nil {
+			errs = append(errs, newScanError(a.SectionID, a.RatingKey, err))
+			fmt.Fprintf(log, "SKIP   %s media=%s: %v\n", a.Title, a.MediaID, err)
+			continue
+		}
+		if err := pc.DeleteMedia(ctx, a.RatingKey, a.MediaID); err != nil {
+			errs = append(errs, newScanError(a.SectionID, a.RatingKey, err))
+			fmt.Fprintf(log, "FAILED %s media=%s: %v\n", a.Title, a.MediaID, err)
+			continue
+		}
+		done++
+		fmt.Fprintf(log, "DELETED %s media=%s (%s)\n", a.Title, a.MediaID, BytesHuman(a.Bytes))
+	}
+	return done, errs
+}
+
+// checkDeletable refuses to delete a version that is gone, an item's only
+// version, or a version whose keeper is gone or a ghost: a stale plan must
+// not remove the only good copy.
+func checkDeletable(ctx context.Context, pc *Client, a DeleteAction) error {
+	if a.Keep == "" || a.Keep == a.MediaID {
+		return fmt.Errorf("the plan names no other version of item %s to keep; rebuild it", a.RatingKey)
+	}
+	vv, err := pc.DeepFetchItem(ctx, a.RatingKey, true)
+	if err != nil {
+		return err
+	}
+	var found bool
+	var keep *Version
+	for _, m := range vv.Media {
+		switch m.ID {
+		case a.MediaID:
+			found = true
+		case a.Keep:
+			v := newVersion(m, "")
+			keep = &v
+		}
+	}
+	switch {
+	case !found:
+		return fmt.Errorf("media %s no longer belongs to item %s", a.MediaID, a.RatingKey)
+	case len(vv.Media) < 2:
+		return fmt.Errorf("refusing to delete the only version of item %s", a.RatingKey)
+	case keep == nil:
+		return fmt.Errorf("the version to keep (media %s) no longer belongs to item %s", a.Keep, a.RatingKey)
+	case len(keep.Parts) == 0 || versionIsGhost(*keep, true):
+		return fmt.Errorf("the version to keep (media %s) of item %s is missing files", a.Keep, a.RatingKey)
+	}
+	return nil
+}
+
+// runDelete implements `goPlexr delete`.
+func runDelete(args []string) int {
+	var (
+		o          Options
+		reportPath string
+		planPath   string
+		rule       string
+		sectionID  string
+		confirm    bool
+	)
+	var configPath, profile string
+	fs := flag.NewFlagSet("delete", flag.ContinueOnError)
+	addConfigFlags(fs, &configPath, &profile)
+	addConnFlags(fs, &o)
+	fs.StringVar(&reportPath, "report", "", "goPlexr JSON report to build a plan from (its keep-best removal candidates)")
+	fs.StringVar(&planPath, "plan", "", "Plan file: written when building from -report, read otherwise")
+	fs.StringVar(&rule, "rule", "", "Only include items flagged by this -policy-file rule")
+	fs.StringVar(&sectionID, "section", "", "Only include this library section ID")
+	fs.BoolVar(&confirm, "confirm", false, "Execute the plan read from -plan (without it, only print the plan)")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage:")
+		fmt.Fprintln(os.Stderr, "  goPlexr delete -report report.json -plan plan.json   # build + print plan (dry run)")
+		fmt.Fprintln(os.Stderr, "  goPlexr delete -plan plan.json                       # print a reviewed plan (dry run)")
+		fmt.Fprintln(os.Stderr, "  goPlexr delete -plan plan.json -confirm              # execute a reviewed plan")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	if err := applySettings(fs, configPath, profile); err != nil {
+		fmt.Fprintln(os.Stderr, "ERROR:", err)
+		return exitUsage
+	}
+	if err := resolveToken(context.Background(), &o); err != nil {
+		fmt.Fprintln(os.Stderr, "ERROR:", err)
+		return exitUsage
+	}
+	registerSecret(o.Token)
+	if o.Token == "" || planPath == "" {
+		fmt.Fprintln(os.Stderr, "ERROR: a token (-token, -token-file or -token-cmd) and -plan are required (the token signs and verifies the plan).")
+		fs.Usage()
+		return exitUsage
+	}
+
+	// Build mode: report -> signed plan, never executes.
+	if reportPath != "" {
+		if confirm {
+			fmt.Fprintln(os.Stderr, "ERROR: -confirm cannot be used while building a plan; review the plan, then run with -plan only.")
+			return exitUsage
+		}
+		var out Output
+		if err := readJSONFile(reportPath, &out); err != nil {
+			fmt.Fprintln(os.Stderr, "FATAL:", err)
+			return exitFatal
+		}
+		plan := BuildDeletePlan(out, rule, sectionID)
+		plan.Source = reportPath
+		if err := plan.Sign(o.Token); err != nil {
+			fmt.Fprintln(os.Stderr, "FATAL:", err)
+			return exitFatal
+		}
+		if err := writeJSONFile(planPath, plan, true); err != nil {
+			fmt.Fprintln(os.Stderr, "FATAL:", err)
+			return exitFatal
+		}
+		plan.Print(os.Stdout)
+		fmt.Printf("\nDry run. Plan written to %s; run `goPlexr delete -plan %s -confirm` to execute it.\n", planPath, planPath)
+		return 0
+	}
+
+	var plan DeletePlan
+	if err := readJSONFile(planPath, &plan); err != nil {
+		fmt.Fprintln(os.Stderr, "FATAL:", err)
+		return exitFatal
+	}
+	if err := plan.Verify(o.Token); err != nil {
+		fmt.Fprintln(os.Stderr, "FATAL:", err)
+		return exitFatal
+	}
+	if o.BaseURL == "" {
+		o.BaseURL = plan.Server
+	}
+	if strings.TrimRight(redactURL(o.BaseURL), "/") != strings.TrimRight(plan.Server, "/") {
+		fmt.Fprintf(os.Stderr, "FATAL: plan is for %s, not %s\n", plan.Server, redactURL(o.BaseURL))
+		return exitFatal
+	}
+	plan.Print(os.Stdout)
+	if !confirm {
+		fmt.Println("\nDry run. Re-run with -confirm to delete these versions.")
+		return 0
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	pc, err := NewClient(o)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "FATAL:", err)
+		return exitFatal
+	}
+	done, errs := ExecuteDeletePlan(ctx, pc, plan, os.Stdout)
+	fmt.Printf("\nDeleted %d of %d versions.\n", done, len(plan.Actions))
+	if len(errs) > 0 {
+		return exitPartial
+	}
+	return 0
+}
+
+// readJSONFile decodes a JSON file into v.
+func readJSONFile(path string, v any) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+// hasFlag reports whether a -policy-file flag rule matched the item.
+func hasFlag(it Item, rule string) bool {
+	for _, f := range it.Flags {
+		if f.Rule == rule {
+			return true
+		}
+	}
+	return false
+}
+
+// findVersion returns the item's version with the given ID.
+func findVersion(it Item, id string) (Version, bool) {
+	for _, v := range it.Versions {
+		if v.ID == id {
+			return v, true
+		}
+	}
+	return Version{}, false
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func planTestOutput(server string) Output {
+	return Output{
+		Server: server,
+		Sections: []SectionResult{{
+			SectionID: "1", SectionTitle: "Movies", Type: "movie",
+			Items: []Item{{
+				RatingKey: "100", Title: "Dup Movie", Year: 2020,
+				Versions: []Version{
+					{ID: "m1", Parts: []PartOut{{File: "/path/dup1.mkv", Size: 1000}}},
+					{ID: "m2", Parts: []PartOut{{File: "/path/dup2.mkv", Size: 2000}}},
+				},
+				Recommendation: &Recommendation{
+					Keep: "m2",
+					CandidatesForRemoval: []RemovalCandidate{
+						{VersionID: "m1", Bytes: 1000, Reasons: []string{"lower resolution (1080 vs 2160)"}},
+					},
+					ReclaimableBytes: 1000,
+				},
+			}},
+		}},
+	}
+}
+
+func TestDeletePlan_SignAndVerify(t *testing.T) {
+	plan := BuildDeletePlan(planTestOutput("http://plex:32400"), "", "")
+	if len(plan.Actions) != 1 || plan.Actions[0].MediaID != "m1" || plan.Actions[0].Files[0] != "/path/dup1.mkv" {
+		t.Fatalf("unexpected plan actions: %+v", plan.Actions)
+	}
+	if err := plan.Sign("secret"); err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	if err := plan.Verify("secret"); err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if err := plan.Verify("other-token"); err != ErrPlanSignature {
+		t.Fatalf("expected signature mismatch for another token, got %v", err)
+	}
+
+	tampered := plan
+	tampered.Actions = append([]DeleteAction{}, plan.Actions...)
+	tampered.Actions[0].MediaID = "m2"
+	if err := tampered.Verify("secret"); err != ErrPlanSignature {
+		t.Fatalf("expected signature mismatch after edit, got %v", err)
+	}
+}
+
+func TestDeletePlan_RuleFilter(t *testing.T) {
+	out := planTestOutput("http://plex:32400")
+	if plan := BuildDeletePlan(out, "low-bitrate", ""); len(plan.Actions) != 0 {
+		t.Fatalf("expected no actions for unflagged items, got %d", len(plan.Actions))
+	}
+	out.Sections[0].Items[0].Flags = []RuleFlag{{Rule: "low-bitrate", Reason: "x"}}
+	if plan := BuildDeletePlan(out, "low-bitrate", ""); len(plan.Actions) != 1 {
+		t.Fatalf("expected flagged item to be included, got %d", len(plan.Actions))
+	}
+}
+
+func TestExecuteDeletePlan(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		deleted []string
+	)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/library/metadata/100", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(metadata100XML))
+	})
+	mux.HandleFunc("/library/metadata/100/media/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodDelete {
+			t.Errorf("expected DELETE, got %s", r.Method)
+		}
+		mu.Lock()
+		deleted = append(deleted, strings.TrimPrefix(r.URL.Path, "/library/metadata/100/media/"))
+		mu.Unlock()
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	pc, err := NewClient(Options{BaseURL: ts.URL, Token: "fake", Timeout: 5 * time.Second})
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	plan := BuildDeletePlan(planTestOutput(ts.URL), "", "")
+	plan.Actions = append(plan.Actions, DeleteAction{SectionID: "1", RatingKey: "100", Title: "Dup Movie", MediaID: "gone"})
+
+	var log bytes.Buffer
+	done, errs := ExecuteDeletePlan(context.Background(), pc, plan, &log)
+	if done != 1 || len(deleted) != 1 || deleted[0] != "m1" {
+		t.Fatalf("expected only m1 deleted, got done=%d deleted=%v\n%s", done, deleted, log.String())
+	}
+	if len(errs) != 1 || errs[0].RatingKey != "100" {
+		t.Fatalf("expected the stale action to be skipped with an error, got %+v", errs)
+	}
+}
+
+func TestDeleteMedia_LostResponse(t *testing.T) {
+	calls := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			// the version is deleted but the response is lost on the way back
+			http.Error(w, "bad gateway", http.StatusBadGateway)
+			return
+		}
+		http.NotFound(w, r)
+	}))
+	defer ts.Close()
+
+	pc, err := NewClient(Options{BaseURL: ts.URL, Token: "fake", Timeout: 5 * time.Second, Retries: 2, RetryMaxWait: time.Millisecond})
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	if err := pc.DeleteMedia(context.Background(), "100", "m1"); err != nil || calls != 2 {
+		t.Fatalf("DeleteMedia = %v after %d calls, want success after 2", err, calls)
+	}
+	// a 404 on the first attempt is still an error
+	calls = 1
+	if err := pc.DeleteMedia(context.Background(), "100", "m1"); !isNotFound(err) {
+		t.Fatalf("DeleteMedia of a missing version = %v, want a 404", err)
+	}
+}
+
+func TestExecuteDeletePlan_StaleKeeper(t *testing.T) {
+	cases := map[string]string{
+		"keeper gone":  strings.Replace(metadata100XML, `<Media id="m2"`, `<Media id="m3"`, 1),
+		"keeper ghost": strings.Replace(metadata100XML, `size="2000" exists="1" accessible="1"`, `size="2000" exists="0" accessible="0"`, 1),
+	}
+	for name, item := range cases {
+		t.Run(name, func(t *testing.T) {
+			deletes := 0
+			mux := http.NewServeMux()
+			mux.HandleFunc("/library/metadata/100", func(w http.ResponseWriter, r *http.Request) {
+				_, _ = w.Write([]byte(item))
+			})
+			mux.HandleFunc("/library/metadata/100/media/", func(w http.ResponseWriter, r *http.Request) {
+				deletes++
+			})
+			ts := httptest.NewServer(mux)
+			defer ts.Close()
+
+			pc, err := NewClient(Options{BaseURL: ts.URL, Token: "fake", Timeout: 5 * time.Second})
+			if err != nil {
+				t.Fatalf("NewClient: %v", err)
+			}
+			var log bytes.Buffer
+			done, errs := ExecuteDeletePlan(context.Background(), pc, BuildDeletePlan(planTestOutput(ts.URL), "", ""), &log)
+			if done != 0 || deletes != 0 || len(errs) != 1 || !strings.Contains(errs[0].Message, "version to keep") {
+				t.Fatalf("done=%d deletes=%d errs=%+v\n%s", done, deletes, errs, log.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"slices"
+	"strings"
+)
+
+// Plex keeps cuts of a movie apart by edition: an item's editionTitle, or
+// an {edition-...} tag in the file name. Theatrical and extended cuts kept
+// side by side are not duplicates of each other, so with -edition-aware
+// (the default) versions are grouped by edition and an item counts as a
+// duplicate only when one edition holds two or more versions the policy
+// doesn't consider intentional.
+
+const reasonEditionSet = "edition_set"
+
+func init() {
+	RegisterReason(reasonEditionSet, ReasonInfo{
+		Title:       "Edition Sets",
+		Description: "Items whose versions are different editions (e.g. Theatrical and Extended), one version each, were not counted as duplicates.",
+	})
+}
+
+// editionNames are release-name spellings of common editions, as
+// partTokens sequences, and the edition they name. Longer spellings first.
+var editionNames = []struct {
+	tokens []string
+	name   string
+}{
+	{[]string{"director's", "cut"}, "Director's Cut"},
+	{[]string{"directors", "cut"}, "Director's Cut"},
+	{[]string{"extended", "cut"}, "Extended"},
+	{[]string{"extended", "edition"}, "Extended"},
+	{[]string{"final", "cut"}, "Final Cut"},
+	{[]string{"ultimate", "cut"}, "Ultimate"},
+	{[]string{"ultimate", "edition"}, "Ultimate"},
+	{[]string{"special", "edition"}, "Special Edition"},
+	{[]string{"extended"}, "Extended"},
+	{[]string{"unrated"}, "Unrated"},
+	{[]string{"uncut"}, "Uncut"},
+	{[]string{"imax"}, "IMAX"},
+	{[]string{"redux"}, "Redux"},
+}
+
+// regularEditions name the regular release. A version labelled with one is
+// the same edition as an untagged version, so they are still duplicates.
+var regularEditions = map[string]bool{
+	"theatrical": true, "theatrical cut": true, "theatrical edition": true, "theatrical version": true,
+	"standard": true, "standard edition": true, "original": true, "original cut": true,
+}
+
+// regularEdition returns e, or "" if it names the regular release.
+func regularEdition(e string) string {
+	if regularEditions[strings.ToLower(strings.Join(strings.Fields(e), " "))] {
+		return ""
+	}
+	return e
+}
+
+// detectEdition returns a version's edition: the item's editionTitle, else
+// an {edition-...} tag, else a common edition name in a part's file name.
+// Names only count after the release year ("Heat (1995) Extended.mkv",
+// "Heat.1995.Extended.1080p.mkv") so titles like "Uncut Gems" aren't taken
+// for editions. The regular release (Theatrical, Standard, ...) is "".
+func detectEdition(editionTitle string, v Version) string {
+	if t := strings.TrimSpace(editionTitle); t != "" {
+		return regularEdition(t)
+	}
+	if e := editionTag(v); e != "" {
+		return regularEdition(e)
+	}
+	for _, p := range v.Parts {
+		toks := partTokens(Version{Parts: []PartOut{p}})
+		year := slices.IndexFunc(toks, func(t string) bool {
+			return len(t) == 4 && (strings.HasPrefix(t, "19") || strings.HasPrefix(t, "20")) && allDigits(t)
+		})
+		if year == -1 {
+			continue
+		}
+		toks = toks[year+1:]
+		for _, en := range editionNames {
+			for i := 0; i+len(en.tokens) <= len(toks); i++ {
+				if slices.Equal(toks[i:i+len(en.tokens)], en.tokens) {
+					return en.name
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// itemEdition returns the edition all of an item's versions share, or "".
+func itemEdition(it Item) string {
+	e := ""
+	for i, v := range it.Versions {
+		if i > 0 && !strings.EqualFold(v.Edition, e) {
+			return ""
+		}
+		e = v.Edition
+	}
+	return e
+}
+
+// groupEditions groups the versions by edition (case-insensitively, in
+// order of first appearance). It returns nil when they are all one edition.
+func groupEditions(vs []Version) []EditionGroup {
+	var groups []EditionGroup
+	for _, v := range vs {
+		i := slices.IndexFunc(groups, func(g EditionGroup) bool { return strings.EqualFold(g.Edition, v.Edition) })
+		if i == -1 {
+			i = len(groups)
+			groups = append(groups, EditionGroup{Edition: v.Edition})
+		}
+		groups[i].Versions = append(groups[i].Versions, v.ID)
+	}
+	if len(groups) < 2 {
+		return nil
+	}
+	return groups
+}
+
+// decideItem runs the policy on an item. With editionAware and versions of
+// more than one edition, it runs on each edition with two or more versions
+// instead: the item is a duplicate if any of them is, and is otherwise
+// ignored with the first edition's reason (or edition_set when every
+// edition has a single version). Rule flags from every edition are kept.
+func decideItem(p Policy, it *Item, editionAware bool) Decision {
+	it.Editions = nil
+	if editionAware {
+		it.Editions = groupEditions(it.Versions)
+	}
+	if it.Editions == nil {
+		return p.Decide(*it)
+	}
+
+	var flags []RuleFlag
+	var ignored *Decision
+	dup := false
+	for i := range it.Editions {
+		g := &it.Editions[i]
+		if len(g.Versions) < 2 {
+			continue
+		}
+		sub := *it
+		sub.Versions, sub.Editions = editionVersions(it.Versions, g), nil
+		d := p.Decide(sub)
+		for _, f := range d.Flags {
+			if !slices.Contains(flags, f) {
+				flags = append(flags, f)
+			}
+		}
+		if d.Ignore {
+			g.Reason = d.Reason
+			if ignored == nil {
+				ignored = &d
+			}
+			continue
+		}
+		g.Duplicate, dup = true, true
+	}
+	switch {
+	case dup:
+		return Decision{Flags: flags}
+	case ignored != nil:
+		ignored.Flags = flags
+		return *ignored
+	}
+	return Decision{Ignore: true, Reason: reasonEditionSet, Flags: flags}
+}
+
+// recommendItem makes the keep-best recommendation. For an item grouped by
+// edition, each duplicate edition keeps its best version; the removal
+// candidates of all of them are combined and Recommendation.Keep is the
+// first edition's pick.
+func recommendItem(it *Item, m ScoreModel, verify bool) *Recommendation {
+	if it.Editions == nil {
+		return recommend(it, m, verify)
+	}
+	var rec *Recommendation
+	for i := range it.Editions {
+		g := &it.Editions[i]
+		if !g.Duplicate {
+			continue
+		}
+		sub := Item{Versions: editionVersions(it.Versions, g)}
+		r := recommend(&sub, m, verify)
+		if r == nil {
+			continue
+		}
+		k := 0
+		for j := range it.Versions {
+			if strings.EqualFold(it.Versions[j].Edition, g.Edition) {
+				it.Versions[j].Score = sub.Versions[k].Score
+				k++
+			}
+		}
+		g.Keep = r.Keep
+		if rec == nil {
+			rec = &Recommendation{Keep: r.Keep}
+		}
+		rec.CandidatesForRemoval = append(rec.CandidatesForRemoval, r.CandidatesForRemoval...)
+		rec.ReclaimableBytes += r.ReclaimableBytes
+	}
+	return rec
+}
+
+// editionVersions returns the versions of an edition group.
+func editionVersions(vs []Version, g *EditionGroup) []Version {
+	var out []Version
+	for _, v := range vs {
+		if strings.EqualFold(v.Edition, g.Edition) {
+			out = append(out, v)
+		}
+	}
+	return out
+}
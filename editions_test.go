@@ -0,0 +1,112 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func editionVersion(id, file string) Version {
+	v := Version{ID: id, VideoResolution: "1080", Parts: []PartOut{{File: file, Size: 100}}}
+	v.Edition = detectEdition("", v)
+	return v
+}
+
+func TestDetectEdition(t *testing.T) {
+	cases := []struct {
+		title, file, want string
+	}{
+		{"Director's Cut", "/m/Blade Runner (1982)/Blade Runner (1982).mkv", "Director's Cut"},
+		{"", "/m/Blade Runner (1982)/Blade Runner (1982) {edition-Final Cut}.mkv", "Final Cut"},
+		{"", "/m/Heat (1995)/Heat (1995) Extended.mkv", "Extended"},
+		{"", "/m/Heat.1995.Directors.Cut.1080p.mkv", "Director's Cut"},
+		{"", "/m/Avatar.2009.IMAX.2160p.mkv", "IMAX"},
+		{"", "/m/Uncut Gems (2019)/Uncut Gems (2019).mkv", ""}, // before the year: part of the title
+		{"", "/m/Heat (1995)/Heat (1995).mkv", ""},
+		{"", "/m/Heat (1995)/Heat (1995) Theatrical.mkv", ""}, // the regular release
+		{"", "/m/Heat (1995)/Heat (1995) {edition-Standard Edition}.mkv", ""},
+		{"Theatrical Cut", "/m/Heat (1995)/Heat (1995).mkv", ""},
+	}
+	for _, c := range cases {
+		if got := detectEdition(c.title, Version{Parts: []PartOut{{File: c.file}}}); got != c.want {
+			t.Errorf("detectEdition(%q, %q) = %q, want %q", c.title, c.file, got, c.want)
+		}
+	}
+}
+
+func TestDecideItem_Editions(t *testing.T) {
+	ps, err := ParsePolicies("plex")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	it := Item{Versions: []Version{
+		editionVersion("1", "/m/Heat (1995)/Heat (1995).mkv"),
+		editionVersion("2", "/m/Heat (1995)/Heat (1995) Extended.mkv"),
+	}}
+	if d := decideItem(ps, &it, true); !d.Ignore || d.Reason != reasonEditionSet || len(it.Editions) != 2 {
+		t.Errorf("theatrical+extended: %+v, editions %+v", d, it.Editions)
+	}
+	if d := decideItem(ps, &it, false); d.Ignore || it.Editions != nil {
+		t.Errorf("edition-aware off: %+v, editions %+v", d, it.Editions)
+	}
+
+	it.Versions = append(it.Versions, editionVersion("3", "/m/Heat (1995)/Heat.1995.720p.mkv"))
+	it.Versions[2].VideoResolution = "720"
+	d := decideItem(ps, &it, true)
+	if d.Ignore || !it.Editions[0].Duplicate || it.Editions[1].Duplicate {
+		t.Fatalf("two theatrical + extended: %+v, editions %+v", d, it.Editions)
+	}
+	rec := recommendItem(&it, DefaultScoreModel, false)
+	if rec == nil || rec.Keep != "1" || len(rec.CandidatesForRemoval) != 1 || rec.CandidatesForRemoval[0].VersionID != "3" {
+		t.Errorf("recommendation = %+v", rec)
+	}
+	if it.Editions[0].Keep != "1" || it.Editions[1].Keep != "" {
+		t.Errorf("edition keeps = %+v", it.Editions)
+	}
+}
+
+func TestDecideItem_TheatricalIsRegular(t *testing.T) {
+	ps, err := ParsePolicies("plex")
+	if err != nil {
+		t.Fatal(err)
+	}
+	it := Item{Versions: []Version{
+		editionVersion("1", "/m/Movie (2001)/Movie (2001).mkv"),
+		editionVersion("2", "/m/Movie (2001)/Movie (2001) Theatrical.mkv"),
+	}}
+	it.Versions[1].VideoResolution = "720"
+	if d := decideItem(ps, &it, true); d.Ignore || it.Editions != nil {
+		t.Errorf("untagged + theatrical: %+v, editions %+v; want a duplicate", d, it.Editions)
+	}
+}
+
+func TestFindSplitDuplicates_Editions(t *testing.T) {
+	vids := []Video{
+		{RatingKey: "1", Type: "movie", Title: "Heat", Year: 1995, Guid: "plex://movie/a"},
+		{RatingKey: "2", Type: "movie", Title: "Heat", Year: 1995, Guid: "plex://movie/a", EditionTitle: "Extended"},
+	}
+	if groups := findSplitDuplicates(Directory{Key: "1", Title: "Movies"}, vids, true); len(groups) != 0 {
+		t.Errorf("edition-aware: groups = %+v, want none", groups)
+	}
+	if groups := findSplitDuplicates(Directory{Key: "1", Title: "Movies"}, vids, false); len(groups) != 1 {
+		t.Errorf("edition-aware off: groups = %d, want 1", len(groups))
+	}
+}
+
+func TestRenderHTML_Edition(t *testing.T) {
+	it := Item{RatingKey: "1", Title: "Heat", Year: 1995, Versions: []Version{
+		editionVersion("1", "/m/Heat (1995)/Heat (1995).mkv"),
+		editionVersion("2", "/m/Heat (1995)/Heat (1995) {edition-Director's Cut}.mkv"),
+	}}
+	out := Output{Sections: []SectionResult{{SectionID: "1", SectionTitle: "Movies", Type: "movie", Items: []Item{it}}}}
+	fn := filepath.Join(t.TempDir(), "editions.html")
+	if err := RenderHTML(out, false, false, fn); err != nil {
+		t.Fatalf("RenderHTML: %v", err)
+	}
+	b, _ := os.ReadFile(fn)
+	if !strings.Contains(string(b), `<span class="badge">Director&#39;s Cut</span>`) {
+		t.Errorf("report missing the edition badge")
+	}
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+)
+
+// Plex servers answer GDM ("G'Day Mate") probes on the local network: an
+// M-SEARCH datagram sent to UDP port 32414 (multicast 239.0.0.250 or
+// broadcast) gets an HTTP-style reply with the server's name, port and
+// version from each server that sees it.
+
+// gdmTargets are where probes are sent.
+var gdmTargets = []string{"239.0.0.250:32414", "255.255.255.255:32414"}
+
+const gdmProbe = "M-SEARCH * HTTP/1.1\r\n\r\n"
+
+// gdmWait is how long replies are collected by default.
+const gdmWait = 2 * time.Second
+
+// GDMServer is a server that answered a GDM probe.
+type GDMServer struct {
+	Name       string `json:"name"`
+	Address    string `json:"address"`
+	Port       int    `json:"port"`
+	Version    string `json:"version,omitempty"`
+	ResourceID string `json:"resource_id,omitempty"`
+	URL        string `json:"url"`
+}
+
+// parseGDMResponse parses a GDM reply received from addr. Replies that are
+// not from a media server are rejected.
+func parseGDMResponse(b []byte, addr net.IP) (GDMServer, bool) {
+	sc := bufio.NewScanner(bytes.NewReader(b))
+	if !sc.Scan() || !strings.Contains(sc.Text(), "200") {
+		return GDMServer{}, false
+	}
+	s := GDMServer{Address: addr.String(), Port: 32400}
+	for sc.Scan() {
+		k, v, ok := strings.Cut(sc.Text(), ":")
+		if !ok {
+			continue
+		}
+		v = strings.TrimSpace(v)
+		switch strings.ToLower(strings.TrimSpace(k)) {
+		case "content-type":
+			if !strings.Contains(v, "plex/media-server") {
+				return GDMServer{}, false
+			}
+		case "name":
+			s.Name = v
+		case "port":
+			if p, err := strconv.Atoi(v); err == nil && p > 0 && p < 65536 {
+				s.Port = p
+			}
+		case "version":
+			s.Version = v
+		case "resource-identifier":
+			s.ResourceID = v
+		}
+	}
+	s.URL = "http://" + net.JoinHostPort(s.Address, strconv.Itoa(s.Port))
+	return s, true
+}
+
+// DiscoverGDM probes the local network and collects replies for wait (or
+// until ctx ends). A server usually answers both probes; duplicates are
+// dropped. Servers are sorted by name.
+func DiscoverGDM(ctx context.Context, wait time.Duration) ([]GDMServer, error) {
+	conn, err := net.ListenPacket("udp4", ":0")
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	var sent int
+	var errs []error
+	for _, t := range gdmTargets {
+		addr, err := net.ResolveUDPAddr("udp4", t)
+		if err == nil {
+			_, err = conn.WriteTo([]byte(gdmProbe), addr)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("probe %s: %w", t, err))
+			continue
+		}
+		sent++
+	}
+	if sent == 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	deadline := time.Now().Add(wait)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	_ = conn.SetReadDeadline(deadline)
+	stop := context.AfterFunc(ctx, func() { _ = conn.SetReadDeadline(time.Now()) })
+	defer stop()
+
+	seen := map[string]bool{}
+	var out []GDMServer
+	buf := make([]byte, 4096)
+	for {
+		n, from, err := conn.ReadFrom(buf)
+		if err != nil {
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				break
+			}
+			return out, err
+		}
+		ua, ok := from.(*net.UDPAddr)
+		if !ok {
+			continue
+		}
+		s, ok := parseGDMResponse(buf[:n], ua.IP)
+		if !ok {
+			continue
+		}
+		key := s.ResourceID
+		if key == "" {
+			key = s.URL
+		}
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		out = append(out, s)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Name != out[j].Name {
+			return out[i].Name < out[j].Name
+		}
+		return out[i].URL < out[j].URL
+	})
+	return out, ctx.Err()
+}
+
+// discoverURL returns the URL of the only server on the local network. It
+// is how a scan without -url finds its server.
+func discoverURL(ctx context.Context, wait time.Duration) (GDMServer, error) {
+	servers, err := DiscoverGDM(ctx, wait)
+	if err != nil {
+		return GDMServer{}, fmt.Errorf("discover: %w", err)
+	}
+	switch len(servers) {
+	case 0:
+		return GDMServer{}, errors.New("no -url given and no Plex server answered on the local network")
+	case 1:
+		return servers[0], nil
+	}
+	var list []string
+	for _, s := range servers {
+		list = append(list, s.Name+" ("+s.URL+")")
+	}
+	return GDMServer{}, fmt.Errorf("no -url given and %d Plex servers answered: %s; pick one with -url or 'goPlexr discover -save'", len(servers), strings.Join(list, ", "))
+}
+
+// runDiscover implements `goPlexr discover`.
+func runDiscover(args []string) int {
+	var (
+		configPath, profile string
+		wait                time.Duration
+		save, asJSON        bool
+	)
+	fs := flag.NewFlagSet("discover", flag.ContinueOnError)
+	addConfigFlags(fs, &configPath, &profile)
+	fs.DurationVar(&wait, "wait", gdmWait, "How long to wait for replies")
+	fs.BoolVar(&save, "save", false, "Choose a server and save its url to the settings file (-profile selects the table)")
+	fs.BoolVar(&asJSON, "json", false, "Print the servers as JSON")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: goPlexr discover [-wait 2s] [-json] [-save [-profile NAME]]")
+		fmt.Fprintln(os.Stderr, "List Plex servers on the local network (GDM, UDP port 32414).")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	if fs.NArg() > 0 || (save && asJSON) {
+		fs.Usage()
+		return exitUsage
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	servers, err := DiscoverGDM(ctx, wait)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "FATAL:", err)
+		return exitFatal
+	}
+	if asJSON {
+		if servers == nil {
+			servers = []GDMServer{}
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(servers); err != nil {
+			fmt.Fprintln(os.Stderr, "FATAL:", err)
+			return exitFatal
+		}
+		return 0
+	}
+	if len(servers) == 0 {
+		fmt.Fprintln(os.Stderr, "No Plex servers answered. GDM needs \"Enable local network discovery\" on the server and UDP 32414 reachable from here.")
+		return exitFatal
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "#\tNAME\tADDRESS\tPORT\tVERSION\tURL")
+	for i, s := range servers {
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%d\t%s\t%s\n", i+1, s.Name, s.Address, s.Port, s.Version, s.URL)
+	}
+	tw.Flush()
+	if !save {
+		return 0
+	}
+
+	if profile == "" {
+		profile = os.Getenv("GOPLEXR_PROFILE")
+	}
+	path, _ := settingsPath(configPath)
+	if path == "" {
+		fmt.Fprintln(os.Stderr, "ERROR: no settings file location; pass -config.")
+		return exitUsage
+	}
+	var names []string
+	for _, s := range servers {
+		names = append(names, s.Name+" "+s.URL)
+	}
+	p := prompter{in: bufio.NewScanner(os.Stdin), out: os.Stderr}
+	i, err := p.choose("Server", names)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "FATAL:", err)
+		return exitFatal
+	}
+	if err := saveSettings(path, profile, map[string]string{"url": servers[i].URL}); err != nil {
+		fmt.Fprintln(os.Stderr, "FATAL: save settings:", err)
+		return exitFatal
+	}
+	fmt.Fprintf(os.Stderr, "Saved url %s to %s\n", servers[i].URL, path)
+	return 0
+}
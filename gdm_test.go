@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseGDMResponse(t *testing.T) {
+	reply := "HTTP/1.0 200 OK\r\nContent-Type: plex/media-server\r\nResource-Identifier: abc123\r\nName: Den\r\nPort: 32401\r\nVersion: 1.40.2.8395\r\n\r\n"
+	s, ok := parseGDMResponse([]byte(reply), net.ParseIP("192.168.1.5"))
+	want := GDMServer{Name: "Den", Address: "192.168.1.5", Port: 32401, Version: "1.40.2.8395", ResourceID: "abc123", URL: "http://192.168.1.5:32401"}
+	if !ok || s != want {
+		t.Errorf("parsed = %+v, %v; want %+v", s, ok, want)
+	}
+	player := strings.Replace(reply, "plex/media-server", "plex/media-player", 1)
+	if _, ok := parseGDMResponse([]byte(player), net.ParseIP("192.168.1.6")); ok {
+		t.Errorf("player reply should be rejected")
+	}
+	if _, ok := parseGDMResponse([]byte("HTTP/1.0 404 Not Found\r\n"), net.ParseIP("192.168.1.7")); ok {
+		t.Errorf("non-200 reply should be rejected")
+	}
+}
+
+// fakeGDM answers every probe twice (like a server reached by multicast and broadcast).
+func fakeGDM(t *testing.T, reply string) string {
+	t.Helper()
+	pc, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("udp: %v", err)
+	}
+	t.Cleanup(func() { pc.Close() })
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, from, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if strings.HasPrefix(string(buf[:n]), "M-SEARCH") {
+				_, _ = pc.WriteTo([]byte(reply), from)
+				_, _ = pc.WriteTo([]byte(reply), from)
+			}
+		}
+	}()
+	return pc.LocalAddr().String()
+}
+
+func TestDiscoverGDM(t *testing.T) {
+	defer func(old []string) { gdmTargets = old }(gdmTargets)
+	a := fakeGDM(t, "HTTP/1.0 200 OK\r\nContent-Type: plex/media-server\r\nResource-Identifier: a\r\nName: Den\r\nPort: 32400\r\n\r\n")
+	b := fakeGDM(t, "HTTP/1.0 200 OK\r\nContent-Type: plex/media-server\r\nResource-Identifier: b\r\nName: Attic\r\nPort: 32500\r\n\r\n")
+
+	gdmTargets = []string{a}
+	s, err := discoverURL(context.Background(), 200*time.Millisecond)
+	if err != nil || s.Name != "Den" || s.URL != "http://127.0.0.1:32400" {
+		t.Errorf("one server: %+v, %v", s, err)
+	}
+
+	gdmTargets = []string{a, b}
+	servers, err := DiscoverGDM(context.Background(), 200*time.Millisecond)
+	if err != nil || len(servers) != 2 || servers[0].Name != "Attic" {
+		t.Errorf("two servers: %+v, %v", servers, err)
+	}
+	if _, err := discoverURL(context.Background(), 200*time.Millisecond); err == nil || !strings.Contains(err.Error(), "2 Plex servers") {
+		t.Errorf("two servers: expected an ambiguity error, got %v", err)
+	}
+}
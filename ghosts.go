@@ -0,0 +1,391 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ghostSection gathers the ghost parts found in one library.
+type ghostSection struct {
+	id, title string
+	checked   map[string]bool     // part files verification checked -> ghost
+	dirs      map[string]struct{} // parent folders of ghost parts (Plex paths)
+	ghosts    map[string]int      // ratingKey -> ghost parts before cleanup
+	order     []string            // rating keys in report order
+	skip      string              // why the library is left alone, if it is
+	keepTrash string              // why the trash is not emptied, if it isn't
+}
+
+// ghostProbeItems is how many other items per library folder -fix-ghosts
+// re-verifies through Plex, so an offline mount is noticed even when none of
+// its items has duplicates.
+const ghostProbeItems = 5
+
+// cleanupGhosts asks Plex to rescan the folders holding ghost parts, empties
+// each affected library's trash, then re-verifies the affected items (with
+// checkFiles=1, or locally with -local-verify). Parts that weren't verified
+// (failed deep fetch) are not ghosts, and libraries whose ghosts look like an
+// outage (see ghostRisk) are reported but not touched: a rescan of an offline
+// mount makes Plex drop the media on it. Emptying the trash purges the whole
+// library, so it is also skipped unless every folder of the library is known
+// to be online (see unprovenFolder). Failed requests are appended to errs.
+func cleanupGhosts(ctx context.Context, pc *Client, o Options, pm PathMap, libs []Directory, sections []SectionResult, ignored []IgnoredItem, errs *[]ScanError) *GhostCleanup {
+	var secs []*ghostSection
+	byID := map[string]*ghostSection{}
+	add := func(secID, secTitle string, it Item) {
+		gs, ok := byID[secID]
+		if !ok {
+			gs = &ghostSection{id: secID, title: secTitle, checked: map[string]bool{}, dirs: map[string]struct{}{}, ghosts: map[string]int{}}
+			byID[secID] = gs
+			secs = append(secs, gs)
+		}
+		n := 0
+		for _, v := range it.Versions {
+			for _, p := range v.Parts {
+				if p.Unverified {
+					continue
+				}
+				gs.checked[p.File] = gs.checked[p.File] || !p.VerifiedOnDisk
+				if !p.VerifiedOnDisk {
+					n++
+					if d := plexDir(p.File); d != "" {
+						gs.dirs[d] = struct{}{}
+					}
+				}
+			}
+		}
+		if n == 0 {
+			return
+		}
+		if _, seen := gs.ghosts[it.RatingKey]; !seen {
+			gs.order = append(gs.order, it.RatingKey)
+		}
+		gs.ghosts[it.RatingKey] += n
+	}
+	for _, sec := range sections {
+		for _, it := range sec.Items {
+			add(sec.SectionID, sec.SectionTitle, it)
+		}
+	}
+	for _, ig := range ignored {
+		add(ig.SectionID, ig.SectionTitle, ig.Item)
+	}
+	secs = slices.DeleteFunc(secs, func(gs *ghostSection) bool { return len(gs.ghosts) == 0 })
+
+	gc := &GhostCleanup{}
+	if len(secs) == 0 {
+		return gc
+	}
+
+	// 0. check the rest of each library, and leave libraries alone whose
+	// ghosts look like an outage
+	cleaning := false
+	for _, gs := range secs {
+		lib := Directory{Key: gs.id}
+		if i := slices.IndexFunc(libs, func(d Directory) bool { return d.Key == gs.id }); i != -1 {
+			lib = libs[i]
+		}
+		if err := probeLibrary(ctx, pc, o, pm, lib, gs); err != nil {
+			*errs = append(*errs, newScanError(gs.id, "", err))
+		}
+		if gs.skip = ghostRisk(gs, o, pm, lib.Location); gs.skip != "" {
+			fmt.Fprintf(os.Stderr, "WARN: -fix-ghosts: leaving library %q alone: %s\n", gs.title, gs.skip)
+			continue
+		}
+		if gs.keepTrash = unprovenFolder(gs, lib.Location); gs.keepTrash != "" {
+			fmt.Fprintf(os.Stderr, "WARN: -fix-ghosts: not emptying the trash of library %q: %s\n", gs.title, gs.keepTrash)
+		}
+		cleaning = true
+	}
+
+	// 1. partial rescans so Plex notices the missing files
+	for _, gs := range secs {
+		if gs.skip != "" {
+			continue
+		}
+		for _, d := range sortedKeys(gs.dirs) {
+			if err := pc.RefreshSectionPath(ctx, gs.id, d); err != nil {
+				*errs = append(*errs, newScanError(gs.id, "", err))
+			}
+		}
+	}
+
+	// 2. give the scanner time to mark the media unavailable
+	if cleaning && o.FixGhostsWait > 0 {
+		if o.Verbose {
+			fmt.Fprintf(os.Stderr, "Waiting %s for Plex to rescan before emptying trash\n", o.FixGhostsWait)
+		}
+		t := time.NewTimer(o.FixGhostsWait)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			*errs = append(*errs, newScanError("", "", ctx.Err()))
+			return gc
+		case <-t.C:
+		}
+	}
+
+	// 3. empty trash and 4. re-verify
+	for _, gs := range secs {
+		res := GhostCleanupSection{
+			SectionID:    gs.id,
+			SectionTitle: gs.title,
+			Skipped:      gs.skip,
+			TrashKept:    gs.keepTrash,
+		}
+		if gs.skip != "" {
+			for _, n := range gs.ghosts {
+				res.GhostsBefore += n
+			}
+			res.GhostsAfter = res.GhostsBefore
+			gc.GhostsBefore += res.GhostsBefore
+			gc.GhostsAfter += res.GhostsAfter
+			gc.Sections = append(gc.Sections, res)
+			continue
+		}
+		res.RefreshedPaths = sortedKeys(gs.dirs)
+		res.ItemsChecked = len(gs.order)
+		if gs.keepTrash == "" {
+			if err := pc.EmptyTrash(ctx, gs.id); err != nil {
+				*errs = append(*errs, newScanError(gs.id, "", err))
+			} else {
+				res.TrashEmptied = true
+			}
+		}
+
+		after := make([]int, len(gs.order))
+		afterErrs := make([]error, len(gs.order))
+		_ = runPool(ctx, o.Concurrency, len(gs.order), func(ctx context.Context, i int) {
+			after[i], afterErrs[i] = countGhosts(ctx, pc, o, pm, gs.order[i])
+		})
+		for i, rk := range gs.order {
+			res.GhostsBefore += gs.ghosts[rk]
+			switch err := afterErrs[i]; {
+			case err == nil:
+				res.GhostsAfter += after[i]
+			case isNotFound(err):
+				res.ItemsRemoved++ // the whole item went away with the trash
+			default:
+				// unknown state: assume nothing changed
+				res.GhostsAfter += gs.ghosts[rk]
+				*errs = append(*errs, newScanError(gs.id, rk, err))
+			}
+		}
+
+		gc.GhostsBefore += res.GhostsBefore
+		gc.GhostsAfter += res.GhostsAfter
+		gc.Sections = append(gc.Sections, res)
+	}
+	return gc
+}
+
+// probeLibrary adds the rest of a library to gs.checked, so the ghost ratio
+// and the folder checks see more than the duplicates: with -local-verify
+// every listed part is verified locally, otherwise up to ghostProbeItems
+// other items per folder are re-fetched with checkFiles=1. Items that fail
+// to re-fetch are left out.
+func probeLibrary(ctx context.Context, pc *Client, o Options, pm PathMap, lib Directory, gs *ghostSection) error {
+	if lib.Type == "" {
+		return nil
+	}
+	vids, err := pc.FetchAllItems(ctx, lib.Key, lib.Type)
+	if err != nil {
+		return err
+	}
+	if o.LocalVerify {
+		for _, v := range vids {
+			for _, m := range v.Media {
+				for _, p := range m.Part {
+					if _, ok := gs.checked[p.File]; ok || p.File == "" {
+						continue
+					}
+					po := PartOut{File: p.File, Size: p.Size}
+					verifyLocal(&po, pm)
+					gs.checked[p.File] = !po.VerifiedOnDisk
+				}
+			}
+		}
+		return nil
+	}
+
+	var keys []string
+	picked := map[string]int{}
+	for _, v := range vids {
+		for _, l := range lib.Location {
+			if picked[l.Path] < ghostProbeItems && slices.ContainsFunc(v.Media, func(m Media) bool {
+				return slices.ContainsFunc(m.Part, func(p Part) bool {
+					_, ok := gs.checked[p.File]
+					return !ok && underDir(p.File, l.Path)
+				})
+			}) {
+				picked[l.Path]++
+				keys = append(keys, v.RatingKey)
+				break
+			}
+		}
+	}
+	parts := make([][]PartOut, len(keys))
+	if err := runPool(ctx, o.Concurrency, len(keys), func(ctx context.Context, i int) {
+		parts[i], _ = verifiedParts(ctx, pc, o, pm, keys[i])
+	}); err != nil {
+		return err
+	}
+	for _, ps := range parts {
+		for _, p := range ps {
+			gs.checked[p.File] = gs.checked[p.File] || !p.VerifiedOnDisk
+		}
+	}
+	return nil
+}
+
+// ghostRisk returns why cleaning a library looks unsafe, or "". Deleted files
+// are a few parts here and there; an offline disk or mount is all the parts
+// under it. So a library is left alone when more than -fix-ghosts-max-ratio
+// of its checked parts are ghosts, or when one of its folders is unreachable:
+// with -local-verify it can't be stat'ed here, otherwise every checked part
+// under it is a ghost.
+func ghostRisk(gs *ghostSection, o Options, pm PathMap, locs []Location) string {
+	ghosts := 0
+	for _, ghost := range gs.checked {
+		if ghost {
+			ghosts++
+		}
+	}
+	if float64(ghosts) > o.MaxGhostRatio*float64(len(gs.checked)) {
+		return fmt.Sprintf("%d of %d checked part files are missing (more than -fix-ghosts-max-ratio %g)", ghosts, len(gs.checked), o.MaxGhostRatio)
+	}
+	for _, l := range locs {
+		if o.LocalVerify {
+			if _, err := os.Stat(pm.Local(l.Path)); err != nil {
+				return fmt.Sprintf("library folder %s is unreachable: %v", l.Path, err)
+			}
+			continue
+		}
+		ghosts, found := 0, 0
+		for f, ghost := range gs.checked {
+			if underDir(f, l.Path) {
+				if ghost {
+					ghosts++
+				} else {
+					found++
+				}
+			}
+		}
+		if ghosts > 0 && found == 0 {
+			return fmt.Sprintf("none of the checked files under library folder %s was found", l.Path)
+		}
+	}
+	return ""
+}
+
+// unprovenFolder returns why a library's folders aren't known to be online,
+// or "": each needs a checked part under it that was found on disk.
+func unprovenFolder(gs *ghostSection, locs []Location) string {
+	if len(locs) == 0 {
+		return "Plex listed no folders for the library"
+	}
+	for _, l := range locs {
+		found := false
+		for f, ghost := range gs.checked {
+			if !ghost && underDir(f, l.Path) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Sprintf("no file under library folder %s was found, so it may be offline", l.Path)
+		}
+	}
+	return ""
+}
+
+// underDir reports whether file is inside dir (a Plex path, either separator
+// style). Only whole path components match: /data/tv2/x is not under /data/tv.
+func underDir(file, dir string) bool {
+	dir = strings.TrimRight(dir, `/\`)
+	rest, ok := strings.CutPrefix(file, dir)
+	return ok && dir != "" && rest != "" && (rest[0] == '/' || rest[0] == '\\')
+}
+
+// countGhosts re-fetches an item and counts missing parts, verified the
+// same way as the scan.
+func countGhosts(ctx context.Context, pc *Client, o Options, pm PathMap, ratingKey string) (int, error) {
+	parts, err := verifiedParts(ctx, pc, o, pm, ratingKey)
+	n := 0
+	for _, p := range parts {
+		if !p.VerifiedOnDisk {
+			n++
+		}
+	}
+	return n, err
+}
+
+// verifiedParts re-fetches an item and returns its parts, verified the same
+// way as the scan.
+func verifiedParts(ctx context.Context, pc *Client, o Options, pm PathMap, ratingKey string) ([]PartOut, error) {
+	vv, err := pc.DeepFetchItem(ctx, ratingKey, !o.LocalVerify)
+	if err != nil {
+		return nil, err
+	}
+	var parts []PartOut
+	for _, m := range vv.Media {
+		for _, p := range newVersion(m, "").Parts {
+			if o.LocalVerify {
+				verifyLocal(&p, pm)
+			}
+			parts = append(parts, p)
+		}
+	}
+	return parts, nil
+}
+
+// isNotFound reports whether err is a Plex 404.
+func isNotFound(err error) bool {
+	var re *RequestError
+	return errors.As(err, &re) && re.StatusCode == http.StatusNotFound
+}
+
+// plexDir returns the parent folder of a Plex part path (either separator style).
+func plexDir(file string) string {
+	if file == "" {
+		return ""
+	}
+	if strings.Contains(file, "\\") && !strings.Contains(file, "/") {
+		if i := strings.LastIndexByte(file, '\\'); i > 0 {
+			return file[:i]
+		}
+		return ""
+	}
+	return path.Dir(file)
+}
+
+// sortedKeys returns a set's keys in sorted order.
+func sortedKeys(m map[string]struct{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// RefreshSectionPath asks Plex to rescan one folder of a library.
+func (c *Client) RefreshSectionPath(ctx context.Context, sectionID, dir string) error {
+	q := url.Values{}
+	q.Set("path", dir)
+	return c.send(ctx, http.MethodGet, c.buildURL("/library/sections/"+sectionID+"/refresh", q))
+}
+
+// EmptyTrash removes media Plex has marked unavailable from a library.
+func (c *Client) EmptyTrash(ctx context.Context, sectionID string) error {
+	return c.send(ctx, http.MethodPut, c.buildURL("/library/sections/"+sectionID+"/emptyTrash", nil))
+}
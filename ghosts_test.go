@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const ghostItemXML = `<?xml version="1.0"?>
+<MediaContainer>
+  <Video ratingKey="300" title="Ghosty" year="2001">
+    <Media id="g1" videoResolution="1080" width="1920" height="1080">
+      <Part id="gp1" file="/movies/Ghosty/Ghosty.mkv" size="1000" exists="1" accessible="1" />
+    </Media>
+    <Media id="g2" videoResolution="1080" width="1920" height="1080">
+      <Part id="gp2" file="/old/Ghosty/Ghosty.avi" size="900" exists="0" accessible="0" />
+    </Media>
+  </Video>
+</MediaContainer>`
+
+const ghostItemCleanXML = `<?xml version="1.0"?>
+<MediaContainer>
+  <Video ratingKey="300" title="Ghosty" year="2001">
+    <Media id="g1" videoResolution="1080" width="1920" height="1080">
+      <Part id="gp1" file="/movies/Ghosty/Ghosty.mkv" size="1000" exists="1" accessible="1" />
+    </Media>
+  </Video>
+</MediaContainer>`
+
+func TestCollectRun_FixGhosts(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		refresh  []string
+		emptied  bool
+		trashHit int
+	)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/library/sections", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`<MediaContainer><Directory key="1" type="movie" title="Movies"><Location path="/movies" /></Directory></MediaContainer>`))
+	})
+	mux.HandleFunc("/library/sections/1/all", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(ghostItemXML))
+	})
+	mux.HandleFunc("/library/sections/1/refresh", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		refresh = append(refresh, r.URL.Query().Get("path"))
+		mu.Unlock()
+	})
+	mux.HandleFunc("/library/sections/1/emptyTrash", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut {
+			t.Errorf("expected PUT for emptyTrash, got %s", r.Method)
+		}
+		mu.Lock()
+		emptied = true
+		trashHit++
+		mu.Unlock()
+	})
+	mux.HandleFunc("/library/metadata/300", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		done := emptied
+		mu.Unlock()
+		if done {
+			_, _ = w.Write([]byte(ghostItemCleanXML))
+			return
+		}
+		_, _ = w.Write([]byte(ghostItemXML))
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	o := Options{
+		BaseURL:       ts.URL,
+		Token:         "fake",
+		Deep:          true,
+		Verify:        true,
+		DupPolicy:     "plex",
+		Timeout:       5 * time.Second,
+		FixGhosts:     true,
+		MaxGhostRatio: 0.5,
+	}
+	pc, err := NewClient(o)
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	out, err := RunCollection(context.Background(), pc, o)
+	if err != nil {
+		t.Fatalf("RunCollection: %v", err)
+	}
+
+	gc := out.GhostCleanup
+	if gc == nil || len(gc.Sections) != 1 {
+		t.Fatalf("expected ghost cleanup for one section, got %+v", gc)
+	}
+	if gc.GhostsBefore != 1 || gc.GhostsAfter != 0 {
+		t.Fatalf("expected 1 ghost before and 0 after, got %d/%d", gc.GhostsBefore, gc.GhostsAfter)
+	}
+	if len(refresh) != 1 || refresh[0] != "/old/Ghosty" {
+		t.Fatalf("expected a refresh of the ghost's folder, got %v", refresh)
+	}
+	if trashHit != 1 || !gc.Sections[0].TrashEmptied {
+		t.Fatalf("expected trash emptied once, got %d", trashHit)
+	}
+	if out.Summary.Degraded {
+		t.Fatalf("did not expect errors: %+v", out.Errors)
+	}
+}
+
+// fixGhostsServer serves one movie library (sectionXML) whose listing
+// returns listXML and whose deep fetches return items by rating key (a 500
+// for others), and counts refresh and emptyTrash calls.
+func fixGhostsServer(t *testing.T, sectionXML, listXML string, items map[string]string) (o Options, refreshes, trashes *int) {
+	t.Helper()
+	var mu sync.Mutex
+	refreshes, trashes = new(int), new(int)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/library/sections", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`<MediaContainer>` + sectionXML + `</MediaContainer>`))
+	})
+	mux.HandleFunc("/library/sections/1/all", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(listXML))
+	})
+	mux.HandleFunc("/library/sections/1/refresh", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		*refreshes++
+		mu.Unlock()
+	})
+	mux.HandleFunc("/library/sections/1/emptyTrash", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		*trashes++
+		mu.Unlock()
+	})
+	mux.HandleFunc("/library/metadata/", func(w http.ResponseWriter, r *http.Request) {
+		item, ok := items[strings.TrimPrefix(r.URL.Path, "/library/metadata/")]
+		if !ok {
+			http.Error(w, "boom", http.StatusInternalServerError)
+			return
+		}
+		_, _ = w.Write([]byte(item))
+	})
+	ts := httptest.NewServer(mux)
+	t.Cleanup(ts.Close)
+
+	return Options{
+		BaseURL:       ts.URL,
+		Token:         "fake",
+		Deep:          true,
+		Verify:        true,
+		DupPolicy:     "plex",
+		Timeout:       5 * time.Second,
+		FixGhosts:     true,
+		MaxGhostRatio: 0.5,
+	}, refreshes, trashes
+}
+
+func runFixGhosts(t *testing.T, o Options) Output {
+	t.Helper()
+	pc, err := NewClient(o)
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	out, err := RunCollection(context.Background(), pc, o)
+	if err != nil {
+		t.Fatalf("RunCollection: %v", err)
+	}
+	return out
+}
+
+const ghostListXML = `<MediaContainer>
+  <Video ratingKey="300" title="Ghosty" year="2001">
+    <Media id="g1" videoResolution="1080"><Part id="gp1" file="/movies/Ghosty/Ghosty.mkv" size="1000" /></Media>
+    <Media id="g2" videoResolution="1080"><Part id="gp2" file="/old/Ghosty/Ghosty.avi" size="900" /></Media>
+  </Video>
+</MediaContainer>`
+
+func TestFixGhosts_FailedDeepFetchIsNotAGhost(t *testing.T) {
+	o, refreshes, trashes := fixGhostsServer(t, `<Directory key="1" type="movie" title="Movies" />`, ghostListXML, nil)
+	out := runFixGhosts(t, o)
+
+	if out.Summary.TotalGhostParts != 0 || !out.Summary.Degraded {
+		t.Errorf("ghosts = %d, degraded = %v; want 0 ghosts and a degraded scan", out.Summary.TotalGhostParts, out.Summary.Degraded)
+	}
+	if p := out.Sections[0].Items[0].Versions[0].Parts[0]; !p.Unverified {
+		t.Errorf("part of an item without deep fetch not marked unverified: %+v", p)
+	}
+	if *refreshes != 0 || *trashes != 0 || len(out.GhostCleanup.Sections) != 0 {
+		t.Errorf("cleanup ran on unverified parts: %d refreshes, %d trash, %+v", *refreshes, *trashes, out.GhostCleanup)
+	}
+}
+
+func TestFixGhosts_LooksLikeOutage(t *testing.T) {
+	allGone := strings.ReplaceAll(ghostItemXML, `exists="1" accessible="1"`, `exists="0" accessible="0"`)
+	cases := []struct {
+		name, section, item string
+	}{
+		{"ratio", `<Directory key="1" type="movie" title="Movies" />`, allGone},
+		{"folder", `<Directory key="1" type="movie" title="Movies"><Location path="/movies" /><Location path="/old/" /></Directory>`, ghostItemXML},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			o, refreshes, trashes := fixGhostsServer(t, c.section, ghostListXML, map[string]string{"300": c.item})
+			gc := runFixGhosts(t, o).GhostCleanup
+
+			if *refreshes != 0 || *trashes != 0 {
+				t.Errorf("library cleaned: %d refreshes, %d trash", *refreshes, *trashes)
+			}
+			if len(gc.Sections) != 1 || gc.Sections[0].Skipped == "" || gc.Sections[0].GhostsAfter != gc.Sections[0].GhostsBefore {
+				t.Errorf("cleanup = %+v, want one skipped section", gc)
+			}
+		})
+	}
+}
+
+func TestFixGhosts_OfflineFolderWithoutDuplicates(t *testing.T) {
+	// /offline holds a single-version item only, so the scan never checked it
+	section := `<Directory key="1" type="movie" title="Movies"><Location path="/movies" /><Location path="/offline" /></Directory>`
+	list := strings.Replace(ghostListXML, `</MediaContainer>`, `<Video ratingKey="301" title="Alone" year="2003">
+    <Media id="a1" videoResolution="1080"><Part id="ap1" file="/offline/Alone/Alone.mkv" size="800" /></Media>
+  </Video>
+</MediaContainer>`, 1)
+	alone := func(state string) string {
+		return `<MediaContainer><Video ratingKey="301" title="Alone" year="2003"><Media id="a1"><Part id="ap1" file="/offline/Alone/Alone.mkv" size="800" ` + state + ` /></Media></Video></MediaContainer>`
+	}
+	cases := []struct {
+		name, probe   string
+		wantRefreshes int
+	}{
+		{"missing", alone(`exists="0" accessible="0"`), 0}, // its files are gone too: left alone
+		{"unknown", "", 1}, // re-fetch failed: rescan, keep the trash
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			items := map[string]string{"300": ghostItemXML}
+			if c.probe != "" {
+				items["301"] = c.probe
+			}
+			o, refreshes, trashes := fixGhostsServer(t, section, list, items)
+			o.MaxGhostRatio = 1
+			gc := runFixGhosts(t, o).GhostCleanup
+
+			if *trashes != 0 || *refreshes != c.wantRefreshes {
+				t.Errorf("%d refreshes, %d trash; want %d refreshes and no trash", *refreshes, *trashes, c.wantRefreshes)
+			}
+			if len(gc.Sections) != 1 || gc.Sections[0].TrashEmptied || gc.Sections[0].Skipped == "" && gc.Sections[0].TrashKept == "" {
+				t.Errorf("cleanup = %+v, want the trash kept with a reason", gc)
+			}
+		})
+	}
+
+	// once the folder is shown to be online the trash is emptied
+	items := map[string]string{"300": ghostItemXML, "301": alone(`exists="1" accessible="1"`)}
+	o, _, trashes := fixGhostsServer(t, section, list, items)
+	if gc := runFixGhosts(t, o).GhostCleanup; *trashes != 1 || !gc.Sections[0].TrashEmptied {
+		t.Errorf("online library: %d trash, %+v", *trashes, gc)
+	}
+}
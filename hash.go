@@ -0,0 +1,479 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// -hash finds byte-identical copies: the same file stored twice under
+// different names, in different items, libraries or (with multi) servers.
+// It needs local access to the media (-path-map) and works in stages so
+// only plausible copies are read:
+//
+//  1. files are grouped by size; a file with a unique size has no copy;
+//  2. same-size files get a partial hash of their first and last MiB;
+//  3. with -hash-full, partial matches are confirmed with a full hash.
+//
+// Hashes are cached by path, keyed on size and mtime, so later runs only
+// read new or changed files.
+
+// hashChunk is how much of each end of a file the partial hash reads.
+const hashChunk = 1 << 20
+
+// hashRef is one reference to a media file: a part of a listed item.
+type hashRef struct {
+	IdenticalFile
+	size int64 // from Plex, for the size prefilter
+}
+
+// partRefs returns a hashRef for every part of the videos with a file path.
+func partRefs(sec Directory, vids []Video, pm PathMap) []hashRef {
+	var refs []hashRef
+	for _, v := range vids {
+		for _, m := range v.Media {
+			for _, p := range m.Part {
+				if p.File == "" || p.Size <= 0 {
+					continue
+				}
+				refs = append(refs, hashRef{
+					IdenticalFile: IdenticalFile{
+						SectionID:    sec.Key,
+						SectionTitle: sec.Title,
+						RatingKey:    v.RatingKey,
+						Title:        videoLabel(&v),
+						VersionID:    m.ID,
+						PartID:       p.ID,
+						File:         p.File,
+						LocalPath:    filepath.Clean(pm.Local(p.File)),
+					},
+					size: p.Size,
+				})
+			}
+		}
+	}
+	return refs
+}
+
+// videoLabel is a short display name for a movie or episode.
+func videoLabel(v *Video) string {
+	if v.Type == "episode" {
+		return fmt.Sprintf("%s S%02dE%02d", v.GrandparentTitle, v.ParentIndex, v.Index)
+	}
+	if v.Year > 0 {
+		return fmt.Sprintf("%s (%d)", v.Title, v.Year)
+	}
+	return v.Title
+}
+
+// hashedFile is a unique local file and what we learned about it.
+type hashedFile struct {
+	path    string
+	size    int64
+	modTime time.Time
+	partial string
+	full    string
+	err     error
+}
+
+// findIdentical groups the files referenced by refs that are byte-identical
+// (or, without full, identical in size and partial hash). A file referenced
+// more than once (e.g. by two servers sharing a mount) is one file, not a
+// copy. Files that can't be read are returned with the error.
+func findIdentical(ctx context.Context, refs []hashRef, cache *hashCache, full bool, workers int) ([]IdenticalGroup, []hashedFile, error) {
+	// 1. unique files with a size another file shares
+	bySize := map[int64][]string{}
+	seen := map[string]bool{}
+	for _, r := range refs {
+		if !seen[r.LocalPath] {
+			seen[r.LocalPath] = true
+			bySize[r.size] = append(bySize[r.size], r.LocalPath)
+		}
+	}
+	var files []*hashedFile
+	for _, paths := range bySize {
+		if len(paths) > 1 {
+			for _, p := range paths {
+				files = append(files, &hashedFile{path: p})
+			}
+		}
+	}
+
+	// 2. partial hashes, then 3. full hashes of partial matches
+	err := runPool(ctx, workers, len(files), func(ctx context.Context, i int) {
+		files[i].hash(ctx, cache, false)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	groups := groupFiles(files, func(f *hashedFile) string { return fmt.Sprintf("%d/%s", f.size, f.partial) })
+	if full {
+		var confirm []*hashedFile
+		for _, g := range groups {
+			confirm = append(confirm, g...)
+		}
+		err := runPool(ctx, workers, len(confirm), func(ctx context.Context, i int) {
+			confirm[i].hash(ctx, cache, true)
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		groups = groupFiles(confirm, func(f *hashedFile) string { return f.full })
+	} else {
+		// small files, and files a -hash-full run cached, are known in full
+		var split [][]*hashedFile
+		for _, g := range groups {
+			if allFull(g) {
+				split = append(split, groupFiles(g, func(f *hashedFile) string { return f.full })...)
+			} else {
+				split = append(split, g)
+			}
+		}
+		groups = split
+	}
+
+	// references per file, for the report
+	byPath := map[string][]IdenticalFile{}
+	for _, r := range refs {
+		byPath[r.LocalPath] = append(byPath[r.LocalPath], r.IdenticalFile)
+	}
+	var out []IdenticalGroup
+	for _, g := range groups {
+		f := g[0]
+		ig := IdenticalGroup{Size: f.size, Copies: len(g), RedundantBytes: f.size * int64(len(g)-1), Full: allFull(g)}
+		if ig.Full {
+			ig.Hash = "sha256:" + f.full
+		} else {
+			ig.Hash = "partial-sha256:" + f.partial
+		}
+		for _, hf := range g {
+			ig.Files = append(ig.Files, byPath[hf.path]...)
+		}
+		sort.SliceStable(ig.Files, func(a, b int) bool { return ig.Files[a].LocalPath < ig.Files[b].LocalPath })
+		out = append(out, ig)
+	}
+	sort.Slice(out, func(a, b int) bool {
+		if out[a].RedundantBytes != out[b].RedundantBytes {
+			return out[a].RedundantBytes > out[b].RedundantBytes
+		}
+		return out[a].Hash < out[b].Hash
+	})
+
+	var failed []hashedFile
+	for _, f := range files {
+		if f.err != nil {
+			failed = append(failed, *f)
+		}
+	}
+	return out, failed, nil
+}
+
+// allFull reports whether every file's full hash is known.
+func allFull(g []*hashedFile) bool {
+	for _, f := range g {
+		if f.full == "" {
+			return false
+		}
+	}
+	return true
+}
+
+// groupFiles groups files without errors by key and keeps groups of two or more.
+func groupFiles(files []*hashedFile, key func(*hashedFile) string) [][]*hashedFile {
+	idx := map[string]int{}
+	var groups [][]*hashedFile
+	for _, f := range files {
+		if f.err != nil {
+			continue
+		}
+		k := key(f)
+		i, ok := idx[k]
+		if !ok {
+			i = len(groups)
+			idx[k] = i
+			groups = append(groups, nil)
+		}
+		groups[i] = append(groups[i], f)
+	}
+	var out [][]*hashedFile
+	for _, g := range groups {
+		if len(g) > 1 {
+			sort.Slice(g, func(a, b int) bool { return g[a].path < g[b].path })
+			out = append(out, g)
+		}
+	}
+	return out
+}
+
+// hash fills in the file's partial (or full) hash, from the cache when the
+// file's size and mtime are unchanged. Files of up to two chunks are read
+// whole, so their partial hash is also the full one.
+func (f *hashedFile) hash(ctx context.Context, cache *hashCache, full bool) {
+	if f.err != nil || (!full && f.partial != "") || (full && f.full != "") {
+		return
+	}
+	if f.modTime.IsZero() {
+		fi, err := os.Stat(f.path)
+		if err != nil {
+			f.err = err
+			return
+		}
+		f.size, f.modTime = fi.Size(), fi.ModTime()
+	}
+	if e, ok := cache.get(f.path, f.size, f.modTime); ok {
+		f.partial, f.full = fallback(f.partial, e.Partial), fallback(f.full, e.Full)
+	}
+	if (!full && f.partial != "") || (full && f.full != "") {
+		return
+	}
+
+	sum, err := hashFile(ctx, f.path, f.size, full)
+	if err != nil {
+		f.err = err
+		return
+	}
+	if full {
+		f.full = sum
+	} else {
+		f.partial = sum
+		if f.size <= 2*hashChunk {
+			f.full = sum
+		}
+	}
+	cache.put(f.path, hashCacheEntry{Size: f.size, ModTime: f.modTime.UnixNano(), Partial: f.partial, Full: f.full})
+}
+
+// hashFile returns the hex SHA-256 of the whole file, or of its first and
+// last hashChunk bytes.
+func hashFile(ctx context.Context, path string, size int64, full bool) (string, error) {
+	fh, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer fh.Close()
+	h := sha256.New()
+	switch {
+	case full || size <= 2*hashChunk:
+		_, err = io.Copy(h, ctxReader{ctx, fh})
+	default:
+		if _, err = io.Copy(h, io.NewSectionReader(fh, 0, hashChunk)); err == nil {
+			_, err = io.Copy(h, io.NewSectionReader(fh, size-hashChunk, hashChunk))
+		}
+	}
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// ctxReader stops a long read (a full hash of a large file) when ctx ends.
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c ctxReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
+
+// hashCache is the persistent hash cache, a JSON file keyed by path.
+type hashCache struct {
+	path  string // "" for an in-memory cache
+	mu    sync.Mutex
+	files map[string]hashCacheEntry
+	dirty bool
+}
+
+type hashCacheEntry struct {
+	Size    int64  `json:"size"`
+	ModTime int64  `json:"mtime"` // Unix nanoseconds
+	Partial string `json:"partial,omitempty"`
+	Full    string `json:"full,omitempty"`
+}
+
+// hashCacheFile is the on-disk format.
+type hashCacheFile struct {
+	Version int                       `json:"version"`
+	Files   map[string]hashCacheEntry `json:"files"`
+}
+
+// defaultHashCachePath is $XDG_CACHE_HOME/goplexr/hashes.json (or the OS equivalent).
+func defaultHashCachePath() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "goplexr", "hashes.json")
+}
+
+// openCaches shares one hashCache per file between the scans of a run
+// (multi scans servers in parallel).
+var (
+	openCachesMu sync.Mutex
+	openCaches   = map[string]*hashCache{}
+)
+
+// openHashCache returns the cache at path ("" for the default, "-" for no
+// cache file). A missing file is an empty cache; so is an unreadable one,
+// which is reported and overwritten on save.
+func openHashCache(path string) (*hashCache, error) {
+	if path == "" {
+		path = defaultHashCachePath()
+	}
+	if path == "-" || path == "" {
+		return &hashCache{files: map[string]hashCacheEntry{}}, nil
+	}
+	openCachesMu.Lock()
+	defer openCachesMu.Unlock()
+	if c, ok := openCaches[path]; ok {
+		return c, nil
+	}
+	c, err := loadHashCache(path)
+	openCaches[path] = c
+	return c, err
+}
+
+// loadHashCache reads the cache file at path.
+func loadHashCache(path string) (*hashCache, error) {
+	c := &hashCache{files: map[string]hashCacheEntry{}}
+	c.path = path
+	var f hashCacheFile
+	if err := readJSONFile(path, &f); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return c, nil
+		}
+		return c, fmt.Errorf("hash cache %s: %w (starting a new one)", path, err)
+	}
+	if f.Files != nil {
+		c.files = f.Files
+	}
+	return c, nil
+}
+
+func (c *hashCache) get(path string, size int64, modTime time.Time) (hashCacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.files[path]
+	if !ok || e.Size != size || e.ModTime != modTime.UnixNano() {
+		return hashCacheEntry{}, false
+	}
+	return e, true
+}
+
+func (c *hashCache) put(path string, e hashCacheEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.files[path] = e
+	c.dirty = true
+}
+
+// save writes the cache if it changed.
+func (c *hashCache) save() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.path == "" || !c.dirty {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(c.path), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(c.path), ".hashes-*.json")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := json.NewEncoder(tmp).Encode(hashCacheFile{Version: 1, Files: c.files}); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), c.path); err != nil {
+		return err
+	}
+	c.dirty = false
+	return nil
+}
+
+// hashIdentical runs the hashing stages over refs with the -hash-cache and
+// returns the groups of identical files. Files that can't be read are
+// appended to errs.
+func hashIdentical(ctx context.Context, o Options, refs []hashRef, errs *[]ScanError) ([]IdenticalGroup, error) {
+	cache, err := openHashCache(o.HashCache)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "WARN:", err)
+	}
+	groups, failed, err := findIdentical(ctx, refs, cache, o.HashFull, o.Concurrency)
+	if err != nil {
+		return nil, err
+	}
+	if err := cache.save(); err != nil {
+		fmt.Fprintln(os.Stderr, "WARN: save hash cache:", err)
+	}
+	for _, f := range failed {
+		for _, r := range refs {
+			if r.LocalPath == f.path {
+				*errs = append(*errs, ScanError{SectionID: r.SectionID, RatingKey: r.RatingKey, Message: fmt.Sprintf("hash: %s: %s", f.path, localErrorCode(f.err))})
+				break
+			}
+		}
+	}
+	return groups, nil
+}
+
+// outputItems returns every item of out (and ignored) that can hold parts.
+func outputItems(out *Output, ignored []IgnoredItem) []*Item {
+	var items []*Item
+	for si := range out.Sections {
+		for ii := range out.Sections[si].Items {
+			items = append(items, &out.Sections[si].Items[ii])
+		}
+	}
+	for i := range ignored {
+		items = append(items, &ignored[i].Item)
+	}
+	for gi := range out.SplitDuplicates {
+		for ii := range out.SplitDuplicates[gi].Items {
+			items = append(items, &out.SplitDuplicates[gi].Items[ii])
+		}
+	}
+	for gi := range out.CrossLibrary {
+		items = append(items, &out.CrossLibrary[gi].Item)
+	}
+	return items
+}
+
+// markIdentical sets IdenticalHash on every part of items whose file is in
+// one of the groups. Part IDs are unique on a server, so merged items
+// (cross-library groups) match too.
+func markIdentical(groups []IdenticalGroup, items ...*Item) {
+	ids := map[string]string{}
+	for _, g := range groups {
+		for _, f := range g.Files {
+			ids[f.PartID] = g.Hash
+		}
+	}
+	for _, it := range items {
+		for vi := range it.Versions {
+			for pi := range it.Versions[vi].Parts {
+				p := &it.Versions[vi].Parts[pi]
+				if h, ok := ids[p.ID]; ok {
+					p.IdenticalHash = h
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFindIdentical(t *testing.T) {
+	dir := t.TempDir()
+	big := make([]byte, 3*hashChunk)
+	for i := range big {
+		big[i] = byte(i % 251)
+	}
+	middle := append([]byte(nil), big...)
+	middle[len(middle)/2] ^= 1 // same first and last MiB
+	head := append([]byte(nil), big...)
+	head[0] ^= 1
+	write := func(name string, b []byte) string {
+		fn := filepath.Join(dir, name)
+		if err := os.WriteFile(fn, b, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return fn
+	}
+	files := map[string]string{
+		"a": write("a.mkv", big),
+		"b": write("b.mkv", big),
+		"c": write("c.mkv", middle),
+		"d": write("d.mkv", head),
+		"e": write("e.mkv", []byte("small file")),
+		"f": write("f.mkv", []byte("small file")),
+		"g": write("g.mkv", []byte("unique")),
+	}
+	ref := func(part, name string, size int64) hashRef {
+		return hashRef{IdenticalFile: IdenticalFile{SectionID: "1", RatingKey: part, PartID: part, LocalPath: files[name]}, size: size}
+	}
+	refs := []hashRef{
+		ref("1", "a", int64(len(big))), ref("2", "b", int64(len(big))), ref("3", "c", int64(len(big))), ref("4", "d", int64(len(big))),
+		ref("5", "e", 10), ref("6", "f", 10), ref("7", "g", 6),
+		ref("8", "a", int64(len(big))), // a second reference to a is not a copy
+		{IdenticalFile: IdenticalFile{PartID: "9", LocalPath: filepath.Join(dir, "gone.mkv")}, size: 6},
+	}
+
+	cachePath := filepath.Join(dir, "cache", "hashes.json")
+	cache, err := loadHashCache(cachePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	groups, failed, err := findIdentical(context.Background(), refs, cache, false, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(groups) != 2 {
+		t.Fatalf("groups = %+v, want a/b/c (partial) and e/f", groups)
+	}
+	if g := groups[0]; g.Full || g.Copies != 3 || len(g.Files) != 4 || !strings.HasPrefix(g.Hash, "partial-sha256:") || g.RedundantBytes != 2*int64(len(big)) {
+		t.Errorf("partial group = %+v", g)
+	}
+	if g := groups[1]; !g.Full || g.Copies != 2 || !strings.HasPrefix(g.Hash, "sha256:") {
+		t.Errorf("small group = %+v", g)
+	}
+	if len(failed) != 1 || failed[0].path != filepath.Join(dir, "gone.mkv") {
+		t.Errorf("failed = %+v", failed)
+	}
+
+	groups, _, err = findIdentical(context.Background(), refs, cache, true, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(groups) != 2 || !groups[0].Full || groups[0].Copies != 2 || groups[0].Files[0].LocalPath != files["a"] {
+		t.Fatalf("full groups = %+v, want a/b confirmed and c dropped", groups)
+	}
+
+	// the cache survives a reload and is used while size and mtime match
+	if err := cache.save(); err != nil {
+		t.Fatal(err)
+	}
+	reloaded, err := loadHashCache(cachePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fi, _ := os.Stat(files["a"])
+	e, ok := reloaded.get(files["a"], fi.Size(), fi.ModTime())
+	if !ok || e.Partial == "" || e.Full == "" {
+		t.Errorf("cache entry for a = %+v, %v", e, ok)
+	}
+	if _, ok := reloaded.get(files["a"], fi.Size()+1, fi.ModTime()); ok {
+		t.Error("cache hit for a changed size")
+	}
+}
+
+func TestMarkIdentical(t *testing.T) {
+	groups := []IdenticalGroup{{Hash: "sha256:x", Files: []IdenticalFile{{PartID: "10"}, {PartID: "20"}}}}
+	it := Item{RatingKey: "1", Versions: []Version{{Parts: []PartOut{{ID: "10"}}}, {Parts: []PartOut{{ID: "11"}}}}}
+	markIdentical(groups, &it)
+	if it.Versions[0].Parts[0].IdenticalHash != "sha256:x" || it.Versions[1].Parts[0].IdenticalHash != "" {
+		t.Errorf("parts = %+v", it.Versions)
+	}
+}
+
+func TestRunMulti_IdenticalAcrossServers(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/library/sections", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(sectionsXML))
+	})
+	mux.HandleFunc("/library/sections/1/all", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(duplicatesXML))
+	})
+	mux.HandleFunc("/library/metadata/100", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(metadata100XML))
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	// both "servers" hold the same two files, in different folders
+	dirs := [2]string{t.TempDir(), t.TempDir()}
+	for _, d := range dirs {
+		_ = os.WriteFile(filepath.Join(d, "dup1.mkv"), bytes.Repeat([]byte("1"), 1000), 0o644)
+		_ = os.WriteFile(filepath.Join(d, "dup2.mkv"), bytes.Repeat([]byte("2"), 2000), 0o644)
+	}
+	out := t.TempDir()
+	c, err := LoadMultiConfig(writeMultiConfig(t, `{
+  "out_dir": "`+out+`",
+  "defaults": {"dup_policy": "plex", "hash": true, "hash_cache": "`+filepath.Join(out, "hashes.json")+`"},
+  "servers": [
+    {"name": "a", "url": "`+ts.URL+`", "token": "t", "path_map": "/path=`+dirs[0]+`"},
+    {"name": "b", "url": "`+ts.URL+`", "token": "t", "path_map": "/path=`+dirs[1]+`"}
+  ]
+}`))
+	if err != nil {
+		t.Fatalf("LoadMultiConfig: %v", err)
+	}
+	idx, err := RunMulti(context.Background(), c, io.Discard)
+	if err != nil {
+		t.Fatalf("RunMulti: %v", err)
+	}
+	if len(idx.Identical) != 2 {
+		t.Fatalf("identical = %+v, want dup1 and dup2 on both servers", idx.Identical)
+	}
+	g := idx.Identical[0]
+	if !g.Full || g.Size != 2000 || len(g.Files) != 2 || g.Files[0].Server == g.Files[1].Server {
+		t.Errorf("group = %+v", g)
+	}
+	if _, err := os.Stat(filepath.Join(out, "hashes.json")); err != nil {
+		t.Errorf("hash cache not written: %v", err)
+	}
+	b, _ := os.ReadFile(filepath.Join(out, "index.html"))
+	if !strings.Contains(string(b), "Identical files on more than one server") {
+		t.Errorf("index.html does not list the identical files")
+	}
+}
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"fmt"
+	"html"
 	"html/template"
 	"os"
 	"path/filepath"
@@ -30,13 +32,10 @@ func RenderHTML(out Output, verify bool, ignoreExtras bool, filename string) err
 			return len(it.Versions)
 		},
 		"itemGhostCount": func(it Item, verify bool) int {
-			if !verify {
-				return 0
-			}
 			n := 0
 			for _, v := range it.Versions {
 				for _, p := range v.Parts {
-					if !p.VerifiedOnDisk {
+					if partIsGhost(p, verify) {
 						n++
 					}
 				}
@@ -51,13 +50,64 @@ func RenderHTML(out Output, verify bool, ignoreExtras bool, filename string) err
 			}
 			return s
 		},
+		"dupItem": func(it Item, verify bool) dupItemView {
+			return dupItemView{It: it, Verify: verify}
+		},
+		"partStatus": func(p PartOut, verify bool) partStatusView {
+			return partStatusView{P: p, Verify: verify}
+		},
+		"localErrorLabel":   localErrorLabel,
+		"dynamicRangeLabel": dynamicRangeLabel,
+		"audioLabel":        audioLabel,
+		"subtitleLabel":     subtitleLabel,
+		"itemLabel":         itemLabel,
+		"showViews":         showViews,
+		"seasonLabel": func(n int) string {
+			if n == 0 {
+				return "Specials"
+			}
+			return fmt.Sprintf("Season %d", n)
+		},
 		"policyName": func(s string) string {
-			switch strings.ToLower(strings.TrimSpace(s)) {
-			case "ignore-4k-1080":
-				return "Policy: Ignore 4K+HD pair (1080/720)"
-			default:
-				return "Policy: Plex (all multi-version)"
+			ps, err := ParsePolicies(s)
+			if err != nil {
+				return "Policy: " + s
+			}
+			return "Policy: " + ps.Label()
+		},
+		"policyGroups": policyGroups,
+		"reasonTitle": func(r string) string {
+			return reasonInfo(r).Title
+		},
+		"storageBreakdowns": storageBreakdowns,
+		"versionPick": func(it Item, versionID string) string {
+			if it.Recommendation == nil {
+				return ""
+			}
+			if it.Recommendation.Keep == versionID {
+				return "keep"
 			}
+			for _, g := range it.Editions {
+				if g.Keep == versionID {
+					return "keep"
+				}
+			}
+			for _, c := range it.Recommendation.CandidatesForRemoval {
+				if c.VersionID == versionID {
+					return "remove"
+				}
+			}
+			return ""
+		},
+		"removalReasons": func(it Item, versionID string) string {
+			if it.Recommendation != nil {
+				for _, c := range it.Recommendation.CandidatesForRemoval {
+					if c.VersionID == versionID {
+						return strings.Join(c.Reasons, "; ")
+					}
+				}
+			}
+			return ""
 		},
 		"filterIgnoredBy": func(items []IgnoredItem, reason string) []IgnoredItem {
 			out := make([]IgnoredItem, 0, len(items))
@@ -79,7 +129,77 @@ func RenderHTML(out Output, verify bool, ignoreExtras bool, filename string) err
 		},
 	}
 
-	const tpl = `<!doctype html>
+	const tpl = `{{ define "partStatus" }}
+{{ if .Verify }}
+  {{ if .P.Unverified }}<span class="chip warn" title="the item's deep fetch failed">Not verified</span>{{ else if .P.VerifiedOnDisk }}<span class="chip ok">Verified</span>{{ else }}<span class="chip bad">{{ if .P.LocalError }}{{ localErrorLabel .P.LocalError }}{{ else }}Missing/Unreachable{{ end }}</span>{{ end }}
+  {{ if .P.SizeMismatch }}<span class="chip warn" title="Plex: {{ bytesHuman .P.Size }}, on disk: {{ bytesHuman .P.LocalSize }}">Size mismatch</span>{{ end }}
+  {{ if and .P.LocalPath (ne .P.LocalPath .P.File) }}<div class="muted small">local: <code>{{ .P.LocalPath }}</code></div>{{ end }}
+  {{ with .P.LocalModTime }}<div class="muted small">modified {{ .Format "2006-01-02 15:04" }}</div>{{ end }}
+{{ else }}<span class="chip warn">Not checked</span>{{ end }}
+{{ with .P.IdenticalHash }}<span class="chip warn" title="{{ . }}">Identical copy</span>{{ end }}
+{{ end -}}
+{{ define "streams" }}
+{{ with .Video }}{{ with .DynamicRange }}<span class="chip{{ if ne . "sdr" }} ok{{ end }}">{{ dynamicRangeLabel . }}</span>{{ end }}{{ with .BitDepth }} <span class="muted small">{{ . }}-bit</span>{{ end }}{{ end }}
+{{ with .Audio }}<div class="muted small">Audio: {{ range $i, $a := . }}{{ if $i }}, {{ end }}{{ audioLabel $a }}{{ end }}</div>{{ end }}
+{{ with .Subtitles }}<div class="muted small">Subs: {{ range $i, $s := . }}{{ if $i }}, {{ end }}{{ subtitleLabel $s }}{{ end }}</div>{{ end }}
+{{ end -}}
+{{ define "storageBreakdowns" }}
+{{ range storageBreakdowns . }}
+<table>
+  <thead><tr><th>{{ .Title }}</th><th>Parts</th><th>Size</th><th>Reclaimable</th><th>Ghost</th></tr></thead>
+  <tbody>
+    {{ range .Buckets }}
+    <tr>
+      <td><code>{{ .Key }}</code></td>
+      <td>{{ comma .Parts }}</td>
+      <td>{{ bytesHuman .Bytes }}</td>
+      <td>{{ bytesHuman .ReclaimableBytes }}</td>
+      <td>{{ if gt .GhostBytes 0 }}<span class="chip bad">{{ bytesHuman .GhostBytes }}</span>{{ else }}{{ bytesHuman .GhostBytes }}{{ end }}</td>
+    </tr>
+    {{ end }}
+  </tbody>
+</table>
+{{ end }}
+{{ end -}}
+{{ define "dupItem" }}
+<details>
+  <summary>
+    {{ itemLabel .It }}
+    <span class="badge">{{ itemVersionCount .It }} versions</span>
+    {{ range .It.Flags }}<span class="badge warn" title="{{ .Reason }}">Flag: {{ .Rule }}</span>{{ end }}
+    {{ with .It.Recommendation }}{{ if gt .ReclaimableBytes 0 }}<span class="badge">reclaim {{ bytesHuman .ReclaimableBytes }}</span>{{ end }}{{ end }}
+    {{ $gc := itemGhostCount .It .Verify }}
+    {{ if $.Verify }}
+      {{ if gt $gc 0 }}<span class="badge bad">{{ $gc }} ghost{{ if gt $gc 1 }}s{{ end }}</span>{{ else }}<span class="badge ok">no ghosts</span>{{ end }}
+    {{ else }}<span class="badge warn">verification off</span>{{ end }}
+  </summary>
+  <table>
+    <thead><tr><th>Pick</th><th>Version</th><th>Codec</th><th>Resolution</th><th>Part File</th><th>Size</th><th>Status</th></tr></thead>
+    <tbody>
+      {{ range $v := .It.Versions }}
+        {{ range $p := $v.Parts }}
+        <tr>
+          <td>
+            {{ $pick := versionPick $.It $v.ID }}
+            {{ if eq $pick "keep" }}<span class="chip ok" title="score {{ $v.Score }}">Keep</span>{{ else if eq $pick "remove" }}<span class="chip warn" title="{{ removalReasons $.It $v.ID }}">Remove</span>{{ end }}
+          </td>
+          <td><code>{{ $v.Container }}</code>{{ with $v.Edition }}<span class="badge">{{ . }}</span>{{ end }}</td>
+          <td><span class="muted">{{ $v.VideoCodec }}</span> / <span class="muted">{{ $v.AudioCodec }}</span>{{ template "streams" $v }}</td>
+          <td>{{ $v.VideoResolution }} ({{ $v.Width }}×{{ $v.Height }})</td>
+          <td><code>{{ $p.File }}</code></td>
+          <td>{{ bytesHuman $p.Size }}</td>
+          <td>
+            {{ template "partStatus" (partStatus $p $.Verify) }}
+          </td>
+        </tr>
+        {{ end }}
+      {{ end }}
+    </tbody>
+  </table>
+</details>
+{{ end -}}
+
+<!doctype html>
 <html lang="en">
 <head>
 <meta charset="utf-8">
@@ -138,11 +258,15 @@ hr{border:none;height:1px;background:var(--border);margin:20px 0}
     <div class="muted small">Generated: {{ .Generated }} &nbsp;•&nbsp; Server: <code>{{ .Out.Server }}</code></div>
     <div class="chips" style="margin-top:8px">
       {{ if .Verify }}
-        <span class="chip ok">Verification: On (checkFiles)</span>
+        <span class="chip ok">Verification: On ({{ if .Out.Summary.LocalVerification }}local files{{ else }}checkFiles{{ end }})</span>
+        {{ with .Out.Summary.SizeMismatchParts }}<span class="chip warn">Size mismatches: {{ comma . }}</span>{{ end }}
       {{ else }}
         <span class="chip warn">Verification: Off (ghost counts not checked)</span>
       {{ end }}
       <span class="chip">{{ policyName .Out.Summary.DuplicatePolicy }}</span>
+      {{ if .Out.Summary.PolicyFile }}<span class="chip">Rules: <code>{{ .Out.Summary.PolicyFile }}</code></span>{{ end }}
+      {{ if .Out.Summary.Degraded }}<span class="chip bad">Partial scan: {{ comma (len .Out.Errors) }} failed request{{ if gt (len .Out.Errors) 1 }}s{{ end }}</span>{{ end }}
+      {{ if gt .Out.Summary.HTTPRetries 0 }}<span class="chip warn">HTTP retries: {{ comma .Out.Summary.HTTPRetries }}</span>{{ end }}
       <span class="chip">{{ if .IgnoreExtras }}Extras: Ignored ({{ lenIgnoredBy .Out.Ignored "extra_version" }}){{ else }}Extras: Included{{ end }}</span>
     </div>
   </header>
@@ -154,8 +278,21 @@ hr{border:none;height:1px;background:var(--border);margin:20px 0}
       <div class="card"><h3>Total Libraries</h3><div style="font-size:26px;font-weight:700">{{ comma .Out.Summary.TotalLibraries }}</div></div>
       <div class="card"><h3>Total Versions</h3><div style="font-size:26px;font-weight:700">{{ comma .Out.TotalVersions }}</div></div>
       <div class="card"><h3>Total Ghost Parts</h3><div style="font-size:26px;font-weight:700">{{ comma .Out.Summary.TotalGhostParts }}</div></div>
+      <div class="card"><h3>Reclaimable</h3><div style="font-size:26px;font-weight:700">{{ bytesHuman .Out.Summary.ReclaimableBytes }}</div></div>
+      {{ if gt .Out.Summary.SplitDuplicateGroups 0 }}
+      <div class="card"><h3>Split Duplicates</h3><div style="font-size:26px;font-weight:700">{{ comma .Out.Summary.SplitDuplicateGroups }}</div></div>
+      {{ end }}
+      {{ if .Out.Orphans }}
+      <div class="card"><h3>Orphan Files</h3><div style="font-size:26px;font-weight:700">{{ comma .Out.Summary.OrphanFiles }}</div><div class="muted small">{{ bytesHuman .Out.Summary.OrphanBytes }}</div></div>
+      {{ end }}
+      {{ if .Out.Identical }}
+      <div class="card"><h3>Identical Files</h3><div style="font-size:26px;font-weight:700">{{ comma .Out.Summary.IdenticalGroups }}</div><div class="muted small">{{ bytesHuman .Out.Summary.IdenticalBytes }} in extra copies</div></div>
+      {{ end }}
+      {{ if .Out.CrossLibrary }}
+      <div class="card"><h3>Cross-Library</h3><div style="font-size:26px;font-weight:700">{{ comma .Out.Summary.CrossLibraryGroups }}</div></div>
+      {{ end }}
       {{ if gt .Out.Summary.VariantItemsExcluded 0 }}
-      <div class="card"><h3>4K+HD Pairs Ignored</h3><div style="font-size:26px;font-weight:700">{{ comma .Out.Summary.VariantItemsExcluded }}</div></div>
+      <div class="card"><h3>Ignored by Policy</h3><div style="font-size:26px;font-weight:700">{{ comma .Out.Summary.VariantItemsExcluded }}</div></div>
       {{ end }}
     </div>
 
@@ -172,15 +309,86 @@ hr{border:none;height:1px;background:var(--border);margin:20px 0}
           <div class="kv"><span>Total versions</span><strong>{{ comma .TotalVersions }}</strong></div>
           <div class="kv"><span>Items with ghosts</span><strong>{{ comma .ItemsWithGhosts }}</strong></div>
           <div class="kv"><span>Ghost parts</span><strong>{{ comma .GhostParts }}</strong></div>
+          <div class="kv"><span>Reclaimable (keep best)</span><strong>{{ bytesHuman .ReclaimableBytes }}</strong></div>
           {{ if gt .VariantsExcluded 0 }}
-          <div class="kv"><span>4K+HD Pairs Ignored</span><strong>{{ comma .VariantsExcluded }}</strong></div>
+          <div class="kv"><span>Ignored by policy</span><strong>{{ comma .VariantsExcluded }}</strong></div>
           {{ end }}
+          <div class="kv"><span>In duplicate versions</span><strong>{{ bytesHuman .Storage.DuplicateBytes }}</strong></div>
+          <div class="kv"><span>In ghost parts</span><strong>{{ bytesHuman .Storage.GhostBytes }}</strong></div>
         </div>
+        {{ if gt .Storage.DuplicateBytes 0 }}
+        <details style="margin-top:8px">
+          <summary>Storage breakdown</summary>
+          {{ template "storageBreakdowns" .Storage }}
+        </details>
+        {{ end }}
       </div>
       {{ end }}
     </div>
   </section>
 
+  {{ with .Out.Summary.Storage }}{{ if gt .DuplicateBytes 0 }}
+  <section class="panel" style="margin-top:16px">
+    <h2>Storage</h2>
+    <div class="grid grid-2">
+      <div class="kv"><span>In duplicate versions</span><strong>{{ bytesHuman .DuplicateBytes }}</strong></div>
+      <div class="kv"><span>Reclaimable (keep best)</span><strong>{{ bytesHuman .ReclaimableBytes }}</strong></div>
+      <div class="kv"><span>In ghost parts</span><strong>{{ bytesHuman .GhostBytes }}</strong></div>
+    </div>
+    {{ template "storageBreakdowns" . }}
+  </section>
+  {{ end }}{{ end }}
+
+  {{ with .Out.GhostCleanup }}
+  <section class="panel" style="margin-top:16px">
+    <h2>Ghost Cleanup</h2>
+    <div class="grid grid-2">
+      <div class="kv"><span>Ghost parts before</span><strong>{{ comma .GhostsBefore }}</strong></div>
+      <div class="kv"><span>Ghost parts after</span><strong>{{ comma .GhostsAfter }}</strong></div>
+    </div>
+    {{ if .Sections }}
+    <table>
+      <thead><tr><th>Library</th><th>Refreshed folders</th><th>Trash emptied</th><th>Items checked</th><th>Before</th><th>After</th></tr></thead>
+      <tbody>
+        {{ range .Sections }}
+        <tr>
+          <td>{{ .SectionTitle }}</td>
+          <td>{{ range .RefreshedPaths }}<code>{{ . }}</code><br>{{ end }}</td>
+          <td>{{ if .TrashEmptied }}<span class="chip ok">Yes</span>{{ else }}<span class="chip bad">No</span>{{ end }}{{ with or .Skipped .TrashKept }}<div class="muted">{{ . }}</div>{{ end }}</td>
+          <td>{{ comma .ItemsChecked }}{{ if .ItemsRemoved }} ({{ comma .ItemsRemoved }} removed){{ end }}</td>
+          <td>{{ comma .GhostsBefore }}</td>
+          <td>{{ if gt .GhostsAfter 0 }}<span class="chip bad">{{ comma .GhostsAfter }}</span>{{ else }}<span class="chip ok">0</span>{{ end }}</td>
+        </tr>
+        {{ end }}
+      </tbody>
+    </table>
+    {{ else }}
+    <div class="muted">No ghost parts found; nothing to clean up.</div>
+    {{ end }}
+  </section>
+  {{ end }}
+
+  {{ if .Out.Errors }}
+  <section class="panel" style="margin-top:16px">
+    <h2>Errors</h2>
+    <div class="muted small">These requests failed after retries. Affected libraries are missing from the report; affected items show shallow (non-verified) data.</div>
+    <table>
+      <thead><tr><th>Library</th><th>Rating Key</th><th>Endpoint</th><th>HTTP</th><th>Message</th></tr></thead>
+      <tbody>
+        {{ range .Out.Errors }}
+        <tr>
+          <td>{{ .SectionID }}</td>
+          <td>{{ .RatingKey }}</td>
+          <td><code>{{ .Endpoint }}</code></td>
+          <td>{{ if .HTTPStatus }}{{ .HTTPStatus }}{{ end }}</td>
+          <td>{{ .Message }}</td>
+        </tr>
+        {{ end }}
+      </tbody>
+    </table>
+  </section>
+  {{ end }}
+
   <section class="details">
     <h2>Details (All Duplicate Items)</h2>
     {{ range $s := .Out.Sections }}
@@ -188,56 +396,167 @@ hr{border:none;height:1px;background:var(--border);margin:20px 0}
       {{ if eq (len $s.Items) 0 }}
         <div class="muted">No duplicates in this library after applying policy.</div>
       {{ else }}
-        {{ range $it := $s.Items }}
-        <details>
-          <summary>
-            {{ $it.Title }}{{ if $it.Year }} ({{ $it.Year }}){{ end }}
-            <span class="badge">{{ itemVersionCount $it }} versions</span>
-            {{ $gc := itemGhostCount $it $.Verify }}
-            {{ if $.Verify }}
-              {{ if gt $gc 0 }}<span class="badge bad">{{ $gc }} ghost{{ if gt $gc 1 }}s{{ end }}</span>{{ else }}<span class="badge ok">no ghosts</span>{{ end }}
-            {{ else }}<span class="badge warn">verification off</span>{{ end }}
-          </summary>
-          <table>
-            <thead><tr><th>Version</th><th>Codec</</th><th>Resolution</th><th>Part File</th><th>Size</th><th>Status</th></tr></thead>
-            <tbody>
-              {{ range $v := $it.Versions }}
-                {{ range $p := $v.Parts }}
-                <tr>
-                  <td><code>{{ $v.Container }}</code></td>
-                  <td><span class="muted">{{ $v.VideoCodec }}</span> / <span class="muted">{{ $v.AudioCodec }}</span></td>
-                  <td>{{ $v.VideoResolution }} ({{ $v.Width }}×{{ $v.Height }})</td>
-                  <td><code>{{ $p.File }}</code></td>
-                  <td>{{ bytesHuman $p.Size }}</td>
-                  <td>
-                    {{ if $.Verify }}
-                      {{ if $p.VerifiedOnDisk }}<span class="chip ok">Verified</span>{{ else }}<span class="chip bad">Missing/Unreachable</span>{{ end }}
-                    {{ else }}<span class="chip warn">Not checked</span>{{ end }}
-                  </td>
-                </tr>
-                {{ end }}
-              {{ end }}
-            </tbody>
-          </table>
-        </details>
+        {{ if $s.Shows }}
+          {{ range $sh := showViews $s }}
+          <h4 style="margin:14px 0 6px">{{ $sh.Title }}</h4>
+            {{ range $se := $sh.Seasons }}
+            <div class="muted small" style="margin:8px 0 4px">{{ seasonLabel $se.Season }}</div>
+              {{ range $it := $se.Items }}{{ template "dupItem" (dupItem $it $.Verify) }}{{ end }}
+            {{ end }}
+          {{ end }}
+        {{ else }}
+          {{ range $it := $s.Items }}{{ template "dupItem" (dupItem $it $.Verify) }}{{ end }}
         {{ end }}
       {{ end }}
     {{ end }}
   </section>
 
-  {{ if gt (len (filterIgnoredBy .Out.Ignored "4k+hd_pair")) 0 }}
+  {{ if .Out.SplitDuplicates }}
+  <section class="details" style="margin-top:22px">
+    <h2>Split Duplicates</h2>
+    <div class="muted small" style="margin-bottom:8px">
+      Separate Plex items that look like the same title (shared GUID, external ID or title+year). Plex's duplicate filter does not see these; merge or remove them in Plex.
+    </div>
+    {{ range $g := .Out.SplitDuplicates }}
+    <details>
+      <summary>
+        {{ itemLabel (index $g.Items 0) }}
+        <span class="badge">{{ $g.SectionTitle }}</span>
+        <span class="badge">{{ len $g.Items }} items</span>
+        {{ range $g.MatchedOn }}<span class="badge warn">{{ . }}</span>{{ end }}
+      </summary>
+      <table>
+        <thead><tr><th>Item</th><th>Rating Key</th><th>GUID</th><th>Resolution</th><th>Part File</th><th>Size</th></tr></thead>
+        <tbody>
+          {{ range $it := $g.Items }}
+            {{ range $v := $it.Versions }}
+              {{ range $p := $v.Parts }}
+              <tr>
+                <td>{{ itemLabel $it }}</td>
+                <td>{{ $it.RatingKey }}</td>
+                <td><code>{{ $it.Guid }}</code></td>
+                <td>{{ $v.VideoResolution }} ({{ $v.Width }}×{{ $v.Height }})</td>
+                <td><code>{{ $p.File }}</code></td>
+                <td>{{ bytesHuman $p.Size }}</td>
+              </tr>
+              {{ end }}
+            {{ end }}
+          {{ end }}
+        </tbody>
+      </table>
+    </details>
+    {{ end }}
+  </section>
+  {{ end }}
+
+  {{ if .Out.Orphans }}
+  <section class="details" style="margin-top:22px">
+    <h2>Orphan Files</h2>
+    <div class="muted small" style="margin-bottom:8px">
+      Video files in library folders that no Plex item references: failed matches, leftover downloads or versions Plex dropped. Check them before deleting; files of libraries that were not scanned can show up here too.
+    </div>
+    <table>
+      <thead><tr><th>Library</th><th>File</th><th>Likely Title</th><th>Type</th><th>Modified</th><th>Size</th></tr></thead>
+      <tbody>
+        {{ range .Out.Orphans }}
+        <tr>
+          <td>{{ .SectionTitle }}</td>
+          <td><code>{{ .Path }}</code></td>
+          <td>{{ .LikelyTitle }}{{ if .LikelyYear }} ({{ .LikelyYear }}){{ end }}</td>
+          <td><code>{{ .Extension }}</code></td>
+          <td>{{ with .ModTime }}{{ .Format "2006-01-02" }}{{ end }}</td>
+          <td>{{ bytesHuman .Size }}</td>
+        </tr>
+        {{ end }}
+      </tbody>
+    </table>
+  </section>
+  {{ end }}
+
+  {{ if .Out.Identical }}
+  <section class="details" style="margin-top:22px">
+    <h2>Identical Files</h2>
+    <div class="muted small" style="margin-bottom:8px">
+      Files with the same content stored more than once. "Partial" groups match in size and their first and last MiB; run with -hash-full to confirm them byte for byte.
+    </div>
+    <table>
+      <thead><tr><th>Size</th><th>Copies</th><th>Library</th><th>Title</th><th>File</th></tr></thead>
+      <tbody>
+        {{ range $g := .Out.Identical }}
+          {{ range $i, $f := $g.Files }}
+          <tr>
+            {{ if eq $i 0 }}
+            <td rowspan="{{ len $g.Files }}">{{ bytesHuman $g.Size }}{{ if not $g.Full }} <span class="chip warn" title="{{ $g.Hash }}">Partial</span>{{ end }}</td>
+            <td rowspan="{{ len $g.Files }}">{{ $g.Copies }}</td>
+            {{ end }}
+            <td>{{ $f.SectionTitle }}</td>
+            <td>{{ $f.Title }}</td>
+            <td><code>{{ $f.File }}</code></td>
+          </tr>
+          {{ end }}
+        {{ end }}
+      </tbody>
+    </table>
+  </section>
+  {{ end }}
+
+  {{ if .Out.CrossLibrary }}
+  <section class="details" style="margin-top:22px">
+    <h2>Cross-Library Duplicates</h2>
+    <div class="muted small" style="margin-bottom:8px">
+      Titles found in more than one library (same GUID or external ID). The duplicate policy is applied to all copies together; groups it considers intentional are marked Ignored.
+    </div>
+    {{ range $g := .Out.CrossLibrary }}
+    <details>
+      <summary>
+        {{ itemLabel $g.Item }}
+        {{ range $g.Sections }}<span class="badge">{{ .SectionTitle }}</span>{{ end }}
+        {{ if $g.Ignored }}<span class="badge ok">Ignored: {{ reasonTitle $g.Reason }}</span>{{ if $g.Rule }}<span class="badge">Rule: {{ $g.Rule }}</span>{{ end }}{{ end }}
+        {{ range $g.Item.Flags }}<span class="badge warn" title="{{ .Reason }}">Flag: {{ .Rule }}</span>{{ end }}
+        {{ with $g.Item.Recommendation }}{{ if gt .ReclaimableBytes 0 }}<span class="badge">reclaim {{ bytesHuman .ReclaimableBytes }}</span>{{ end }}{{ end }}
+      </summary>
+      <div class="muted small">Matched on: {{ range $i, $k := $g.MatchedOn }}{{ if $i }}, {{ end }}<code>{{ $k }}</code>{{ end }}</div>
+      <table>
+        <thead><tr><th>Pick</th><th>Library</th><th>Version</th><th>Codec</th><th>Resolution</th><th>Part File</th><th>Size</th></tr></thead>
+        <tbody>
+          {{ range $v := $g.Item.Versions }}
+            {{ range $p := $v.Parts }}
+            <tr>
+              <td>
+                {{ $pick := versionPick $g.Item $v.ID }}
+                {{ if eq $pick "keep" }}<span class="chip ok" title="score {{ $v.Score }}">Keep</span>{{ else if eq $pick "remove" }}<span class="chip warn" title="{{ removalReasons $g.Item $v.ID }}">Remove</span>{{ end }}
+              </td>
+              <td>{{ $v.Library }}</td>
+              <td><code>{{ $v.Container }}</code>{{ with $v.Edition }}<span class="badge">{{ . }}</span>{{ end }}</td>
+              <td><span class="muted">{{ $v.VideoCodec }}</span> / <span class="muted">{{ $v.AudioCodec }}</span>{{ template "streams" $v }}</td>
+              <td>{{ $v.VideoResolution }} ({{ $v.Width }}×{{ $v.Height }})</td>
+              <td><code>{{ $p.File }}</code></td>
+              <td>{{ bytesHuman $p.Size }}</td>
+            </tr>
+            {{ end }}
+          {{ end }}
+        </tbody>
+      </table>
+    </details>
+    {{ end }}
+  </section>
+  {{ end }}
+
+  {{ range $g := policyGroups .Out.Ignored }}
   <section class="details" style="margin-top:22px">
-    <h2>Ignored (4K+HD Pairs)</h2>
+    <h2>Ignored ({{ $g.Title }})</h2>
+    {{ if $g.Description }}
     <div class="muted small" style="margin-bottom:8px">
-     Items with exactly one 4K and one HD (1080/720) version were not counted as duplicates.
+     {{ $g.Description }}
     </div>
-    {{ $pairs := filterIgnoredBy .Out.Ignored "4k+hd_pair" }}
-    {{ range $ig := $pairs }}
+    {{ end }}
+    {{ range $ig := $g.Items }}
     <details>
       <summary>
-       {{ $ig.Item.Title }}{{ if $ig.Item.Year }} ({{ $ig.Item.Year }}){{ end }}
+       {{ itemLabel $ig.Item }}
        <span class="badge">{{ $ig.SectionTitle }}</span>
-       <span class="badge ok">Reason: 4K+HD pair</span>
+       <span class="badge ok">Reason: {{ $g.Title }}</span>
+       {{ if $ig.Rule }}<span class="badge">Rule: {{ $ig.Rule }}</span>{{ end }}
       </summary>
       <table>
         <thead><tr><th>Version</th><th>Codec</th><th>Resolution</th><th>Part File</th><th>Size</th><th>Status</th></tr></thead>
@@ -245,15 +564,13 @@ hr{border:none;height:1px;background:var(--border);margin:20px 0}
           {{ range $v := $ig.Item.Versions }}
             {{ range $p := $v.Parts }}
             <tr>
-              <td><code>{{ $v.Container }}</code></td>
-              <td><span class="muted">{{ $v.VideoCodec }}</span> / <span class="muted">{{ $v.AudioCodec }}</span></td>
+              <td><code>{{ $v.Container }}</code>{{ with $v.Edition }}<span class="badge">{{ . }}</span>{{ end }}</td>
+              <td><span class="muted">{{ $v.VideoCodec }}</span> / <span class="muted">{{ $v.AudioCodec }}</span>{{ template "streams" $v }}</td>
               <td>{{ $v.VideoResolution }} ({{ $v.Width }}×{{ $v.Height }})</td>
               <td><code>{{ $p.File }}</code></td>
               <td>{{ bytesHuman $p.Size }}</td>
               <td>
-                {{ if $.Verify }}
-                  {{ if $p.VerifiedOnDisk }}<span class="chip ok">Verified</span>{{ else }}<span class="chip bad">Missing/Unreachable</span>{{ end }}
-                {{ else }}<span class="chip warn">Not checked</span>{{ end }}
+                {{ template "partStatus" (partStatus $p $.Verify) }}
               </td>
             </tr>
             {{ end }}
@@ -275,7 +592,7 @@ hr{border:none;height:1px;background:var(--border);margin:20px 0}
     {{ range $ig := $extras }}
     <details>
       <summary>
-        {{ $ig.Item.Title }}{{ if $ig.Item.Year }} ({{ $ig.Item.Year }}){{ end }}
+        {{ itemLabel $ig.Item }}
         <span class="badge">{{ $ig.SectionTitle }}</span>
         <span class="badge ok">Reason: Extra</span>
       </summary>
@@ -285,15 +602,13 @@ hr{border:none;height:1px;background:var(--border);margin:20px 0}
           {{ range $v := $ig.Item.Versions }}
             {{ range $p := $v.Parts }}
             <tr>
-              <td><code>{{ $v.Container }}</code></td>
-              <td><span class="muted">{{ $v.VideoCodec }}</span> / <span class="muted">{{ $v.AudioCodec }}</span></td>
+              <td><code>{{ $v.Container }}</code>{{ with $v.Edition }}<span class="badge">{{ . }}</span>{{ end }}</td>
+              <td><span class="muted">{{ $v.VideoCodec }}</span> / <span class="muted">{{ $v.AudioCodec }}</span>{{ template "streams" $v }}</td>
               <td>{{ $v.VideoResolution }} ({{ $v.Width }}×{{ $v.Height }})</td>
               <td><code>{{ $p.File }}</code></td>
               <td>{{ bytesHuman $p.Size }}</td>
               <td>
-                {{ if $.Verify }}
-                  {{ if $p.VerifiedOnDisk }}<span class="chip ok">Verified</span>{{ else }}<span class="chip bad">Missing/Unreachable</span>{{ end }}
-                {{ else }}<span class="chip warn">Not checked</span>{{ end }}
+                {{ template "partStatus" (partStatus $p $.Verify) }}
               </td>
             </tr>
             {{ end }}
@@ -326,3 +641,130 @@ hr{border:none;height:1px;background:var(--border);margin:20px 0}
 	defer f.Close()
 	return t.Execute(f, data)
 }
+
+// partStatusView is the data passed to the "partStatus" sub-template.
+type partStatusView struct {
+	P      PartOut
+	Verify bool
+}
+
+// localErrorLabel describes a -local-verify problem for the report.
+func localErrorLabel(code string) string {
+	switch code {
+	case localNotFound:
+		return "Missing on disk"
+	case localPermission:
+		return "Permission denied"
+	case localNotRegular:
+		return "Not a regular file"
+	}
+	return code
+}
+
+// dupItemView is the data passed to the "dupItem" sub-template.
+type dupItemView struct {
+	It     Item
+	Verify bool
+}
+
+// showView and seasonView hold a show section's duplicate episodes in display order.
+type showView struct {
+	Title   string
+	Seasons []seasonView
+}
+
+type seasonView struct {
+	Season int
+	Items  []Item
+}
+
+// showViews resolves the rating keys in sec.Shows back to the section's items.
+func showViews(sec SectionResult) []showView {
+	byKey := make(map[string]Item, len(sec.Items))
+	for _, it := range sec.Items {
+		byKey[it.RatingKey] = it
+	}
+	views := make([]showView, 0, len(sec.Shows))
+	for _, sh := range sec.Shows {
+		sv := showView{Title: sh.Title}
+		for _, se := range sh.Seasons {
+			sev := seasonView{Season: se.Season}
+			for _, rk := range se.Episodes {
+				if it, ok := byKey[rk]; ok {
+					sev.Items = append(sev.Items, it)
+				}
+			}
+			sv.Seasons = append(sv.Seasons, sev)
+		}
+		views = append(views, sv)
+	}
+	return views
+}
+
+// itemLabel is the display name of an item: "Title (Year)" for movies and
+// "Show S01E02 · Title" for episodes.
+func itemLabel(it Item) string {
+	if it.Type == "episode" {
+		return fmt.Sprintf("%s S%02dE%02d · %s", it.ShowTitle, it.Season, it.Episode, it.Title)
+	}
+	if it.Year != 0 {
+		return fmt.Sprintf("%s (%d)", it.Title, it.Year)
+	}
+	return it.Title
+}
+
+// ignoredGroup is one "Ignored (...)" section of the report. Title is
+// pre-escaped so labels like "4K+HD" aren't rendered as "4K&#43;HD".
+type ignoredGroup struct {
+	Reason      string
+	Title       template.HTML
+	Description string
+	Items       []IgnoredItem
+}
+
+// policyGroups groups policy-ignored items by reason, in order of first
+// appearance. Extras have their own section and are left out.
+func policyGroups(items []IgnoredItem) []ignoredGroup {
+	var groups []ignoredGroup
+	idx := map[string]int{}
+	for _, it := range items {
+		r := strings.ToLower(it.Reason)
+		if r == reasonExtraVersion {
+			continue
+		}
+		i, ok := idx[r]
+		if !ok {
+			ri := reasonInfo(it.Reason)
+			i = len(groups)
+			idx[r] = i
+			groups = append(groups, ignoredGroup{
+				Reason:      it.Reason,
+				Title:       template.HTML(html.EscapeString(ri.Title)), //nolint:gosec
+				Description: ri.Description,
+			})
+		}
+		groups[i].Items = append(groups[i].Items, it)
+	}
+	return groups
+}
+
+// storageBreakdown is one breakdown table of the storage section.
+type storageBreakdown struct {
+	Title   string
+	Buckets []StorageBucket
+}
+
+// storageBreakdowns lists the non-empty breakdowns of s.
+func storageBreakdowns(s StorageStats) []storageBreakdown {
+	var out []storageBreakdown
+	for _, b := range []storageBreakdown{
+		{"Resolution", s.ByResolution},
+		{"Video codec", s.ByCodec},
+		{"Root folder", s.ByRoot},
+	} {
+		if len(b.Buckets) > 0 {
+			out = append(out, b)
+		}
+	}
+	return out
+}
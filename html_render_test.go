@@ -98,3 +98,112 @@ func TestRenderHTML_IgnoredSectionsAppear(t *testing.T) {
 		t.Errorf("did not expect 'Ignored Extras' section when ignoreExtras is false")
 	}
 }
+
+func TestRenderHTML_SplitDuplicates(t *testing.T) {
+	out := Output{
+		Server:  "http://example:32400",
+		Summary: Summary{SplitDuplicateGroups: 1},
+		SplitDuplicates: []SplitGroup{{
+			SectionID:    "1",
+			SectionTitle: "Movies",
+			MatchedOn:    []string{"imdb://tt0113277"},
+			Items: []Item{
+				{RatingKey: "1", Title: "Heat", Year: 1995, Versions: []Version{{Parts: []PartOut{{File: "/movies/Heat.mkv"}}}}},
+				{RatingKey: "2", Title: "Heat", Year: 1995, Versions: []Version{{Parts: []PartOut{{File: "/movies/Heat copy.mkv"}}}}},
+			},
+		}},
+	}
+	fn := filepath.Join(t.TempDir(), "split.html")
+	if err := RenderHTML(out, false, false, fn); err != nil {
+		t.Fatalf("RenderHTML: %v", err)
+	}
+	b, _ := os.ReadFile(fn)
+	s := string(b)
+	for _, want := range []string{"<h2>Split Duplicates</h2>", "imdb://tt0113277", "/movies/Heat copy.mkv"} {
+		if !strings.Contains(s, want) {
+			t.Errorf("report missing %q", want)
+		}
+	}
+}
+
+func TestRenderHTML_CrossLibrary(t *testing.T) {
+	sections, listings := crossLibFixture()
+	policy, _ := ParsePolicies("ignore-4k-1080")
+	out := Output{CrossLibrary: findCrossLibrary(sections, listings, policy, DefaultScoreModel, true)}
+	fn := filepath.Join(t.TempDir(), "cross.html")
+	if err := RenderHTML(out, false, false, fn); err != nil {
+		t.Fatalf("RenderHTML: %v", err)
+	}
+	b, _ := os.ReadFile(fn)
+	s := string(b)
+	for _, want := range []string{"<h2>Cross-Library Duplicates</h2>", "4K Movies", "/4k/Alien.mkv", "Ignored: 4K"} {
+		if !strings.Contains(s, want) {
+			t.Errorf("report missing %q", want)
+		}
+	}
+}
+
+func TestRenderHTML_LocalVerify(t *testing.T) {
+	item := Item{RatingKey: "1", Title: "Heat", Year: 1995, Versions: []Version{
+		{ID: "a", Parts: []PartOut{{File: "/data/Heat.mkv", LocalPath: "/volume1/Heat.mkv", Size: 10, LocalSize: 5, SizeMismatch: true, VerifiedOnDisk: true}}},
+		{ID: "b", Parts: []PartOut{{File: "/data/Heat 2.mkv", LocalPath: "/volume1/Heat 2.mkv", LocalError: localPermission}}},
+	}}
+	out := Output{
+		Summary:  Summary{VerificationPerformed: true, LocalVerification: true, SizeMismatchParts: 1},
+		Sections: []SectionResult{{SectionID: "1", SectionTitle: "Movies", Type: "movie", Items: []Item{item}}},
+	}
+	fn := filepath.Join(t.TempDir(), "local.html")
+	if err := RenderHTML(out, true, false, fn); err != nil {
+		t.Fatalf("RenderHTML: %v", err)
+	}
+	b, _ := os.ReadFile(fn)
+	s := string(b)
+	for _, want := range []string{"Verification: On (local files)", "Size mismatches: 1", "Size mismatch</span>", "/volume1/Heat.mkv", "Permission denied"} {
+		if !strings.Contains(s, want) {
+			t.Errorf("report missing %q", want)
+		}
+	}
+}
+
+func TestRenderHTML_Orphans(t *testing.T) {
+	out := Output{
+		Summary: Summary{OrphanFiles: 1, OrphanBytes: 2048},
+		Orphans: []Orphan{{SectionID: "1", SectionTitle: "Movies", Path: "/volume1/movies/Alien.1979.mkv", Size: 2048, Extension: "mkv", LikelyTitle: "Alien", LikelyYear: 1979}},
+	}
+	fn := filepath.Join(t.TempDir(), "orphans.html")
+	if err := RenderHTML(out, true, false, fn); err != nil {
+		t.Fatalf("RenderHTML: %v", err)
+	}
+	b, _ := os.ReadFile(fn)
+	s := string(b)
+	for _, want := range []string{"<h2>Orphan Files</h2>", "/volume1/movies/Alien.1979.mkv", "Alien (1979)", "<h3>Orphan Files</h3>"} {
+		if !strings.Contains(s, want) {
+			t.Errorf("report missing %q", want)
+		}
+	}
+}
+
+func TestRenderHTML_Identical(t *testing.T) {
+	out := Output{
+		Summary: Summary{IdenticalGroups: 1, IdenticalBytes: 4096},
+		Identical: []IdenticalGroup{{Hash: "partial-sha256:ab", Size: 4096, Copies: 2, RedundantBytes: 4096, Files: []IdenticalFile{
+			{SectionTitle: "Movies", Title: "Heat (1995)", File: "/data/movies/Heat.mkv"},
+			{SectionTitle: "Movies 4K", Title: "Heat (1995)", File: "/data/4k/Heat copy.mkv"},
+		}}},
+		Sections: []SectionResult{{SectionID: "1", SectionTitle: "Movies", Type: "movie", Items: []Item{{RatingKey: "1", Title: "Heat", Versions: []Version{
+			{ID: "1", Parts: []PartOut{{ID: "1", File: "/data/movies/Heat.mkv", IdenticalHash: "partial-sha256:ab"}}},
+			{ID: "2", Parts: []PartOut{{ID: "2", File: "/data/movies/Heat.2.mkv"}}},
+		}}}}},
+	}
+	fn := filepath.Join(t.TempDir(), "identical.html")
+	if err := RenderHTML(out, false, false, fn); err != nil {
+		t.Fatalf("RenderHTML: %v", err)
+	}
+	b, _ := os.ReadFile(fn)
+	s := string(b)
+	for _, want := range []string{"<h2>Identical Files</h2>", "<h3>Identical Files</h3>", "/data/4k/Heat copy.mkv", "Partial</span>", "Identical copy</span>"} {
+		if !strings.Contains(s, want) {
+			t.Errorf("report missing %q", want)
+		}
+	}
+}
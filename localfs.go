@@ -0,0 +1,122 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// With -local-verify, goPlexr runs where the media is (e.g. on the NAS) and
+// stats part files itself instead of asking Plex for checkFiles=1. Plex
+// paths are translated with -path-map, a list of PLEX_PREFIX=LOCAL_PREFIX
+// pairs; paths no prefix matches are used as they are.
+
+// pathMapping maps one Plex path prefix to a local one.
+type pathMapping struct {
+	plex, local string
+}
+
+// PathMap translates Plex file paths to local ones, longest prefix first.
+type PathMap []pathMapping
+
+// ParsePathMap parses "/data/movies=/volume1/movies,/data/tv=/volume1/tv".
+func ParsePathMap(s string) (PathMap, error) {
+	var pm PathMap
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		plex, local, ok := strings.Cut(pair, "=")
+		plex, local = strings.TrimSpace(plex), strings.TrimSpace(local)
+		if !ok || plex == "" || local == "" {
+			return nil, fmt.Errorf("bad -path-map entry %q (want PLEX_PREFIX=LOCAL_PREFIX)", pair)
+		}
+		pm = append(pm, pathMapping{plex: strings.TrimRight(plex, `/\`), local: local})
+	}
+	sort.SliceStable(pm, func(i, j int) bool { return len(pm[i].plex) > len(pm[j].plex) })
+	return pm, nil
+}
+
+// Local returns the local path of a Plex path. Only whole path components
+// match: /data/tv maps /data/tv/x but not /data/tv2/x.
+func (pm PathMap) Local(plexPath string) string {
+	for _, m := range pm {
+		rest, ok := strings.CutPrefix(plexPath, m.plex)
+		if !ok || (rest != "" && rest[0] != '/' && rest[0] != '\\') {
+			continue
+		}
+		if windowsPath(plexPath) {
+			rest = strings.ReplaceAll(rest, `\`, "/")
+		}
+		return filepath.Join(m.local, filepath.FromSlash(rest))
+	}
+	return plexPath
+}
+
+// windowsPath reports whether a Plex path uses backslashes only.
+func windowsPath(p string) bool {
+	return strings.Contains(p, `\`) && !strings.Contains(p, "/")
+}
+
+// Local verification problems, in PartOut.LocalError
+const (
+	localNotFound   = "not_found"
+	localPermission = "permission_denied"
+	localNotRegular = "not_a_file"
+)
+
+// verifyLocal stats a part's file locally and fills in the local fields,
+// Exists, Accessible and VerifiedOnDisk. A size that differs from Plex's
+// record is flagged but still counts as verified.
+func verifyLocal(p *PartOut, pm PathMap) {
+	if p.File == "" {
+		return
+	}
+	p.LocalPath = pm.Local(p.File)
+	p.Exists, p.Accessible, p.VerifiedOnDisk = false, false, false
+
+	fi, err := os.Stat(p.LocalPath)
+	if err != nil {
+		p.LocalError = localErrorCode(err)
+		// a file we may not stat may still exist
+		p.Exists = errors.Is(err, fs.ErrPermission)
+		return
+	}
+	p.Exists = true
+	mt := fi.ModTime().UTC().Truncate(time.Second)
+	p.LocalSize, p.LocalModTime = fi.Size(), &mt
+	if !fi.Mode().IsRegular() {
+		p.LocalError = localNotRegular
+		return
+	}
+	p.SizeMismatch = p.Size > 0 && fi.Size() != p.Size
+
+	f, err := os.Open(p.LocalPath)
+	if err != nil {
+		p.LocalError = localErrorCode(err)
+		return
+	}
+	f.Close()
+	p.Accessible = true
+	p.VerifiedOnDisk = true
+}
+
+func localErrorCode(err error) string {
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return localNotFound
+	case errors.Is(err, fs.ErrPermission):
+		return localPermission
+	}
+	var pe *fs.PathError
+	if errors.As(err, &pe) {
+		return pe.Err.Error()
+	}
+	return err.Error()
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPathMap(t *testing.T) {
+	pm, err := ParsePathMap("/data=/mnt/a, /data/tv/=/mnt/tv, D:\\Media=/mnt/d")
+	if err != nil {
+		t.Fatalf("ParsePathMap: %v", err)
+	}
+	cases := map[string]string{
+		"/data/tv/Show/e1.mkv":     "/mnt/tv/Show/e1.mkv", // longest prefix wins
+		"/data/movies/x.mkv":       "/mnt/a/movies/x.mkv",
+		"/data2/x.mkv":             "/data2/x.mkv", // whole components only
+		`D:\Media\Movies\Heat.mkv`: "/mnt/d/Movies/Heat.mkv",
+	}
+	for in, want := range cases {
+		if got := pm.Local(in); got != filepath.FromSlash(want) {
+			t.Errorf("Local(%q) = %q, want %q", in, got, want)
+		}
+	}
+	for _, bad := range []string{"/data", "=/mnt", "/data="} {
+		if _, err := ParsePathMap(bad); err == nil {
+			t.Errorf("ParsePathMap(%q): expected an error", bad)
+		}
+	}
+}
+
+func TestVerifyLocal(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "a.mkv"), make([]byte, 10), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	pm, _ := ParsePathMap("/plex=" + dir)
+
+	ok := PartOut{File: "/plex/a.mkv", Size: 10}
+	verifyLocal(&ok, pm)
+	if !ok.VerifiedOnDisk || ok.SizeMismatch || ok.LocalSize != 10 || ok.LocalModTime == nil || ok.LocalError != "" {
+		t.Errorf("present file: %+v", ok)
+	}
+	short := PartOut{File: "/plex/a.mkv", Size: 99}
+	verifyLocal(&short, pm)
+	if !short.VerifiedOnDisk || !short.SizeMismatch {
+		t.Errorf("size mismatch: %+v", short)
+	}
+	gone := PartOut{File: "/plex/b.mkv", Size: 10, VerifiedOnDisk: true, Exists: true}
+	verifyLocal(&gone, pm)
+	if gone.VerifiedOnDisk || gone.Exists || gone.LocalError != localNotFound {
+		t.Errorf("missing file: %+v", gone)
+	}
+	folder := PartOut{File: "/plex"}
+	verifyLocal(&folder, pm)
+	if folder.VerifiedOnDisk || folder.LocalError != localNotRegular {
+		t.Errorf("directory: %+v", folder)
+	}
+
+	if os.Geteuid() == 0 {
+		t.Skip("permission checks do not apply to root")
+	}
+	locked := filepath.Join(dir, "locked.mkv")
+	if err := os.WriteFile(locked, []byte("x"), 0o000); err != nil {
+		t.Fatal(err)
+	}
+	denied := PartOut{File: "/plex/locked.mkv"}
+	verifyLocal(&denied, pm)
+	if denied.VerifiedOnDisk || !denied.Exists || denied.Accessible || denied.LocalError != localPermission {
+		t.Errorf("unreadable file: %+v", denied)
+	}
+}
+
+func TestCollectRun_LocalVerify(t *testing.T) {
+	dir := t.TempDir()
+	_ = os.WriteFile(filepath.Join(dir, "dup1.mkv"), make([]byte, 1000), 0o644)
+	_ = os.WriteFile(filepath.Join(dir, "dup2.mkv"), make([]byte, 5), 0o644)
+
+	checkFiles := false
+	mux := http.NewServeMux()
+	mux.HandleFunc("/library/sections", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(sectionsXML))
+	})
+	mux.HandleFunc("/library/sections/1/all", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(duplicatesXML))
+	})
+	mux.HandleFunc("/library/metadata/100", func(w http.ResponseWriter, r *http.Request) {
+		checkFiles = checkFiles || r.URL.Query().Has("checkFiles")
+		_, _ = w.Write([]byte(metadata100XML))
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	o := Options{BaseURL: ts.URL, Token: "fake", Deep: true, Verify: true, LocalVerify: true, PathMap: "/path=" + dir, DupPolicy: "plex", Timeout: 5 * time.Second}
+	if err := validateScan(o); err != nil {
+		t.Fatalf("validateScan: %v", err)
+	}
+	pc, err := NewClient(o)
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	out, err := RunCollection(context.Background(), pc, o)
+	if err != nil {
+		t.Fatalf("RunCollection: %v", err)
+	}
+	if checkFiles {
+		t.Errorf("-local-verify should not ask Plex for checkFiles")
+	}
+	if !out.Summary.LocalVerification || out.Summary.SizeMismatchParts != 1 || out.TotalGhosts != 0 {
+		t.Errorf("summary = %+v, ghosts = %d", out.Summary, out.TotalGhosts)
+	}
+	p := out.Sections[0].Items[0].Versions[1].Parts[0]
+	if p.LocalPath != filepath.Join(dir, "dup2.mkv") || p.LocalSize != 5 || !p.SizeMismatch {
+		t.Errorf("part = %+v", p)
+	}
+
+	o.Verify = false
+	if err := validateScan(o); err == nil {
+		t.Errorf("-local-verify with -verify=false should be rejected")
+	}
+}
@@ -0,0 +1,395 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// goplexr login gets a token without browser dev tools: it runs the plex.tv
+// PIN flow (create a PIN, have the user enter its code at plex.tv/link, poll
+// until it is authorized), lists the account's servers from the resources
+// endpoint and saves the chosen server's URL and token to the settings file.
+
+const defaultPlexTV = "https://plex.tv"
+
+// pinPollInterval is how often a PIN is checked while waiting for the user.
+var pinPollInterval = 2 * time.Second
+
+// plexTV is a minimal plex.tv API v2 client.
+type plexTV struct {
+	base     string
+	http     *http.Client
+	clientID string
+}
+
+func newPlexTV(base string, timeout time.Duration) *plexTV {
+	return &plexTV{
+		base:     strings.TrimRight(base, "/"),
+		http:     &http.Client{Timeout: timeout},
+		clientID: "goPlexr-" + shortHost(),
+	}
+}
+
+// plexPin is a plex.tv PIN; AuthToken is set once the user has entered Code.
+type plexPin struct {
+	ID        int    `json:"id"`
+	Code      string `json:"code"`
+	AuthToken string `json:"authToken"`
+	ExpiresIn int    `json:"expiresIn"` // seconds, at creation
+}
+
+// plexResource is a device of the account (only servers are used).
+type plexResource struct {
+	Name             string           `json:"name"`
+	Product          string           `json:"product"`
+	Provides         string           `json:"provides"` // comma-separated, e.g. "server"
+	ClientIdentifier string           `json:"clientIdentifier"`
+	AccessToken      string           `json:"accessToken"` // token for this server (differs from the account token on shared servers)
+	Owned            bool             `json:"owned"`
+	Connections      []plexConnection `json:"connections"`
+}
+
+type plexConnection struct {
+	Protocol string `json:"protocol"`
+	Address  string `json:"address"`
+	Port     int    `json:"port"`
+	URI      string `json:"uri"`
+	Local    bool   `json:"local"`
+	Relay    bool   `json:"relay"`
+}
+
+// do sends a plex.tv request and decodes the JSON response into v. Failed
+// requests are returned as *RequestError.
+func (p *plexTV) do(ctx context.Context, method, path, token string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, method, p.base+path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("X-Plex-Product", "goPlexr")
+	req.Header.Set("X-Plex-Version", Ver)
+	req.Header.Set("X-Plex-Client-Identifier", p.clientID)
+	if token != "" {
+		req.Header.Set("X-Plex-Token", token)
+	}
+	resp, err := p.http.Do(req)
+	if err != nil {
+		var ue *url.Error
+		if errors.As(err, &ue) {
+			err = ue.Err
+		}
+		return &RequestError{Endpoint: "plex.tv " + path, Err: err}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 8<<10))
+		return &RequestError{
+			Endpoint:   "plex.tv " + path,
+			StatusCode: resp.StatusCode,
+			Err:        fmt.Errorf("http %d: %s", resp.StatusCode, strings.TrimSpace(string(b))),
+		}
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return &RequestError{Endpoint: "plex.tv " + path, StatusCode: resp.StatusCode, Err: fmt.Errorf("decode json: %w", err)}
+	}
+	return nil
+}
+
+// createPin asks plex.tv for a new short (4 character) PIN.
+func (p *plexTV) createPin(ctx context.Context) (plexPin, error) {
+	var pin plexPin
+	err := p.do(ctx, http.MethodPost, "/api/v2/pins?strong=false", "", &pin)
+	if err == nil && (pin.ID == 0 || pin.Code == "") {
+		err = errors.New("plex.tv returned an empty PIN")
+	}
+	return pin, err
+}
+
+// waitForPin polls the PIN until it carries a token, it expires or ctx ends.
+// A failed poll is retried on the next tick unless plex.tv rejected it (4xx
+// other than 429): the code stays valid through a network blip or a 5xx.
+func (p *plexTV) waitForPin(ctx context.Context, pin plexPin) (string, error) {
+	if pin.ExpiresIn > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(pin.ExpiresIn)*time.Second)
+		defer cancel()
+	}
+	t := time.NewTicker(pinPollInterval)
+	defer t.Stop()
+	var lastErr error
+	for {
+		select {
+		case <-ctx.Done():
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				if lastErr != nil {
+					return "", fmt.Errorf("the code expired before it was entered; run login again (last poll: %w)", lastErr)
+				}
+				return "", errors.New("the code expired before it was entered; run login again")
+			}
+			return "", ctx.Err()
+		case <-t.C:
+		}
+		var cur plexPin
+		if err := p.do(ctx, http.MethodGet, "/api/v2/pins/"+strconv.Itoa(pin.ID), "", &cur); err != nil {
+			var re *RequestError
+			if errors.As(err, &re) && re.StatusCode >= 400 && re.StatusCode < 500 && !retryableStatus(re.StatusCode) {
+				return "", err
+			}
+			lastErr = err
+			continue
+		}
+		if cur.AuthToken != "" {
+			registerSecret(cur.AuthToken)
+			return cur.AuthToken, nil
+		}
+	}
+}
+
+// servers lists the Plex Media Servers the account can reach, by name.
+func (p *plexTV) servers(ctx context.Context, token string) ([]plexResource, error) {
+	var all []plexResource
+	if err := p.do(ctx, http.MethodGet, "/api/v2/resources?includeHttps=1&includeRelay=1", token, &all); err != nil {
+		return nil, err
+	}
+	var out []plexResource
+	for _, r := range all {
+		if !strings.Contains(r.Provides, "server") {
+			continue
+		}
+		registerSecret(r.AccessToken)
+		sortConnections(r.Connections)
+		out = append(out, r)
+	}
+	sort.SliceStable(out, func(i, j int) bool { return strings.ToLower(out[i].Name) < strings.ToLower(out[j].Name) })
+	return out, nil
+}
+
+// sortConnections puts the connections a scan should prefer first: direct
+// LAN addresses, then direct remote ones, then relays; HTTPS before HTTP.
+func sortConnections(cs []plexConnection) {
+	rank := func(c plexConnection) int {
+		r := 0
+		if c.Relay {
+			r += 4
+		}
+		if !c.Local {
+			r += 2
+		}
+		if c.Protocol != "https" {
+			r++
+		}
+		return r
+	}
+	sort.SliceStable(cs, func(i, j int) bool { return rank(cs[i]) < rank(cs[j]) })
+}
+
+func (c plexConnection) describe() string {
+	var tags []string
+	if c.Local {
+		tags = append(tags, "local")
+	} else {
+		tags = append(tags, "remote")
+	}
+	if c.Relay {
+		tags = append(tags, "relay")
+	}
+	return c.URI + " (" + strings.Join(tags, ", ") + ")"
+}
+
+// prompter reads numbered choices from the user.
+type prompter struct {
+	in  *bufio.Scanner
+	out io.Writer
+}
+
+// choose prints the options and returns the chosen index; a single option
+// is chosen without asking, and an empty answer picks the first one.
+func (p prompter) choose(what string, options []string) (int, error) {
+	if len(options) == 1 {
+		fmt.Fprintf(p.out, "%s: %s\n", what, options[0])
+		return 0, nil
+	}
+	for i, o := range options {
+		fmt.Fprintf(p.out, "  %d) %s\n", i+1, o)
+	}
+	for {
+		fmt.Fprintf(p.out, "%s [1-%d, default 1]: ", what, len(options))
+		if !p.in.Scan() {
+			if err := p.in.Err(); err != nil {
+				return 0, err
+			}
+			return 0, errors.New("no choice made (end of input)")
+		}
+		answer := strings.TrimSpace(p.in.Text())
+		if answer == "" {
+			return 0, nil
+		}
+		if n, err := strconv.Atoi(answer); err == nil && n >= 1 && n <= len(options) {
+			return n - 1, nil
+		}
+		fmt.Fprintf(p.out, "Please enter a number between 1 and %d.\n", len(options))
+	}
+}
+
+// LoginResult is the server and connection chosen during login.
+type LoginResult struct {
+	Server string
+	URL    string
+	Token  string
+}
+
+// Login runs the PIN flow and the server choice. serverName, if set, picks
+// the server by name instead of asking.
+func Login(ctx context.Context, tv *plexTV, serverName string, in io.Reader, out io.Writer) (LoginResult, error) {
+	pin, err := tv.createPin(ctx)
+	if err != nil {
+		return LoginResult{}, err
+	}
+	fmt.Fprintf(out, "Open %s/link and enter the code: %s\nWaiting for authorization (Ctrl-C to cancel)...\n", tv.base, pin.Code)
+	token, err := tv.waitForPin(ctx, pin)
+	if err != nil {
+		return LoginResult{}, err
+	}
+	fmt.Fprintln(out, "Authorized.")
+
+	servers, err := tv.servers(ctx, token)
+	if err != nil {
+		return LoginResult{}, err
+	}
+	if len(servers) == 0 {
+		return LoginResult{}, errors.New("this account has no Plex Media Servers")
+	}
+
+	p := prompter{in: bufio.NewScanner(in), out: out}
+	var srv plexResource
+	if serverName != "" {
+		found := false
+		for _, s := range servers {
+			if strings.EqualFold(s.Name, serverName) {
+				srv, found = s, true
+				break
+			}
+		}
+		if !found {
+			return LoginResult{}, fmt.Errorf("no server named %q (have: %s)", serverName, strings.Join(resourceNames(servers), ", "))
+		}
+	} else {
+		var names []string
+		for _, s := range servers {
+			label := s.Name
+			if !s.Owned {
+				label += " (shared)"
+			}
+			names = append(names, label)
+		}
+		i, err := p.choose("Server", names)
+		if err != nil {
+			return LoginResult{}, err
+		}
+		srv = servers[i]
+	}
+	if len(srv.Connections) == 0 {
+		return LoginResult{}, fmt.Errorf("server %q has no connections", srv.Name)
+	}
+
+	conn := srv.Connections[0]
+	if serverName == "" {
+		var uris []string
+		for _, c := range srv.Connections {
+			uris = append(uris, c.describe())
+		}
+		i, err := p.choose("Connection", uris)
+		if err != nil {
+			return LoginResult{}, err
+		}
+		conn = srv.Connections[i]
+	}
+
+	res := LoginResult{Server: srv.Name, URL: conn.URI, Token: srv.AccessToken}
+	if res.Token == "" {
+		res.Token = token
+	}
+	return res, nil
+}
+
+func resourceNames(rs []plexResource) []string {
+	var names []string
+	for _, r := range rs {
+		names = append(names, r.Name)
+	}
+	return names
+}
+
+// runLogin implements `goPlexr login`.
+func runLogin(args []string) int {
+	var (
+		configPath, profile, plexTVURL, serverName string
+		timeout                                    time.Duration
+		noSave                                     bool
+	)
+	fs := flag.NewFlagSet("login", flag.ContinueOnError)
+	addConfigFlags(fs, &configPath, &profile)
+	fs.StringVar(&plexTVURL, "plextv-url", defaultPlexTV, "plex.tv base URL")
+	fs.StringVar(&serverName, "server", "", "Server name to save (default: ask)")
+	fs.DurationVar(&timeout, "timeout", 20*time.Second, "HTTP timeout per plex.tv request")
+	fs.BoolVar(&noSave, "no-save", false, "Print the URL and token instead of saving them to the settings file")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: goPlexr login [-profile NAME] [-server NAME] [-no-save]")
+		fmt.Fprintln(os.Stderr, "Sign in with a plex.tv link code and save a server's url and token to the settings file.")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	if fs.NArg() > 0 {
+		fs.Usage()
+		return exitUsage
+	}
+	if profile == "" {
+		profile = os.Getenv("GOPLEXR_PROFILE")
+	}
+	path, _ := settingsPath(configPath)
+	if path == "" && !noSave {
+		fmt.Fprintln(os.Stderr, "ERROR: no settings file location; pass -config or use -no-save.")
+		return exitUsage
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	res, err := Login(ctx, newPlexTV(plexTVURL, timeout), serverName, os.Stdin, os.Stderr)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "FATAL:", err)
+		return exitFatal
+	}
+
+	if noSave {
+		fmt.Printf("url = %s\ntoken = %s\n", res.URL, res.Token)
+		return 0
+	}
+	// the new token replaces any other token source in the same table; in a
+	// profile it also shadows the top-level ones (see loadSettings)
+	if err := saveSettings(path, profile, map[string]string{"url": res.URL, "token": res.Token}, "token-file", "token-cmd"); err != nil {
+		fmt.Fprintln(os.Stderr, "FATAL: save settings:", err)
+		return exitFatal
+	}
+	where := path
+	if profile != "" {
+		where += " [profiles." + profile + "]"
+	}
+	fmt.Fprintf(os.Stderr, "Saved url (%s) and token for %q to %s\n", res.URL, res.Server, where)
+	return 0
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakePlexTV serves the PIN and resources endpoints; the PIN is authorized
+// on the second poll.
+func fakePlexTV(t *testing.T) *httptest.Server {
+	t.Helper()
+	var polls atomic.Int32
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/v2/pins", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Plex-Client-Identifier") == "" {
+			http.Error(w, "missing client identifier", http.StatusBadRequest)
+			return
+		}
+		_, _ = w.Write([]byte(`{"id": 42, "code": "WXYZ", "authToken": null, "expiresIn": 900}`))
+	})
+	mux.HandleFunc("GET /api/v2/pins/42", func(w http.ResponseWriter, r *http.Request) {
+		if polls.Add(1) < 2 {
+			_, _ = w.Write([]byte(`{"id": 42, "code": "WXYZ", "authToken": null}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"id": 42, "code": "WXYZ", "authToken": "account-token"}`))
+	})
+	mux.HandleFunc("GET /api/v2/resources", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Plex-Token") != "account-token" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		_, _ = w.Write([]byte(`[
+  {"name": "Phone", "provides": "client,player", "connections": []},
+  {"name": "Den", "provides": "server", "owned": true, "accessToken": "den-token", "connections": [
+    {"protocol": "https", "uri": "https://relay.example:8443", "local": false, "relay": true},
+    {"protocol": "http", "uri": "http://192.168.1.5:32400", "local": true, "relay": false},
+    {"protocol": "https", "uri": "https://1-2-3-4.abc.plex.direct:32400", "local": false, "relay": false}
+  ]},
+  {"name": "attic", "provides": "server", "owned": false, "accessToken": "attic-token", "connections": [
+    {"protocol": "https", "uri": "https://attic.example:32400", "local": false, "relay": false}
+  ]}
+]`))
+	})
+	return httptest.NewServer(mux)
+}
+
+func TestLogin(t *testing.T) {
+	defer func(d time.Duration) { pinPollInterval = d }(pinPollInterval)
+	pinPollInterval = time.Millisecond
+	ts := fakePlexTV(t)
+	defer ts.Close()
+
+	var out strings.Builder
+	// servers sort as attic, Den; pick Den, then its second connection
+	res, err := Login(context.Background(), newPlexTV(ts.URL, 5*time.Second), "", strings.NewReader("x\n2\n2\n"), &out)
+	if err != nil {
+		t.Fatalf("Login: %v\n%s", err, out.String())
+	}
+	want := LoginResult{Server: "Den", URL: "https://1-2-3-4.abc.plex.direct:32400", Token: "den-token"}
+	if res != want {
+		t.Errorf("result = %+v, want %+v", res, want)
+	}
+	if !strings.Contains(out.String(), "WXYZ") || !strings.Contains(out.String(), "Please enter a number") {
+		t.Errorf("output:\n%s", out.String())
+	}
+
+	res, err = Login(context.Background(), newPlexTV(ts.URL, 5*time.Second), "den", strings.NewReader(""), io.Discard)
+	if err != nil || res.URL != "http://192.168.1.5:32400" {
+		t.Errorf("-server: result = %+v, err = %v (want the local connection)", res, err)
+	}
+	if _, err := Login(context.Background(), newPlexTV(ts.URL, 5*time.Second), "garage", nil, io.Discard); err == nil {
+		t.Errorf("expected an error for an unknown server")
+	}
+}
+
+func TestWaitForPin_TransientErrors(t *testing.T) {
+	defer func(d time.Duration) { pinPollInterval = d }(pinPollInterval)
+	pinPollInterval = time.Millisecond
+	var polls atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch polls.Add(1) {
+		case 1:
+			http.Error(w, "busy", http.StatusServiceUnavailable)
+		case 2:
+			_, _ = w.Write([]byte(`{"id": 42, "code": "WXYZ", "authToken": null}`))
+		default:
+			_, _ = w.Write([]byte(`{"id": 42, "code": "WXYZ", "authToken": "account-token"}`))
+		}
+	}))
+	defer ts.Close()
+
+	tok, err := newPlexTV(ts.URL, 5*time.Second).waitForPin(context.Background(), plexPin{ID: 42, ExpiresIn: 5})
+	if err != nil || tok != "account-token" || polls.Load() != 3 {
+		t.Errorf("token = %q, err = %v after %d polls; want the token after 3", tok, err, polls.Load())
+	}
+
+	// a rejected PIN fails right away
+	polls.Store(0)
+	gone := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		polls.Add(1)
+		http.NotFound(w, r)
+	}))
+	defer gone.Close()
+	if _, err := newPlexTV(gone.URL, 5*time.Second).waitForPin(context.Background(), plexPin{ID: 42, ExpiresIn: 5}); err == nil || polls.Load() != 1 {
+		t.Errorf("404: err = %v after %d polls, want an error after 1", err, polls.Load())
+	}
+}
+
+func TestRunLogin_Saves(t *testing.T) {
+	defer func(d time.Duration) { pinPollInterval = d }(pinPollInterval)
+	pinPollInterval = time.Millisecond
+	unsetenv(t, "GOPLEXR_CONFIG", "GOPLEXR_PROFILE")
+	ts := fakePlexTV(t)
+	defer ts.Close()
+
+	fn := filepath.Join(t.TempDir(), "sub", "config.toml")
+	_ = os.MkdirAll(filepath.Dir(fn), 0o700)
+	_ = os.WriteFile(fn, []byte("token-file = \"/run/secrets/plex\"\n"), 0o600)
+	if rc := runLogin([]string{"-plextv-url", ts.URL, "-config", fn, "-profile", "den", "-server", "Den"}); rc != 0 {
+		t.Fatalf("rc = %d", rc)
+	}
+	fs, o, _, _ := newTestFlags(t)
+	if err := applySettings(fs, fn, "den"); err != nil {
+		t.Fatalf("applySettings: %v", err)
+	}
+	if o.BaseURL != "http://192.168.1.5:32400" || o.Token != "den-token" {
+		t.Errorf("saved url = %s, token = %s", o.BaseURL, o.Token)
+	}
+	// the profile's token shadows the top-level token-file
+	if o.TokenFile != "" || checkTokenSources(*o) != nil {
+		t.Errorf("top-level token-file still applied: %q", o.TokenFile)
+	}
+	if fi, err := os.Stat(fn); err != nil || fi.Mode().Perm() != 0o600 {
+		t.Errorf("settings file mode: %v %v", fi, err)
+	}
+}
+
+func TestSaveSettings(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "config.toml")
+	orig := `# my settings
+url = "http://old:32400" # old box
+token_file = "/run/secrets/plex"
+include-shows = true
+
+# nightly runs
+[profiles.nightly]
+quiet = true
+
+[profiles.other]
+retries = 1
+`
+	if err := os.WriteFile(fn, []byte(orig), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := saveSettings(fn, "", map[string]string{"url": "http://new:32400", "token": `a"b`}, "token-file"); err != nil {
+		t.Fatalf("saveSettings: %v", err)
+	}
+	if err := saveSettings(fn, "nightly", map[string]string{"url": "http://n:32400"}); err != nil {
+		t.Fatalf("saveSettings: %v", err)
+	}
+	if err := saveSettings(fn, "new", map[string]string{"url": "http://x:32400"}); err != nil {
+		t.Fatalf("saveSettings: %v", err)
+	}
+	b, _ := os.ReadFile(fn)
+	want := `# my settings
+url = "http://new:32400"
+include-shows = true
+token = "a\"b"
+
+# nightly runs
+[profiles.nightly]
+quiet = true
+url = "http://n:32400"
+
+[profiles.other]
+retries = 1
+
+[profiles.new]
+url = "http://x:32400"
+`
+	if string(b) != want {
+		t.Errorf("file =\n%s\nwant\n%s", b, want)
+	}
+}
@@ -4,7 +4,17 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"os"
+	"os/signal"
+	"syscall"
+)
+
+// Exit codes
+const (
+	exitFatal   = 1
+	exitUsage   = 2
+	exitPartial = 3 // scan finished but some sections/items failed (summary.degraded)
 )
 
 /*
@@ -14,7 +24,22 @@ go build -ldflags "-X Ver=v0.3.0" ./cmd/goPlexr
 */
 var Ver = "v0.9.1"
 
+// commands are the subcommands selected by the first argument; anything else is a scan.
+var commands = map[string]func(args []string) int{
+	"delete":    runDelete,
+	"multi":     runMulti,
+	"aggregate": runAggregate,
+	"login":     runLogin,
+	"discover":  runDiscover,
+}
+
 func main() {
+	if len(os.Args) > 1 {
+		if cmd, ok := commands[os.Args[1]]; ok {
+			os.Exit(cmd(os.Args[2:]))
+		}
+	}
+
 	o := Parse()
 
 	// Show version and exit
@@ -25,18 +50,19 @@ func main() {
 	}
 
 	// Basic validation
-	ctx := context.Background()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 	pc, err := NewClient(o)
 	if err != nil {
 		fmt.Fprintln(os.Stderr, "FATAL:", err)
-		os.Exit(1)
+		os.Exit(exitFatal)
 	}
 
 	// Collect duplicates
 	out, err := RunCollection(ctx, pc, o)
 	if err != nil {
 		fmt.Fprintln(os.Stderr, "FATAL:", err)
-		os.Exit(1)
+		os.Exit(exitFatal)
 	}
 
 	// JSON to stdout
@@ -50,13 +76,9 @@ func main() {
 
 	// JSON to stdout (unless -quiet)
 	if !o.Quiet {
-		enc := json.NewEncoder(os.Stdout)
-		if o.Pretty {
-			enc.SetIndent("", "  ")
-		}
-		if err := enc.Encode(out); err != nil {
+		if err := writeJSON(os.Stdout, out, o.Pretty); err != nil {
 			fmt.Fprintln(os.Stderr, "FATAL:", err)
-			os.Exit(1)
+			os.Exit(exitFatal)
 		}
 	}
 
@@ -70,6 +92,11 @@ func main() {
 	}
 
 	_ = Output{} // keep import if optimizer gets cute
+
+	if out.Summary.Degraded {
+		fmt.Fprintf(os.Stderr, "WARN: partial scan, %d request(s) failed (see \"errors\" in JSON)\n", len(out.Errors))
+		os.Exit(exitPartial)
+	}
 }
 
 // writeJSONFile writes the given value as JSON to the specified file path.
@@ -79,7 +106,12 @@ func writeJSONFile(path string, v any, pretty bool) error {
 		return err
 	}
 	defer f.Close()
-	enc := json.NewEncoder(f)
+	return writeJSON(f, v, pretty)
+}
+
+// writeJSON encodes v to w, indented if pretty.
+func writeJSON(w io.Writer, v any, pretty bool) error {
+	enc := json.NewEncoder(w)
 	if pretty {
 		enc.SetIndent("", "  ")
 	}
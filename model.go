@@ -1,31 +1,67 @@
 package main
 
+import "time"
+
 // Output (top-level JSON)
 type Output struct {
-	Server        string          `json:"server"`
-	Sections      []SectionResult `json:"sections"`
-	TotalItems    int             `json:"total_duplicate_items"`
-	TotalVersions int             `json:"total_versions"`
-	TotalGhosts   int             `json:"total_ghost_parts"`
-	Summary       Summary         `json:"summary"`
-	Ignored       []IgnoredItem   `json:"ignored,omitempty"`
+	Server          string              `json:"server"`
+	Sections        []SectionResult     `json:"sections"`
+	TotalItems      int                 `json:"total_duplicate_items"`
+	TotalVersions   int                 `json:"total_versions"`
+	TotalGhosts     int                 `json:"total_ghost_parts"`
+	Summary         Summary             `json:"summary"`
+	Ignored         []IgnoredItem       `json:"ignored,omitempty"`
+	Errors          []ScanError         `json:"errors,omitempty"`
+	GhostCleanup    *GhostCleanup       `json:"ghost_cleanup,omitempty"`    // with -fix-ghosts
+	SplitDuplicates []SplitGroup        `json:"split_duplicates,omitempty"` // with -split-dups
+	CrossLibrary    []CrossLibraryGroup `json:"cross_library,omitempty"`    // with -cross-library
+	Catalog         []CatalogItem       `json:"catalog,omitempty"`          // with -catalog
+	Orphans         []Orphan            `json:"orphans,omitempty"`          // with -orphans
+	Identical       []IdenticalGroup    `json:"identical,omitempty"`        // with -hash
+
+	hashRefs []hashRef // the files -hash considered, for cross-server groups
 }
 
 // Result for a single library/section
 type SectionResult struct {
-	SectionID    string `json:"section_id"`
-	SectionTitle string `json:"section_title"`
-	Type         string `json:"type"`
-	Items        []Item `json:"items"` // duplicate items only
+	SectionID    string      `json:"section_id"`
+	SectionTitle string      `json:"section_title"`
+	Type         string      `json:"type"`
+	Items        []Item      `json:"items"`           // duplicate items only
+	Shows        []ShowGroup `json:"shows,omitempty"` // show sections: Items grouped by show/season
+}
+
+// Duplicate episodes of one show, grouped by season
+type ShowGroup struct {
+	RatingKey string        `json:"rating_key,omitempty"`
+	Title     string        `json:"title"`
+	Seasons   []SeasonGroup `json:"seasons"`
+}
+
+// Duplicate episodes of one season (rating keys of the matching SectionResult.Items)
+type SeasonGroup struct {
+	Season   int      `json:"season"`
+	Episodes []string `json:"episodes"`
 }
 
-// Item represents a movie or show with its versions
+// Item represents a movie or episode with its versions
 type Item struct {
-	RatingKey string    `json:"rating_key"`
-	Title     string    `json:"title"`
-	Year      int       `json:"year,omitempty"`
-	Guid      string    `json:"guid,omitempty"`
-	Versions  []Version `json:"versions"`
+	RatingKey      string          `json:"rating_key"`
+	Type           string          `json:"type,omitempty"` // "movie" or "episode"
+	Title          string          `json:"title"`
+	Year           int             `json:"year,omitempty"`
+	Guid           string          `json:"guid,omitempty"`
+	ExternalIDs    []string        `json:"external_ids,omitempty"` // imdb://, tmdb://, tvdb:// (with -split-dups)
+	Versions       []Version       `json:"versions"`
+	Flags          []RuleFlag      `json:"flags,omitempty"`          // "flag" rules from -policy-file that matched
+	Recommendation *Recommendation `json:"recommendation,omitempty"` // keep-best pick (duplicate items only)
+	Editions       []EditionGroup  `json:"editions,omitempty"`       // with -edition-aware, when versions differ in edition
+
+	// Episode only
+	ShowRatingKey string `json:"show_rating_key,omitempty"`
+	ShowTitle     string `json:"show_title,omitempty"`
+	Season        int    `json:"season,omitempty"`
+	Episode       int    `json:"episode,omitempty"`
 }
 
 // A specific version of an item (e.g., a 4K or 1080p file)
@@ -38,7 +74,72 @@ type Version struct {
 	Bitrate         int       `json:"bitrate,omitempty"`
 	Width           int       `json:"width,omitempty"`
 	Height          int       `json:"height,omitempty"`
+	Score           float64   `json:"score,omitempty"`      // keep-best score (higher is better)
+	SectionID       string    `json:"section_id,omitempty"` // cross-library groups only
+	Library         string    `json:"library,omitempty"`    // cross-library groups only: section title
+	Edition         string    `json:"edition,omitempty"`    // e.g. "Extended"; "" for the standard cut
 	Parts           []PartOut `json:"parts,omitempty"`
+
+	// From the deep fetch's streams
+	Video     *VideoStream     `json:"video,omitempty"`
+	Audio     []AudioStream    `json:"audio,omitempty"`
+	Subtitles []SubtitleStream `json:"subtitles,omitempty"`
+}
+
+// The video track of a version
+type VideoStream struct {
+	Codec          string `json:"codec,omitempty"`
+	BitDepth       int    `json:"bit_depth,omitempty"`
+	ColorTransfer  string `json:"color_transfer,omitempty"`
+	ColorPrimaries string `json:"color_primaries,omitempty"`
+	DOVIProfile    int    `json:"dovi_profile,omitempty"`
+	DynamicRange   string `json:"dynamic_range,omitempty"` // sdr, hdr10, hlg or dolby_vision; empty if unknown
+}
+
+// An audio track of a version
+type AudioStream struct {
+	Codec    string `json:"codec,omitempty"`
+	Profile  string `json:"profile,omitempty"` // e.g. "ma" for DTS-HD MA
+	Channels int    `json:"channels,omitempty"`
+	Layout   string `json:"layout,omitempty"`   // e.g. "7.1(side)"
+	Language string `json:"language,omitempty"` // ISO 639-2 code when Plex knows it
+	Title    string `json:"title,omitempty"`
+	Default  bool   `json:"default,omitempty"`
+	Atmos    bool   `json:"atmos,omitempty"`
+}
+
+// A subtitle track of a version
+type SubtitleStream struct {
+	Codec    string `json:"codec,omitempty"`
+	Language string `json:"language,omitempty"`
+	Title    string `json:"title,omitempty"`
+	Forced   bool   `json:"forced,omitempty"`
+	SDH      bool   `json:"sdh,omitempty"`      // for the deaf and hard of hearing
+	External bool   `json:"external,omitempty"` // a sidecar file, not in the container
+}
+
+// The versions of one edition of an item
+type EditionGroup struct {
+	Edition   string   `json:"edition"`          // "" for versions without one
+	Versions  []string `json:"versions"`         // Version.IDs
+	Duplicate bool     `json:"duplicate"`        // two or more versions the policy doesn't ignore
+	Keep      string   `json:"keep,omitempty"`   // keep-best pick of a duplicate edition
+	Reason    string   `json:"reason,omitempty"` // why the policy ignored its versions
+}
+
+// Which version of a duplicate item to keep, and what removing the others frees
+type Recommendation struct {
+	Keep                 string             `json:"keep"` // Version.ID
+	CandidatesForRemoval []RemovalCandidate `json:"candidates_for_removal"`
+	ReclaimableBytes     int64              `json:"reclaimable_bytes"`
+}
+
+// A version recommended for removal
+type RemovalCandidate struct {
+	VersionID string   `json:"version_id"`
+	Score     float64  `json:"score"`
+	Bytes     int64    `json:"bytes"`
+	Reasons   []string `json:"reasons"`
 }
 
 // A specific part of a version (e.g., a file on disk)
@@ -50,16 +151,40 @@ type PartOut struct {
 	VerifiedOnDisk bool   `json:"verified_on_disk"`
 	Exists         bool   `json:"exists"`
 	Accessible     bool   `json:"accessible"`
+	Unverified     bool   `json:"unverified,omitempty"` // the item wasn't deep-fetched: Exists/Accessible are unknown
+
+	// With -local-verify: what a local stat of the (mapped) file found
+	LocalPath    string     `json:"local_path,omitempty"`
+	LocalSize    int64      `json:"local_size,omitempty"`
+	LocalModTime *time.Time `json:"local_mtime,omitempty"`
+	SizeMismatch bool       `json:"size_mismatch,omitempty"` // local size differs from Plex's
+	LocalError   string     `json:"local_error,omitempty"`   // not_found, permission_denied, not_a_file or the OS error
+
+	// With -hash: the IdenticalGroup hash if another file has the same content
+	IdenticalHash string `json:"identical_hash,omitempty"`
 }
 
 // Summary aggregates
 type Summary struct {
 	VerificationPerformed bool             `json:"verification_performed"`
+	LocalVerification     bool             `json:"local_verification,omitempty"` // files were stat'ed locally, not via checkFiles
+	SizeMismatchParts     int              `json:"size_mismatch_parts,omitempty"`
+	OrphanFiles           int              `json:"orphan_files,omitempty"`
+	OrphanBytes           int64            `json:"orphan_bytes,omitempty"`
+	IdenticalGroups       int              `json:"identical_groups,omitempty"`
+	IdenticalBytes        int64            `json:"identical_bytes,omitempty"` // held by the extra copies
 	TotalLibraries        int              `json:"total_libraries"`
 	TotalDuplicateItems   int              `json:"total_duplicate_items"`
 	TotalGhostParts       int              `json:"total_ghost_parts"`
 	DuplicatePolicy       string           `json:"duplicate_policy"`
+	PolicyFile            string           `json:"policy_file,omitempty"`
+	ReclaimableBytes      int64            `json:"reclaimable_bytes"`
+	SplitDuplicateGroups  int              `json:"split_duplicate_groups,omitempty"`
+	CrossLibraryGroups    int              `json:"cross_library_groups,omitempty"` // not ignored by policy
 	VariantItemsExcluded  int              `json:"variant_items_excluded,omitempty"`
+	HTTPRetries           int64            `json:"http_retries"`
+	Degraded              bool             `json:"degraded"` // some requests failed; see Output.Errors
+	Storage               StorageStats     `json:"storage"`
 	Libraries             []LibrarySummary `json:"libraries"`
 }
 
@@ -73,12 +198,151 @@ type LibrarySummary struct {
 	GhostParts       int    `json:"ghost_parts"`
 	ItemsWithGhosts  int    `json:"items_with_ghosts"`
 	VariantsExcluded int    `json:"variants_excluded,omitempty"`
+	ReclaimableBytes int64  `json:"reclaimable_bytes"`
+
+	Storage StorageStats `json:"storage"`
+}
+
+// Bytes held by the parts of duplicate items (as recorded by Plex)
+type StorageStats struct {
+	DuplicateBytes   int64           `json:"duplicate_bytes"`   // every version of the duplicate items
+	ReclaimableBytes int64           `json:"reclaimable_bytes"` // removal candidates under keep-best
+	GhostBytes       int64           `json:"ghost_bytes"`       // verified parts found missing
+	ByResolution     []StorageBucket `json:"by_resolution"`
+	ByCodec          []StorageBucket `json:"by_codec"`
+	ByRoot           []StorageBucket `json:"by_root"` // section folder, else first two path folders
+}
+
+// One row of a storage breakdown
+type StorageBucket struct {
+	Key              string `json:"key"` // e.g. "2160", "hevc", "/mnt/disk1/movies"
+	Parts            int    `json:"parts"`
+	Bytes            int64  `json:"bytes"`
+	ReclaimableBytes int64  `json:"reclaimable_bytes"`
+	GhostBytes       int64  `json:"ghost_bytes"`
 }
 
 // Optional list of items excluded by policy (e.g., 4K+1080 pairs)
 type IgnoredItem struct {
 	SectionID    string `json:"section_id"`
 	SectionTitle string `json:"section_title"`
-	Reason       string `json:"reason"` // e.g. "4k+1080_pair"
+	Reason       string `json:"reason"`         // e.g. "4k+hd_pair"
+	Rule         string `json:"rule,omitempty"` // -policy-file rule that fired, if any
 	Item         Item   `json:"item"`
 }
+
+// A -policy-file "flag" rule that matched a kept item
+type RuleFlag struct {
+	Rule   string `json:"rule"`
+	Reason string `json:"reason"`
+}
+
+// A request that failed during the scan; the section or item it names is
+// missing from the results or was reported from shallow listing data.
+type ScanError struct {
+	SectionID  string `json:"section_id,omitempty"`
+	RatingKey  string `json:"rating_key,omitempty"`
+	Endpoint   string `json:"endpoint,omitempty"` // URL path, no token
+	HTTPStatus int    `json:"http_status,omitempty"`
+	Message    string `json:"message"`
+}
+
+// Result of -fix-ghosts (section refresh + empty trash + re-verify)
+type GhostCleanup struct {
+	GhostsBefore int                   `json:"ghosts_before"`
+	GhostsAfter  int                   `json:"ghosts_after"`
+	Sections     []GhostCleanupSection `json:"sections,omitempty"`
+}
+
+// Ghost cleanup for a single library/section
+type GhostCleanupSection struct {
+	SectionID      string   `json:"section_id"`
+	SectionTitle   string   `json:"section_title"`
+	RefreshedPaths []string `json:"refreshed_paths"`
+	TrashEmptied   bool     `json:"trash_emptied"`
+	ItemsChecked   int      `json:"items_checked"`
+	ItemsRemoved   int      `json:"items_removed,omitempty"` // items gone entirely after emptying trash
+	Skipped        string   `json:"skipped,omitempty"`       // why the library was left alone (looks like an outage)
+	TrashKept      string   `json:"trash_kept,omitempty"`    // why the trash wasn't emptied (a folder may be offline)
+	GhostsBefore   int      `json:"ghosts_before"`
+	GhostsAfter    int      `json:"ghosts_after"`
+}
+
+// Separate items in one library that are the same title (Plex did not merge them)
+type SplitGroup struct {
+	SectionID    string   `json:"section_id"`
+	SectionTitle string   `json:"section_title"`
+	MatchedOn    []string `json:"matched_on"` // keys the items share, e.g. "imdb://tt0111161" or "title:heat (1995)"
+	Items        []Item   `json:"items"`
+}
+
+// Copies of one title in more than one scanned library. Item holds the
+// versions of all copies (Version.Library says where each lives) so the
+// policy and keep-best scoring see them as one duplicate item.
+type CrossLibraryGroup struct {
+	MatchedOn []string              `json:"matched_on"` // GUIDs/external IDs shared across the libraries
+	Sections  []CrossLibrarySection `json:"sections"`
+	Item      Item                  `json:"item"`
+	Ignored   bool                  `json:"ignored,omitempty"` // intentional per policy, e.g. 4K library + HD library
+	Reason    string                `json:"reason,omitempty"`
+	Rule      string                `json:"rule,omitempty"`
+}
+
+// One library's copy in a CrossLibraryGroup
+type CrossLibrarySection struct {
+	SectionID    string `json:"section_id"`
+	SectionTitle string `json:"section_title"`
+	RatingKey    string `json:"rating_key"`
+}
+
+// A video file in a library folder that no part references (-orphans)
+type Orphan struct {
+	SectionID    string     `json:"section_id"`
+	SectionTitle string     `json:"section_title"`
+	Path         string     `json:"path"` // local path
+	Size         int64      `json:"size"`
+	Extension    string     `json:"extension"`
+	ModTime      *time.Time `json:"mtime,omitempty"`
+	LikelyTitle  string     `json:"likely_title,omitempty"` // guessed from the file or folder name
+	LikelyYear   int        `json:"likely_year,omitempty"`
+}
+
+// Files with the same content (-hash): byte-identical when Full, otherwise
+// the same size and first and last MiB
+type IdenticalGroup struct {
+	Hash           string          `json:"hash"` // "sha256:…" or "partial-sha256:…"
+	Full           bool            `json:"full"`
+	Size           int64           `json:"size"`
+	Copies         int             `json:"copies"`          // distinct files
+	RedundantBytes int64           `json:"redundant_bytes"` // size × (copies − 1)
+	Files          []IdenticalFile `json:"files"`           // every part referencing a copy
+}
+
+// A part referencing one file of an IdenticalGroup
+type IdenticalFile struct {
+	Server       string `json:"server,omitempty"` // multi index only
+	SectionID    string `json:"section_id"`
+	SectionTitle string `json:"section_title"`
+	RatingKey    string `json:"rating_key"`
+	Title        string `json:"title"`
+	VersionID    string `json:"version_id"`
+	PartID       string `json:"part_id"`
+	File         string `json:"file"` // Plex path
+	LocalPath    string `json:"local_path"`
+}
+
+// One item of a scanned library, for cross-server aggregation (-catalog)
+type CatalogItem struct {
+	SectionID      string   `json:"section_id"`
+	RatingKey      string   `json:"rating_key"`
+	Type           string   `json:"type,omitempty"`
+	Title          string   `json:"title"`
+	Year           int      `json:"year,omitempty"`
+	ShowTitle      string   `json:"show_title,omitempty"`
+	Season         int      `json:"season,omitempty"`
+	Episode        int      `json:"episode,omitempty"`
+	IDs            []string `json:"ids,omitempty"` // Plex GUID and external IDs
+	BestResolution string   `json:"best_resolution"`
+	Versions       int      `json:"versions"`
+	Bytes          int64    `json:"bytes"`
+}
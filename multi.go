@@ -0,0 +1,548 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"html/template"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// MultiConfig is the `goPlexr multi -config` file: the servers to scan, the
+// scan settings they share and where the reports go.
+type MultiConfig struct {
+	OutDir        string         `json:"out_dir"`
+	Parallel      int            `json:"parallel,omitempty"`       // servers scanned at once (default 4)
+	ServerTimeout Duration       `json:"server_timeout,omitempty"` // limit for one scan attempt of one server (default 10m)
+	ServerRetries int            `json:"server_retries,omitempty"` // extra attempts after a scan fails outright
+	Defaults      ScanSettings   `json:"defaults"`
+	Servers       []ServerConfig `json:"servers"`
+}
+
+// ServerConfig is one server in a MultiConfig. Its scan settings override
+// the config's defaults.
+type ServerConfig struct {
+	Name      string `json:"name"` // report file names: <name>.json / <name>.html
+	URL       string `json:"url"`
+	Token     string `json:"token,omitempty"`
+	TokenFile string `json:"token_file,omitempty"` // like -token-file
+	TokenCmd  string `json:"token_cmd,omitempty"`  // like -token-cmd
+	ScanSettings
+}
+
+// ScanSettings are the per-server overridable scan options. Unset (nil)
+// fields keep the value from the level below: defaults, then the scan flag
+// defaults.
+type ScanSettings struct {
+	Sections     *string   `json:"sections,omitempty"`
+	IncludeShows *bool     `json:"include_shows,omitempty"`
+	IgnoreExtras *bool     `json:"ignore_extras,omitempty"`
+	EditionAware *bool     `json:"edition_aware,omitempty"`
+	Deep         *bool     `json:"deep,omitempty"`
+	Verify       *bool     `json:"verify,omitempty"`
+	LocalVerify  *bool     `json:"local_verify,omitempty"`
+	PathMap      *string   `json:"path_map,omitempty"`
+	Orphans      *bool     `json:"orphans,omitempty"`
+	Hash         *bool     `json:"hash,omitempty"`
+	HashFull     *bool     `json:"hash_full,omitempty"`
+	HashCache    *string   `json:"hash_cache,omitempty"`
+	DupPolicy    *string   `json:"dup_policy,omitempty"`
+	PolicyFile   *string   `json:"policy_file,omitempty"`
+	ScoreWeights *string   `json:"score_weights,omitempty"`
+	SplitDups    *bool     `json:"split_dups,omitempty"`
+	CrossLibrary *bool     `json:"cross_library,omitempty"`
+	Catalog      *bool     `json:"catalog,omitempty"`
+	Insecure     *bool     `json:"insecure,omitempty"`
+	TokenInQuery *bool     `json:"token_in_query,omitempty"`
+	Timeout      *Duration `json:"timeout,omitempty"` // per request
+	Retries      *int      `json:"retries,omitempty"` // per request
+	Concurrency  *int      `json:"concurrency,omitempty"`
+	PageSize     *int      `json:"page_size,omitempty"`
+}
+
+// Duration is a time.Duration written as a string ("45s", "10m") in JSON.
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration must be a string like \"45s\": %w", err)
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// apply copies the set fields onto o.
+func (s ScanSettings) apply(o *Options) {
+	set(&o.SectionsCSV, s.Sections)
+	set(&o.IncludeShows, s.IncludeShows)
+	set(&o.IgnoreExtras, s.IgnoreExtras)
+	set(&o.EditionAware, s.EditionAware)
+	set(&o.Deep, s.Deep)
+	set(&o.Verify, s.Verify)
+	set(&o.LocalVerify, s.LocalVerify)
+	set(&o.PathMap, s.PathMap)
+	set(&o.Orphans, s.Orphans)
+	set(&o.Hash, s.Hash)
+	set(&o.HashFull, s.HashFull)
+	set(&o.HashCache, s.HashCache)
+	set(&o.DupPolicy, s.DupPolicy)
+	set(&o.PolicyFile, s.PolicyFile)
+	set(&o.ScoreWeights, s.ScoreWeights)
+	set(&o.SplitDups, s.SplitDups)
+	set(&o.CrossLibrary, s.CrossLibrary)
+	set(&o.Catalog, s.Catalog)
+	set(&o.InsecureTLS, s.Insecure)
+	set(&o.TokenInQuery, s.TokenInQuery)
+	set(&o.Retries, s.Retries)
+	set(&o.Concurrency, s.Concurrency)
+	set(&o.PageSize, s.PageSize)
+	if s.Timeout != nil {
+		o.Timeout = time.Duration(*s.Timeout)
+	}
+}
+
+func set[T any](dst *T, v *T) {
+	if v != nil {
+		*dst = *v
+	}
+}
+
+// LoadMultiConfig reads and validates a multi-server config.
+func LoadMultiConfig(filename string) (*MultiConfig, error) {
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	var c MultiConfig
+	if err := dec.Decode(&c); err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	if err := c.validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	return &c, nil
+}
+
+func (c *MultiConfig) validate() error {
+	if len(c.Servers) == 0 {
+		return errors.New("no servers defined")
+	}
+	if c.Parallel < 0 || c.ServerRetries < 0 || c.ServerTimeout < 0 {
+		return errors.New("parallel, server_retries and server_timeout must not be negative")
+	}
+	seen := map[string]bool{}
+	for i, s := range c.Servers {
+		if s.Name == "" {
+			return fmt.Errorf("server %d: name is required", i+1)
+		}
+		fn := reportBaseName(s.Name)
+		if seen[fn] {
+			return fmt.Errorf("server %q: duplicate name (report files would collide)", s.Name)
+		}
+		seen[fn] = true
+
+		o := c.options(s)
+		if err := checkTokenSources(o); err != nil {
+			return fmt.Errorf("server %q: %w", s.Name, err)
+		}
+		if err := validateScan(o); err != nil {
+			return fmt.Errorf("server %q: %w", s.Name, err)
+		}
+	}
+	return nil
+}
+
+// options builds the scan Options for one server: scan flag defaults, then
+// the config defaults, then the server's own settings.
+func (c *MultiConfig) options(s ServerConfig) Options {
+	var o Options
+	fs := flag.NewFlagSet("", flag.ContinueOnError)
+	addConnFlags(fs, &o)
+	addScanFlags(fs, &o)
+	c.Defaults.apply(&o)
+	s.ScanSettings.apply(&o)
+	o.BaseURL, o.Token, o.TokenFile, o.TokenCmd = s.URL, s.Token, s.TokenFile, s.TokenCmd
+	o.Quiet = true
+	return o
+}
+
+// reportBaseName turns a server name into a safe file name.
+func reportBaseName(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_', r == '.':
+			return r
+		}
+		return '_'
+	}, strings.TrimSpace(name))
+}
+
+// MultiIndex is index.json: the outcome of every server in a multi run.
+type MultiIndex struct {
+	Generated time.Time        `json:"generated"`
+	Servers   []ServerResult   `json:"servers"`
+	Identical []IdenticalGroup `json:"identical,omitempty"` // -hash copies on more than one server
+}
+
+// Server outcomes
+const (
+	serverOK       = "ok"
+	serverDegraded = "degraded" // scanned, but some requests failed; see the report's errors
+	serverFailed   = "failed"
+	serverSkipped  = "skipped" // missing url or token
+)
+
+// ServerResult is one server's line in the index.
+type ServerResult struct {
+	Name             string   `json:"name"`
+	Server           string   `json:"server,omitempty"`
+	Status           string   `json:"status"`
+	Error            string   `json:"error,omitempty"`
+	Attempts         int      `json:"attempts,omitempty"`
+	Duration         Duration `json:"duration"`
+	JSON             string   `json:"json,omitempty"` // report files, relative to the index
+	HTML             string   `json:"html,omitempty"`
+	DuplicateItems   int      `json:"duplicate_items"`
+	GhostParts       int      `json:"ghost_parts"`
+	DuplicateBytes   int64    `json:"duplicate_bytes"`
+	ReclaimableBytes int64    `json:"reclaimable_bytes"`
+	GhostBytes       int64    `json:"ghost_bytes"`
+	FailedRequests   int      `json:"failed_requests,omitempty"`
+
+	hash     *Options  // the scan's options, with -hash
+	hashRefs []hashRef // the files its -hash considered
+}
+
+// RunMulti scans every configured server (c.Parallel at a time) and writes
+// <out>/<name>.json and .html per server plus index.json and index.html.
+// Results are in config order.
+func RunMulti(ctx context.Context, c *MultiConfig, logw io.Writer) (MultiIndex, error) {
+	if err := os.MkdirAll(c.OutDir, 0o755); err != nil {
+		return MultiIndex{}, err
+	}
+	parallel := c.Parallel
+	if parallel == 0 {
+		parallel = 4
+	}
+	timeout := time.Duration(c.ServerTimeout)
+	if timeout == 0 {
+		timeout = 10 * time.Minute
+	}
+
+	var mu sync.Mutex
+	logf := func(format string, args ...any) {
+		mu.Lock()
+		defer mu.Unlock()
+		fmt.Fprintf(logw, time.Now().Format("2006-01-02 15:04:05")+"  "+format+"\n", args...)
+	}
+
+	idx := MultiIndex{Servers: make([]ServerResult, len(c.Servers))}
+	err := runPool(ctx, parallel, len(c.Servers), func(ctx context.Context, i int) {
+		idx.Servers[i] = scanServer(ctx, c, c.Servers[i], timeout, logf)
+	})
+	if err != nil {
+		return MultiIndex{}, err
+	}
+	idx.Generated = time.Now().UTC().Truncate(time.Second)
+	if idx.Identical, err = crossServerIdentical(ctx, idx.Servers); err != nil {
+		return MultiIndex{}, err
+	}
+
+	if err := writeJSONFile(filepath.Join(c.OutDir, "index.json"), idx, true); err != nil {
+		return idx, err
+	}
+	return idx, renderMultiIndex(idx, filepath.Join(c.OutDir, "index.html"))
+}
+
+// scanServer runs one server's scan, retrying failed attempts, and writes its reports.
+func scanServer(ctx context.Context, c *MultiConfig, s ServerConfig, timeout time.Duration, logf func(string, ...any)) (res ServerResult) {
+	o := c.options(s)
+	res = ServerResult{Name: s.Name, Server: redactURL(o.BaseURL)}
+	if err := resolveToken(ctx, &o); err != nil {
+		res.Status, res.Error = serverFailed, err.Error()
+		logf("ERROR: %q: %v", s.Name, err)
+		return res
+	}
+	if o.BaseURL == "" || o.Token == "" {
+		res.Status = serverSkipped
+		res.Error = "missing url or token"
+		logf("SKIP: %q missing url or token", s.Name)
+		return res
+	}
+
+	start := time.Now()
+	defer func() { res.Duration = Duration(time.Since(start).Round(time.Second)) }()
+
+	pc, err := NewClient(o)
+	if err != nil {
+		res.Status, res.Error = serverFailed, err.Error()
+		logf("ERROR: %q: %v", s.Name, err)
+		return res
+	}
+
+	var out Output
+	for attempt := 0; ; attempt++ {
+		res.Attempts = attempt + 1
+		actx, cancel := context.WithTimeout(ctx, timeout)
+		out, err = RunCollection(actx, pc, o)
+		timedOut := errors.Is(actx.Err(), context.DeadlineExceeded)
+		cancel()
+		if err == nil {
+			break
+		}
+		if timedOut {
+			err = fmt.Errorf("timed out after %s", timeout)
+		}
+		if ctx.Err() != nil || attempt >= c.ServerRetries {
+			res.Status, res.Error = serverFailed, err.Error()
+			logf("ERROR: %q @ %s failed: %v", s.Name, res.Server, err)
+			return res
+		}
+		wait := retryDelay(attempt, 0, o.RetryMaxWait)
+		logf("WARN: %q attempt %d/%d failed (%v); retrying in %s", s.Name, attempt+1, c.ServerRetries+1, err, wait.Round(time.Millisecond))
+		select {
+		case <-ctx.Done():
+			res.Status, res.Error = serverFailed, ctx.Err().Error()
+			return res
+		case <-time.After(wait):
+		}
+	}
+
+	base := reportBaseName(s.Name)
+	res.JSON, res.HTML = base+".json", base+".html"
+	if err := writeJSONFile(filepath.Join(c.OutDir, res.JSON), out, o.Pretty); err != nil {
+		res.Status, res.Error = serverFailed, "write JSON: "+err.Error()
+		return res
+	}
+	if err := RenderHTML(out, o.Verify, o.IgnoreExtras, filepath.Join(c.OutDir, res.HTML)); err != nil {
+		res.Status, res.Error = serverFailed, "write HTML: "+err.Error()
+		return res
+	}
+
+	res.Status = serverOK
+	if out.Summary.Degraded {
+		res.Status = serverDegraded
+	}
+	res.DuplicateItems = out.Summary.TotalDuplicateItems
+	res.GhostParts = out.Summary.TotalGhostParts
+	res.DuplicateBytes = out.Summary.Storage.DuplicateBytes
+	res.ReclaimableBytes = out.Summary.ReclaimableBytes
+	res.GhostBytes = out.Summary.Storage.GhostBytes
+	res.FailedRequests = len(out.Errors)
+	if o.Hash {
+		res.hash, res.hashRefs = &o, out.hashRefs
+	}
+	logf("%s: %q, %d duplicate items, %d ghost parts", res.Status, s.Name, res.DuplicateItems, res.GhostParts)
+	return res
+}
+
+// crossServerIdentical hashes the files of every -hash scan together and
+// returns the groups of identical files referenced by more than one server.
+// Files already hashed by the scans come from the hash cache. The first
+// hashing server's -hash-full, -hash-cache and -concurrency apply.
+func crossServerIdentical(ctx context.Context, servers []ServerResult) ([]IdenticalGroup, error) {
+	var o *Options
+	var refs []hashRef
+	for _, r := range servers {
+		if r.hash == nil {
+			continue
+		}
+		if o == nil {
+			o = r.hash
+		}
+		for _, ref := range r.hashRefs {
+			ref.Server = r.Name
+			refs = append(refs, ref)
+		}
+	}
+	if o == nil {
+		return nil, nil
+	}
+	var errs []ScanError // unreadable files are left out; each server's report lists those its scan hit
+	groups, err := hashIdentical(ctx, *o, refs, &errs)
+	if err != nil {
+		return nil, err
+	}
+	var out []IdenticalGroup
+	for _, g := range groups {
+		seen := map[string]bool{}
+		for _, f := range g.Files {
+			seen[f.Server] = true
+		}
+		if len(seen) > 1 {
+			out = append(out, g)
+		}
+	}
+	return out, nil
+}
+
+// renderMultiIndex writes index.html linking every server's report.
+func renderMultiIndex(idx MultiIndex, filename string) error {
+	funcs := template.FuncMap{
+		"comma":      func(i any) string { return CommaAny(i) },
+		"bytesHuman": BytesHuman,
+		"dur":        func(d Duration) string { return time.Duration(d).String() },
+	}
+	const tpl = `<!doctype html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>PLEX Super Duper Report: All Servers</title>
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<style>
+body{margin:0;font-family:ui-sans-serif,system-ui,-apple-system,Segoe UI,Roboto,Helvetica,Arial;background:#0f172a;color:#e5e7eb}
+.container{max-width:1200px;margin:0 auto;padding:24px}
+h1{font-size:28px;margin:0 0 8px}
+.muted{color:#94a3b8}
+.small{font-size:12px}
+a{color:#38bdf8;text-decoration:none}
+a:hover{text-decoration:underline}
+table{width:100%;border-collapse:collapse;margin-top:16px;background:#111827;border:1px solid #1f2937;border-radius:12px}
+th,td{text-align:left;padding:6px 8px;border-bottom:1px solid #1f2937;vertical-align:top}
+.chip{padding:2px 8px;background:#1f2937;border:1px solid #1f2937;border-radius:999px;font-size:12px}
+.chip.ok{border-color:#10b981;color:#d1fae5}
+.chip.bad{border-color:#ef4444;color:#fee2e2}
+.chip.warn{border-color:#f59e0b;color:#fff7ed}
+</style>
+</head>
+<body>
+<div class="container">
+  <h1>PLEX Super Duper Report: All Servers</h1>
+  <div class="muted small">Generated: {{ .Generated.Format "2006-01-02 15:04:05 MST" }} &nbsp;•&nbsp; {{ len .Servers }} servers</div>
+  <table>
+    <thead><tr><th>Server</th><th>Status</th><th>Duplicates</th><th>Ghost parts</th><th>In duplicates</th><th>Reclaimable</th><th>Time</th><th>Reports</th></tr></thead>
+    <tbody>
+      {{ range .Servers }}
+      <tr>
+        <td>{{ .Name }}</td>
+        <td>
+          {{ if eq .Status "ok" }}<span class="chip ok">OK</span>
+          {{ else if eq .Status "degraded" }}<span class="chip warn" title="{{ .FailedRequests }} failed requests">Partial</span>
+          {{ else if eq .Status "skipped" }}<span class="chip">Skipped</span>
+          {{ else }}<span class="chip bad">Failed</span>{{ end }}
+          {{ with .Error }}<div class="muted small">{{ . }}</div>{{ end }}
+        </td>
+        <td>{{ comma .DuplicateItems }}</td>
+        <td>{{ comma .GhostParts }}{{ if gt .GhostBytes 0 }} <span class="muted small">({{ bytesHuman .GhostBytes }})</span>{{ end }}</td>
+        <td>{{ bytesHuman .DuplicateBytes }}</td>
+        <td>{{ bytesHuman .ReclaimableBytes }}</td>
+        <td>{{ dur .Duration }}{{ if gt .Attempts 1 }} <span class="muted small">({{ .Attempts }} attempts)</span>{{ end }}</td>
+        <td>{{ with .HTML }}<a href="{{ . }}">HTML</a>{{ end }} {{ with .JSON }}<a href="{{ . }}">JSON</a>{{ end }}</td>
+      </tr>
+      {{ end }}
+    </tbody>
+  </table>
+  {{ with .Identical }}
+  <h2 style="font-size:20px;margin:24px 0 0">Identical files on more than one server</h2>
+  <table>
+    <thead><tr><th>Size</th><th>Copies</th><th>Files</th></tr></thead>
+    <tbody>
+      {{ range . }}
+      <tr>
+        <td>{{ bytesHuman .Size }}{{ if not .Full }} <span class="chip warn" title="Same size, first and last MiB; not fully hashed">partial</span>{{ end }}</td>
+        <td>{{ .Copies }}</td>
+        <td>{{ range .Files }}<div><strong>{{ .Server }}</strong> · {{ .SectionTitle }} · {{ .Title }}<div class="muted small">{{ .LocalPath }}</div></div>{{ end }}</td>
+      </tr>
+      {{ end }}
+    </tbody>
+  </table>
+  {{ end }}
+  <div class="muted small" style="margin-top:24px">Report generated by <strong>goPlexr</strong>.</div>
+</div>
+</body>
+</html>`
+
+	t, err := template.New("index").Funcs(funcs).Parse(tpl)
+	if err != nil {
+		return err
+	}
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return t.Execute(f, idx)
+}
+
+// runMulti implements `goPlexr multi`.
+func runMulti(args []string) int {
+	var (
+		configPath string
+		outDir     string
+		parallel   int
+	)
+	fs := flag.NewFlagSet("multi", flag.ContinueOnError)
+	fs.StringVar(&configPath, "config", "", "Multi-server JSON config (servers, shared defaults, out_dir)")
+	fs.StringVar(&outDir, "out-dir", "", "Write reports here instead of the config's out_dir")
+	fs.IntVar(&parallel, "parallel", 0, "Servers to scan at once (overrides the config's parallel)")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: goPlexr multi -config servers.json [-out-dir DIR] [-parallel N]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	if configPath == "" {
+		fmt.Fprintln(os.Stderr, "ERROR: -config is required.")
+		fs.Usage()
+		return exitUsage
+	}
+
+	c, err := LoadMultiConfig(configPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "ERROR:", err)
+		return exitUsage
+	}
+	if outDir != "" {
+		c.OutDir = outDir
+	}
+	if parallel > 0 {
+		c.Parallel = parallel
+	}
+	if c.OutDir == "" {
+		fmt.Fprintln(os.Stderr, "ERROR: no output directory; set out_dir in the config or pass -out-dir.")
+		return exitUsage
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	idx, err := RunMulti(ctx, c, os.Stderr)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "FATAL:", err)
+		return exitFatal
+	}
+
+	bad := 0
+	for _, r := range idx.Servers {
+		if r.Status == serverFailed || r.Status == serverDegraded {
+			bad++
+		}
+	}
+	fmt.Fprintf(os.Stderr, "Scanned %d servers; index written to %s\n", len(idx.Servers), filepath.Join(c.OutDir, "index.html"))
+	if bad > 0 {
+		fmt.Fprintf(os.Stderr, "WARN: %d server(s) failed or were only partially scanned (see index.json)\n", bad)
+		return exitPartial
+	}
+	return 0
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeMultiConfig(t *testing.T, body string) string {
+	t.Helper()
+	fn := filepath.Join(t.TempDir(), "servers.json")
+	if err := os.WriteFile(fn, []byte(body), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return fn
+}
+
+func TestLoadMultiConfig_Errors(t *testing.T) {
+	cases := map[string]string{
+		"no servers":     `{"out_dir": "x", "servers": []}`,
+		"missing name":   `{"servers": [{"url": "http://a"}]}`,
+		"duplicate name": `{"servers": [{"name": "a b"}, {"name": "a/b"}]}`,
+		"unknown field":  `{"servers": [{"name": "a", "verfy": true}]}`,
+		"bad policy":     `{"defaults": {"dup_policy": "nope"}, "servers": [{"name": "a"}]}`,
+		"bad duration":   `{"server_timeout": "soon", "servers": [{"name": "a"}]}`,
+	}
+	for name, body := range cases {
+		if _, err := LoadMultiConfig(writeMultiConfig(t, body)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestMultiConfig_Options(t *testing.T) {
+	c, err := LoadMultiConfig(writeMultiConfig(t, `{
+  "server_timeout": "5m",
+  "defaults": {"include_shows": true, "ignore_extras": true, "timeout": "45s"},
+  "servers": [
+    {"name": "alice", "url": "http://alice:32400", "token": "t1"},
+    {"name": "bob", "url": "http://bob:32400", "token": "t2", "include_shows": false, "sections": "1,2", "dup_policy": "plex"}
+  ]
+}`))
+	if err != nil {
+		t.Fatalf("LoadMultiConfig: %v", err)
+	}
+	if time.Duration(c.ServerTimeout) != 5*time.Minute {
+		t.Errorf("server_timeout = %v", time.Duration(c.ServerTimeout))
+	}
+
+	a := c.options(c.Servers[0])
+	if !a.IncludeShows || !a.IgnoreExtras || a.Timeout != 45*time.Second || a.DupPolicy != "ignore-4k-1080" || !a.Deep || a.PageSize != 500 {
+		t.Errorf("alice options = %+v", a)
+	}
+	if a.BaseURL != "http://alice:32400" || a.Token != "t1" {
+		t.Errorf("alice connection = %s %s", a.BaseURL, a.Token)
+	}
+	b := c.options(c.Servers[1])
+	if b.IncludeShows || !b.IgnoreExtras || b.SectionsCSV != "1,2" || b.DupPolicy != "plex" {
+		t.Errorf("bob overrides not applied: %+v", b)
+	}
+}
+
+func TestRunMulti(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/library/sections", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(sectionsXML))
+	})
+	mux.HandleFunc("/library/sections/1/all", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(duplicatesXML))
+	})
+	mux.HandleFunc("/library/metadata/100", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(metadata100XML))
+	})
+	good := httptest.NewServer(mux)
+	defer good.Close()
+	bad := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer bad.Close()
+
+	out := t.TempDir()
+	c, err := LoadMultiConfig(writeMultiConfig(t, `{
+  "out_dir": "`+out+`",
+  "server_retries": 1,
+  "defaults": {"dup_policy": "plex", "retries": 0},
+  "servers": [
+    {"name": "good one", "url": "`+good.URL+`", "token": "t"},
+    {"name": "bad", "url": "`+bad.URL+`", "token": "t"},
+    {"name": "no-token", "url": "`+good.URL+`"}
+  ]
+}`))
+	if err != nil {
+		t.Fatalf("LoadMultiConfig: %v", err)
+	}
+
+	var log bytes.Buffer
+	idx, err := RunMulti(context.Background(), c, &log)
+	if err != nil {
+		t.Fatalf("RunMulti: %v", err)
+	}
+	if len(idx.Servers) != 3 {
+		t.Fatalf("servers = %d", len(idx.Servers))
+	}
+
+	g, b, s := idx.Servers[0], idx.Servers[1], idx.Servers[2]
+	if g.Status != serverOK || g.DuplicateItems != 1 || g.JSON != "good_one.json" || g.HTML != "good_one.html" {
+		t.Errorf("good = %+v", g)
+	}
+	if b.Status != serverFailed || b.Attempts != 2 || b.JSON != "" {
+		t.Errorf("bad = %+v", b)
+	}
+	if s.Status != serverSkipped {
+		t.Errorf("no-token = %+v", s)
+	}
+	for _, f := range []string{"good_one.json", "good_one.html", "index.json", "index.html"} {
+		if _, err := os.Stat(filepath.Join(out, f)); err != nil {
+			t.Errorf("missing %s: %v", f, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(out, "bad.json")); err == nil {
+		t.Errorf("failed server should not get a report")
+	}
+	idxHTML, _ := os.ReadFile(filepath.Join(out, "index.html"))
+	if !strings.Contains(string(idxHTML), `href="good_one.html"`) {
+		t.Errorf("index.html does not link the report")
+	}
+	if !strings.Contains(log.String(), "retrying") {
+		t.Errorf("expected a retry log line, got:\n%s", log.String())
+	}
+}
@@ -1,35 +1,67 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
 	"time"
 )
 
 type Options struct {
-	BaseURL      string
-	Token        string
-	SectionsCSV  string
-	JSONOut      string
-	HTMLOut      string
-	DupPolicy    string
-	IncludeShows bool
-	Deep         bool
-	Pretty       bool
-	Verify       bool
-	InsecureTLS  bool
-	Verbose      bool
-	Quiet        bool
-	ShowVersion  bool
-	IgnoreExtras bool
-	Timeout      time.Duration
+	BaseURL       string
+	Token         string
+	TokenFile     string
+	TokenCmd      string
+	TokenInQuery  bool
+	SectionsCSV   string
+	JSONOut       string
+	HTMLOut       string
+	DupPolicy     string
+	PolicyFile    string
+	ScoreWeights  string
+	FixGhosts     bool
+	FixGhostsWait time.Duration
+	MaxGhostRatio float64
+	SplitDups     bool
+	CrossLibrary  bool
+	Catalog       bool
+	IncludeShows  bool
+	Deep          bool
+	Pretty        bool
+	Verify        bool
+	LocalVerify   bool
+	PathMap       string
+	Orphans       bool
+	Hash          bool
+	HashFull      bool
+	HashCache     string
+	InsecureTLS   bool
+	Verbose       bool
+	Quiet         bool
+	ShowVersion   bool
+	IgnoreExtras  bool
+	EditionAware  bool
+	Timeout       time.Duration
+	Concurrency   int
+	PageSize      int
+	Retries       int
+	RetryMaxWait  time.Duration
 }
 
 func printUsage() {
 	fmt.Fprintf(os.Stderr, "Usage: goPlexr -url http://HOST:32400 -token TOKEN [options]\n")
+	fmt.Fprintf(os.Stderr, "       goPlexr delete [options]   (delete reviewed duplicate versions; see 'goPlexr delete -h')\n")
+	fmt.Fprintf(os.Stderr, "       goPlexr multi -config FILE (scan many servers; see 'goPlexr multi -h')\n")
+	fmt.Fprintf(os.Stderr, "       goPlexr aggregate ...      (titles shared across servers; see 'goPlexr aggregate -h')\n")
+	fmt.Fprintf(os.Stderr, "       goPlexr discover           (find servers on the local network; see 'goPlexr discover -h')\n")
+	fmt.Fprintf(os.Stderr, "       goPlexr login              (get a token via plex.tv and save it; see 'goPlexr login -h')\n")
 	flag.PrintDefaults()
+	fmt.Fprintf(os.Stderr, "\nEvery flag can also be set with GOPLEXR_<FLAG> (e.g. GOPLEXR_INCLUDE_SHOWS=true) or in the\nsettings file; precedence is flag > environment > profile > settings file > default.\n")
 }
 
 // turn --flag into -flag so stdlib flag parser accepts it.
@@ -41,44 +73,150 @@ func normalizeDoubleDash() {
 	}
 }
 
+// addConnFlags defines the flags every command that talks to Plex shares.
+func addConnFlags(fs *flag.FlagSet, o *Options) {
+	fs.StringVar(&o.BaseURL, "url", "", "Plex base URL (e.g. http://HOST:32400); if unset, the only server found on the local network is used. Env: GOPLEXR_URL or PLEX_URL")
+	fs.StringVar(&o.Token, "token", "", "Plex X-Plex-Token (visible in ps and shell history; prefer -token-file or -token-cmd). Env: GOPLEXR_TOKEN or PLEX_TOKEN")
+	fs.StringVar(&o.TokenFile, "token-file", "", "Read the Plex token from the first line of this file")
+	fs.StringVar(&o.TokenCmd, "token-cmd", "", "Run this shell command and use the first line of its stdout as the Plex token (e.g. 'pass plex/token')")
+	fs.BoolVar(&o.TokenInQuery, "token-in-query", false, "Send the token as an X-Plex-Token query parameter instead of a header (for old servers or proxies that strip headers)")
+	fs.BoolVar(&o.InsecureTLS, "insecure", false, "Skip TLS verification (self-signed HTTPS)")
+	fs.DurationVar(&o.Timeout, "timeout", 20*time.Second, "HTTP timeout per request")
+	fs.IntVar(&o.Retries, "retries", 2, "Retries per request on network errors, HTTP 429 and 5xx (0 disables)")
+	fs.DurationVar(&o.RetryMaxWait, "retry-max-wait", 30*time.Second, "Upper bound on the wait between retries (also caps Retry-After)")
+	fs.BoolVar(&o.Verbose, "verbose", false, "Verbose logs to stderr")
+	fs.BoolVar(&o.Verbose, "V", false, "Verbose logs to stderr (alias)")
+}
+
+// addScanFlags defines the flags that control what a scan collects and how
+// duplicates are judged (shared by the single-server scan and multi).
+func addScanFlags(fs *flag.FlagSet, o *Options) {
+	fs.StringVar(&o.SectionsCSV, "sections", "", "Comma-separated section IDs to scan (skip auto-discovery if set)")
+	fs.BoolVar(&o.IncludeShows, "include-shows", false, "Also scan show libraries (type=show)")
+	fs.BoolVar(&o.Deep, "deep", true, "Deep fetch per item for complete Media/Part details (file paths, etc.)")
+	fs.BoolVar(&o.Pretty, "pretty", true, "Pretty-print JSON output")
+	fs.BoolVar(&o.Verify, "verify", true, "Verify on-disk files (adds checkFiles=1 to deep fetch, slower but accurate)")
+	fs.BoolVar(&o.LocalVerify, "local-verify", false, "Verify part files by stat'ing them locally (with -path-map) instead of checkFiles=1; records local size, mtime, size mismatches and permission errors")
+	fs.BoolVar(&o.Orphans, "orphans", false, "Also walk each scanned library's folders locally (with -path-map) and report video files no Plex item references")
+	fs.BoolVar(&o.Hash, "hash", false, "Also hash part files locally (with -path-map) and report byte-identical copies across items and libraries; only files of equal size are read (first and last MiB)")
+	fs.BoolVar(&o.HashFull, "hash-full", false, "With -hash: confirm partial matches by hashing the whole files")
+	fs.StringVar(&o.HashCache, "hash-cache", "", "With -hash: hash cache file, reused while a file's size and mtime are unchanged (default: goplexr/hashes.json in the user cache dir; '-' for none)")
+	fs.StringVar(&o.PathMap, "path-map", "", "With -local-verify, -orphans or -hash: comma-separated PLEX_PREFIX=LOCAL_PREFIX pairs translating Plex file paths to local ones (e.g. '/data/movies=/volume1/movies')")
+	fs.IntVar(&o.PageSize, "page-size", 500, "Items per request when listing a section (X-Plex-Container-Size); 0 fetches the whole section at once")
+	fs.IntVar(&o.Concurrency, "concurrency", 4, "Max parallel requests to Plex for section listings and deep fetches")
+	fs.StringVar(&o.DupPolicy, "dup-policy", "ignore-4k-1080", "Comma-separated duplicate policies; an item is ignored if any policy ignores it. Available: "+strings.Join(PolicyNames(), ", "))
+	fs.StringVar(&o.PolicyFile, "policy-file", "", "JSON rule file describing intentional version sets (ignore) and items to flag; evaluated before -dup-policy")
+	fs.StringVar(&o.ScoreWeights, "score-weights", "", "Keep-best scoring weights as name=weight pairs (resolution, codec, bitrate, audio, container, hdr, size), e.g. 'resolution=50,size=0'")
+	fs.BoolVar(&o.FixGhosts, "fix-ghosts", false, "For parts verification finds missing: refresh their folders, empty the library trash, then re-verify (modifies the Plex library)")
+	fs.DurationVar(&o.FixGhostsWait, "fix-ghosts-wait", 30*time.Second, "With -fix-ghosts: time to let Plex rescan before emptying trash")
+	fs.Float64Var(&o.MaxGhostRatio, "fix-ghosts-max-ratio", 0.5, "With -fix-ghosts: leave a library alone when more than this fraction of its checked parts are ghosts (an offline mount looks like that)")
+	fs.BoolVar(&o.SplitDups, "split-dups", false, "Also list every item per library and report separate items that are the same title (shared GUID/external ID or title+year)")
+	fs.BoolVar(&o.CrossLibrary, "cross-library", false, "Also list every item per library and report titles present in more than one scanned library (joined by GUID/external IDs); -dup-policy applies to their combined versions")
+	fs.BoolVar(&o.Catalog, "catalog", false, "Also list every item per library in \"catalog\" (IDs, best resolution, size) for 'goPlexr aggregate'")
+	fs.BoolVar(&o.IgnoreExtras, "ignore-extras", false, "Ignore versions in Extras/Featurettes/Trailers/ or -extra... when determining duplicates")
+	fs.BoolVar(&o.EditionAware, "edition-aware", true, "Count duplicates only among versions of the same edition (editionTitle, {edition-...} tag or names like Extended, Unrated, IMAX)")
+}
+
+// addOutputFlags defines where a single-server scan writes its reports.
+func addOutputFlags(fs *flag.FlagSet, o *Options) {
+	fs.StringVar(&o.HTMLOut, "html-out", "", "Write a standalone HTML report to this file (in addition to JSON to stdout)")
+	fs.StringVar(&o.JSONOut, "json-out", "", "Write JSON output to this file (use with -quiet for no stdout)")
+	fs.BoolVar(&o.Quiet, "quiet", false, "Do not write JSON to stdout; use --html-out and/or --json-out")
+	fs.BoolVar(&o.ShowVersion, "version", false, "Print version and exit")
+	fs.BoolVar(&o.ShowVersion, "v", false, "Print version and exit (alias)")
+}
+
 func Parse() Options {
 	var o Options
 	flag.Usage = printUsage
 
 	// Define flags
-	flag.StringVar(&o.BaseURL, "url", os.Getenv("PLEX_URL"), "Plex base URL (e.g. http://HOST:32400). Env: PLEX_URL")
-	flag.StringVar(&o.Token, "token", os.Getenv("PLEX_TOKEN"), "Plex X-Plex-Token. Env: PLEX_TOKEN")
-	flag.StringVar(&o.SectionsCSV, "sections", "", "Comma-separated section IDs to scan (skip auto-discovery if set)")
-	flag.BoolVar(&o.IncludeShows, "include-shows", false, "Also scan show libraries (type=show)")
-	flag.BoolVar(&o.Deep, "deep", true, "Deep fetch per item for complete Media/Part details (file paths, etc.)")
-	flag.BoolVar(&o.Pretty, "pretty", true, "Pretty-print JSON output")
-	flag.BoolVar(&o.Verify, "verify", true, "Verify on-disk files (adds checkFiles=1 to deep fetch, slower but accurate)")
-	flag.BoolVar(&o.InsecureTLS, "insecure", false, "Skip TLS verification (self-signed HTTPS)")
-	flag.DurationVar(&o.Timeout, "timeout", 20*time.Second, "HTTP timeout per request")
-	flag.BoolVar(&o.Verbose, "verbose", false, "Verbose logs to stderr")
-	flag.BoolVar(&o.Verbose, "V", false, "Verbose logs to stderr (alias)")
-	flag.StringVar(&o.HTMLOut, "html-out", "", "Write a standalone HTML report to this file (in addition to JSON to stdout)")
-	flag.StringVar(&o.JSONOut, "json-out", "", "Write JSON output to this file (use with -quiet for no stdout)")
-	flag.BoolVar(&o.Quiet, "quiet", false, "Do not write JSON to stdout; use --html-out and/or --json-out")
-	flag.BoolVar(&o.ShowVersion, "version", false, "Print version and exit")
-	flag.BoolVar(&o.ShowVersion, "v", false, "Print version and exit (alias)")
-	flag.StringVar(&o.DupPolicy, "dup-policy", "ignore-4k-1080", "Duplicate policy: 'ignore-4k-1080' (default) or 'plex' (count any multi-version)")
-	flag.BoolVar(&o.IgnoreExtras, "ignore-extras", false, "Ignore versions in Extras/Featurettes/Trailers/ or -extra... when determining duplicates")
+	var configPath, profile string
+	addConfigFlags(flag.CommandLine, &configPath, &profile)
+	addConnFlags(flag.CommandLine, &o)
+	addScanFlags(flag.CommandLine, &o)
+	addOutputFlags(flag.CommandLine, &o)
 
 	// Support --long flags, then parse
 	normalizeDoubleDash()
 	flag.Parse()
 
+	// Fill in what wasn't given on the command line: env, then settings file
+	if err := applySettings(flag.CommandLine, configPath, profile); err != nil {
+		fmt.Fprintln(os.Stderr, "ERROR:", err)
+		os.Exit(exitUsage)
+	}
+
 	// If version was requested, don't require other flags.
 	if o.ShowVersion {
 		return o
 	}
 
+	if err := validateScan(o); err != nil {
+		fmt.Fprintln(os.Stderr, "ERROR:", err)
+		os.Exit(exitUsage)
+	}
+
+	// -token-cmd and discovery can take a while; let Ctrl-C stop them.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := resolveToken(ctx, &o); err != nil {
+		fmt.Fprintln(os.Stderr, "ERROR:", err)
+		os.Exit(exitUsage)
+	}
+
+	// Without -url, use the only server that answers GDM on the LAN.
+	if o.BaseURL == "" && o.Token != "" {
+		s, err := discoverURL(ctx, gdmWait)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "ERROR:", err)
+			os.Exit(exitUsage)
+		}
+		fmt.Fprintf(os.Stderr, "Using %s at %s (found on the local network)\n", s.Name, s.URL)
+		o.BaseURL = s.URL
+	}
+
 	// Require URL + token otherwise.
-	if o.BaseURL == "" || o.Token == "" {
-		fmt.Fprintln(os.Stderr, "ERROR: -url and -token are required (or set PLEX_URL/PLEX_TOKEN).")
+	if o.Token == "" {
+		fmt.Fprintln(os.Stderr, "ERROR: a token is required (-token, -token-file or -token-cmd, or set it in the settings file or GOPLEXR_*/PLEX_TOKEN).")
+		flag.Usage()
+		os.Exit(exitUsage)
+	}
+	if o.BaseURL == "" {
+		fmt.Fprintln(os.Stderr, "ERROR: -url is required (or set it in the settings file or GOPLEXR_URL/PLEX_URL).")
 		flag.Usage()
-		os.Exit(2)
+		os.Exit(exitUsage)
 	}
 	return o
 }
+
+// validateScan checks the scan options that can be found wrong before any
+// request is made (policies, rule file, score weights, flag combinations).
+func validateScan(o Options) error {
+	if _, err := BuildPolicy(o); err != nil {
+		return err
+	}
+	if _, err := ParseScoreWeights(o.ScoreWeights); err != nil {
+		return err
+	}
+	if o.FixGhosts && (!o.Verify || !o.Deep) {
+		return errors.New("-fix-ghosts needs -verify and -deep to find ghost parts")
+	}
+	if o.FixGhosts && (o.MaxGhostRatio <= 0 || o.MaxGhostRatio > 1) {
+		return errors.New("-fix-ghosts-max-ratio must be greater than 0 and at most 1")
+	}
+	if o.LocalVerify && (!o.Verify || !o.Deep) {
+		return errors.New("-local-verify needs -verify and -deep (it replaces checkFiles=1 with local file checks)")
+	}
+	if o.PathMap != "" && !o.LocalVerify && !o.Orphans && !o.Hash {
+		return errors.New("-path-map is only used with -local-verify, -orphans or -hash")
+	}
+	if (o.HashFull || o.HashCache != "") && !o.Hash {
+		return errors.New("-hash-full and -hash-cache are only used with -hash")
+	}
+	if _, err := ParsePathMap(o.PathMap); err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Orphans are the inverse of ghost parts: video files inside a library's
+// folders that no Part of any listed item references (failed matches,
+// leftover downloads, versions Plex dropped). -orphans walks each scanned
+// section's Location paths locally (translated with -path-map) and diffs
+// them against the full section listings.
+
+// videoExtensions are the files an orphan walk reports; subtitles, artwork
+// and NFOs are never parts, so they are not orphans.
+var videoExtensions = map[string]bool{
+	".3gp": true, ".asf": true, ".avi": true, ".divx": true, ".flv": true, ".iso": true,
+	".m2ts": true, ".m4v": true, ".mkv": true, ".mov": true, ".mp4": true, ".mpeg": true,
+	".mpg": true, ".mts": true, ".ogm": true, ".ts": true, ".vob": true, ".webm": true, ".wmv": true,
+}
+
+// skipDirs are NAS and OS housekeeping folders that never hold library media.
+var skipDirs = map[string]bool{
+	"@eadir": true, "#recycle": true, "#snapshot": true, "$recycle.bin": true,
+	"lost+found": true, "system volume information": true, ".grab": true,
+}
+
+// findOrphans walks the local copies of every section's locations and
+// returns the video files no listed part references. Sections whose listing
+// failed (nil) are skipped, since every file would look orphaned. Locations
+// that can't be walked are appended to errs.
+func findOrphans(ctx context.Context, sections []Directory, listings [][]Video, pm PathMap, errs *[]ScanError) []Orphan {
+	known := map[string]bool{}
+	for _, vids := range listings {
+		for _, v := range vids {
+			for _, m := range v.Media {
+				for _, p := range m.Part {
+					if p.File != "" {
+						known[filepath.Clean(pm.Local(p.File))] = true
+					}
+				}
+			}
+		}
+	}
+
+	var out []Orphan
+	walked := map[string]bool{}
+	for i, sec := range sections {
+		if listings[i] == nil {
+			continue
+		}
+		for _, loc := range sec.Location {
+			root := filepath.Clean(pm.Local(loc.Path))
+			if walked[root] {
+				continue // another scanned section shares the folder
+			}
+			walked[root] = true
+			err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				if err != nil {
+					if path == root {
+						return err
+					}
+					*errs = append(*errs, ScanError{SectionID: sec.Key, Message: "orphans: " + err.Error()})
+					return nil
+				}
+				name := d.Name()
+				if d.IsDir() {
+					if path != root && (strings.HasPrefix(name, ".") || skipDirs[strings.ToLower(name)]) {
+						return filepath.SkipDir
+					}
+					return nil
+				}
+				ext := strings.ToLower(filepath.Ext(name))
+				if !d.Type().IsRegular() || strings.HasPrefix(name, ".") || !videoExtensions[ext] || known[path] {
+					return nil
+				}
+				// local trailers and extras are Plex extras, not parts
+				if rel, err := filepath.Rel(root, path); err == nil && isExtraPath(filepath.ToSlash(rel)) {
+					return nil
+				}
+				fi, err := d.Info()
+				if err != nil {
+					return nil // removed while walking
+				}
+				title, year := likelyTitle(path)
+				mt := fi.ModTime().UTC().Truncate(time.Second)
+				out = append(out, Orphan{
+					SectionID:    sec.Key,
+					SectionTitle: sec.Title,
+					Path:         path,
+					Size:         fi.Size(),
+					Extension:    strings.TrimPrefix(ext, "."),
+					ModTime:      &mt,
+					LikelyTitle:  title,
+					LikelyYear:   year,
+				})
+				return nil
+			})
+			if err != nil {
+				if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+					return out
+				}
+				*errs = append(*errs, ScanError{SectionID: sec.Key, Message: fmt.Sprintf("orphans: cannot walk %s (Plex path %s): %s", root, loc.Path, localErrorCode(err))})
+			}
+		}
+	}
+	sort.SliceStable(out, func(a, b int) bool { return out[a].Path < out[b].Path })
+	return out
+}
+
+var (
+	// a release year in a file or folder name, e.g. "Heat (1995)" or "Heat.1995.1080p"
+	// (the separator after it is checked separately, so "2049.2017" has two)
+	yearRe = regexp.MustCompile(`[\s.(\[_-]((?:19|20)\d{2})`)
+	// where scene-style names stop being the title
+	releaseTagRe = regexp.MustCompile(`(?i)[\s._-](s\d{1,2}e\d{1,3}|\d{3,4}p|bluray|blu-ray|bdrip|brrip|web-?dl|webrip|hdtv|dvdrip|remux|x26[45]|h\.?26[45]|hevc|xvid|proper|repack|uhd|hdr)(?:[\s._-]|$)`)
+)
+
+// likelyTitle guesses the title and year of a media file from its name,
+// falling back to its folder when the name is unhelpful (e.g. "movie.mkv").
+func likelyTitle(path string) (string, int) {
+	base := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	if title, year := titleFromName(base); title != "" && (year > 0 || !genericName(title)) {
+		return title, year
+	}
+	return titleFromName(filepath.Base(filepath.Dir(path)))
+}
+
+// titleFromName extracts "Heat", 1995 from names like "Heat (1995)" or
+// "Heat.1995.1080p.BluRay.x264".
+func titleFromName(name string) (string, int) {
+	cut, year := len(name), 0
+	// the last year wins: "Blade Runner 2049 (2017)"
+	padded := " " + name
+	for _, m := range yearRe.FindAllStringSubmatchIndex(padded, -1) {
+		if m[3] < len(padded) && !strings.ContainsRune(" .)]_-", rune(padded[m[3]])) {
+			continue // part of a longer number or word
+		}
+		year, _ = strconv.Atoi(padded[m[2]:m[3]])
+		cut = max(m[0]-1, 0)
+	}
+	if m := releaseTagRe.FindStringIndex(name); m != nil && m[0] < cut {
+		cut = m[0]
+	}
+	title := strings.NewReplacer(".", " ", "_", " ").Replace(name[:cut])
+	title = strings.Join(strings.Fields(strings.Trim(title, " -([")), " ")
+	return title, year
+}
+
+// genericName reports whether a file name says nothing about its title.
+func genericName(s string) bool {
+	switch strings.ToLower(s) {
+	case "movie", "video", "film", "sample", "main", "feature":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLikelyTitle(t *testing.T) {
+	cases := []struct {
+		path  string
+		title string
+		year  int
+	}{
+		{"/m/Heat (1995)/Heat (1995).mkv", "Heat", 1995},
+		{"/dl/Heat.1995.1080p.BluRay.x264-GRP.mkv", "Heat", 1995},
+		{"/dl/Blade.Runner.2049.2017.2160p.mkv", "Blade Runner 2049", 2017},
+		{"/tv/The.Office.S02E03.720p.HDTV.mkv", "The Office", 0},
+		{"/m/Alien (1979)/movie.mkv", "Alien", 1979},
+		{"/m/Some_Home_Video.mp4", "Some Home Video", 0},
+	}
+	for _, c := range cases {
+		if title, year := likelyTitle(c.path); title != c.title || year != c.year {
+			t.Errorf("likelyTitle(%q) = %q, %d; want %q, %d", c.path, title, year, c.title, c.year)
+		}
+	}
+}
+
+func TestFindOrphans(t *testing.T) {
+	root := t.TempDir()
+	files := map[string]int{
+		"movies/Heat (1995)/Heat (1995).mkv":         10,
+		"movies/Heat (1995)/Heat (1995).en.srt":      1,
+		"movies/Heat (1995)/Trailers/Heat.mkv":       2,
+		"movies/Heat (1995)/Heat (1995)-trailer.mp4": 2,
+		"movies/Alien.1979.1080p.BluRay.x264.mkv":    30,
+		"movies/@eaDir/Alien.1979.mkv/SYNOVIDEO.mp4": 3,
+		"movies/.hidden.mkv":                         4,
+		"tv/Show/S01E01.mkv":                         5,
+	}
+	for name, size := range files {
+		fn := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(fn), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(fn, make([]byte, size), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	pm, _ := ParsePathMap("/data=" + root)
+	sections := []Directory{
+		{Key: "1", Title: "Movies", Location: []Location{{Path: "/data/movies"}, {Path: "/data/gone"}}},
+		{Key: "2", Title: "TV", Location: []Location{{Path: "/data/tv"}}},
+	}
+	heat := Video{Media: []Media{{Part: []Part{{File: "/data/movies/Heat (1995)/Heat (1995).mkv"}}}}}
+	listings := [][]Video{{heat}, nil} // TV listing failed
+
+	var errs []ScanError
+	orphans := findOrphans(context.Background(), sections, listings, pm, &errs)
+	if len(orphans) != 1 {
+		t.Fatalf("orphans = %+v, want only the Alien file", orphans)
+	}
+	o := orphans[0]
+	if o.SectionID != "1" || o.Size != 30 || o.Extension != "mkv" || o.LikelyTitle != "Alien" || o.LikelyYear != 1979 || o.ModTime == nil {
+		t.Errorf("orphan = %+v", o)
+	}
+	if o.Path != filepath.Join(root, "movies", "Alien.1979.1080p.BluRay.x264.mkv") {
+		t.Errorf("path = %s", o.Path)
+	}
+	if len(errs) != 1 || !strings.Contains(errs[0].Message, "/data/gone") || !strings.Contains(errs[0].Message, localNotFound) {
+		t.Errorf("errs = %+v, want one for the missing location", errs)
+	}
+}
+
+func TestDirectoryLocations(t *testing.T) {
+	var mc mediaContainer
+	src := `<MediaContainer><Directory key="1" type="movie" title="Movies"><Location id="3" path="/data/movies"/><Location id="4" path="/data/more"/></Directory></MediaContainer>`
+	if err := xml.Unmarshal([]byte(src), &mc); err != nil {
+		t.Fatal(err)
+	}
+	if got := mc.Directory[0].Location; len(got) != 2 || got[1].Path != "/data/more" {
+		t.Errorf("locations = %+v", got)
+	}
+}
+
+func TestFindOrphans_EmptySection(t *testing.T) {
+	root := t.TempDir()
+	fn := filepath.Join(root, "movies", "Heat (1995)", "Heat (1995).mkv")
+	if err := os.MkdirAll(filepath.Dir(fn), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(fn, make([]byte, 10), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/library/sections/1/all", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`<MediaContainer size="0" totalSize="0"></MediaContainer>`))
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+	o := Options{BaseURL: ts.URL, Token: "fake", Timeout: 5 * time.Second}
+	pc, err := NewClient(o)
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+
+	// a section that listed fine with no items is walked: all its files are orphans
+	sections := []Directory{{Key: "1", Type: "movie", Title: "Movies", Location: []Location{{Path: "/data/movies"}}}}
+	var errs []ScanError
+	listings, err := fetchAllListings(context.Background(), pc, o, sections, &errs)
+	if err != nil || len(errs) != 0 || listings[0] == nil {
+		t.Fatalf("listings = %v, err = %v, errs = %+v", listings, err, errs)
+	}
+	pm, _ := ParsePathMap("/data=" + root)
+	if orphans := findOrphans(context.Background(), sections, listings, pm, &errs); len(orphans) != 1 || orphans[0].Path != fn {
+		t.Errorf("orphans = %+v, want the Heat file", orphans)
+	}
+}
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Policy decides whether a multi-version item is an intentional set of
+// versions (ignored, not counted as a duplicate) or a real duplicate.
+type Policy interface {
+	Decide(it Item) Decision
+}
+
+// PolicyFunc adapts a plain function to the Policy interface.
+type PolicyFunc func(it Item) Decision
+
+func (f PolicyFunc) Decide(it Item) Decision { return f(it) }
+
+// Decision is a policy's verdict on one item. Reason becomes IgnoredItem.Reason.
+// Rule-file policies also name the rule that fired and may flag a kept item.
+type Decision struct {
+	Ignore bool
+	Reason string
+	Rule   string
+	Flags  []RuleFlag
+}
+
+// Keep is the verdict for a real duplicate.
+var Keep = Decision{}
+
+// Ignore returns the verdict for an intentional set of versions.
+func Ignore(reason string) Decision { return Decision{Ignore: true, Reason: reason} }
+
+type registeredPolicy struct {
+	label  string // shown in the HTML report
+	policy Policy
+}
+
+// ReasonInfo describes an IgnoredItem.Reason for the HTML report.
+type ReasonInfo struct {
+	Title       string // section heading, e.g. "4K+HD Pairs"
+	Description string // one-line explanation under the heading
+}
+
+var (
+	policyRegistry = map[string]registeredPolicy{}
+	reasonRegistry = map[string]ReasonInfo{}
+)
+
+// RegisterPolicy makes a policy selectable with -dup-policy under name.
+// It panics on duplicate names, like flag definitions do.
+func RegisterPolicy(name, label string, p Policy) {
+	name = strings.ToLower(name)
+	if _, dup := policyRegistry[name]; dup {
+		panic("policy registered twice: " + name)
+	}
+	policyRegistry[name] = registeredPolicy{label: label, policy: p}
+}
+
+// RegisterReason describes an ignore reason so the HTML report can group and explain it.
+func RegisterReason(reason string, info ReasonInfo) {
+	reasonRegistry[strings.ToLower(reason)] = info
+}
+
+// reasonInfo looks up a reason, falling back to the raw reason string.
+func reasonInfo(reason string) ReasonInfo {
+	if ri, ok := reasonRegistry[strings.ToLower(reason)]; ok {
+		return ri
+	}
+	return ReasonInfo{Title: reason}
+}
+
+// PolicyNames lists the registered policies, sorted.
+func PolicyNames() []string {
+	names := make([]string, 0, len(policyRegistry))
+	for n := range policyRegistry {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// namedPolicy is one member of a PolicySet.
+type namedPolicy struct {
+	name   string
+	label  string
+	policy Policy
+}
+
+// PolicySet composes several policies: an item is ignored as soon as one of
+// them ignores it, and the first such policy supplies the reason.
+type PolicySet []namedPolicy
+
+// ParsePolicies resolves a comma-separated -dup-policy value. An empty value
+// means "plex" (count every multi-version item).
+func ParsePolicies(csv string) (PolicySet, error) {
+	var ps PolicySet
+	for _, name := range strings.Split(csv, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		rp, ok := policyRegistry[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown duplicate policy %q (available: %s)", name, strings.Join(PolicyNames(), ", "))
+		}
+		ps = append(ps, namedPolicy{name: name, label: rp.label, policy: rp.policy})
+	}
+	return ps, nil
+}
+
+// Add appends a policy that isn't in the registry (e.g. one loaded from a file).
+func (ps PolicySet) Add(name, label string, p Policy) PolicySet {
+	return append(ps, namedPolicy{name: name, label: label, policy: p})
+}
+
+func (ps PolicySet) Decide(it Item) Decision {
+	var flags []RuleFlag
+	for _, p := range ps {
+		d := p.policy.Decide(it)
+		flags = append(flags, d.Flags...)
+		if d.Ignore {
+			d.Flags = flags
+			return d
+		}
+	}
+	return Decision{Flags: flags}
+}
+
+// BuildPolicy assembles the policy set for a run: rules from -policy-file
+// (if any) come first, then the -dup-policy list.
+func BuildPolicy(o Options) (PolicySet, error) {
+	ps, err := ParsePolicies(o.DupPolicy)
+	if err != nil {
+		return nil, err
+	}
+	if o.PolicyFile == "" {
+		return ps, nil
+	}
+	rf, err := LoadRuleFile(o.PolicyFile)
+	if err != nil {
+		return nil, err
+	}
+	label := fmt.Sprintf("Rules from %s (%d)", filepath.Base(o.PolicyFile), len(rf.Rules))
+	return append(PolicySet{{name: "policy-file", label: label, policy: rf}}, ps...), nil
+}
+
+// Label is the human-readable description of the set, for the HTML report.
+func (ps PolicySet) Label() string {
+	if len(ps) == 0 {
+		return policyRegistry["plex"].label
+	}
+	labels := make([]string, 0, len(ps))
+	for _, p := range ps {
+		labels = append(labels, p.label)
+	}
+	return strings.Join(labels, " + ")
+}
+
+// Reasons emitted by the built-in policies and the collector
+const (
+	reasonExtraVersion = "extra_version"
+	reason4kHdPair     = "4k+hd_pair"
+	reason3d2dPair     = "3d+2d_pair"
+	reasonHDRSDRPair   = "hdr+sdr_pair"
+)
+
+func init() {
+	RegisterPolicy("plex", "Plex (all multi-version)", PolicyFunc(func(Item) Decision { return Keep }))
+
+	RegisterPolicy("ignore-4k-1080", "Ignore 4K+HD pair (1080/720)", PolicyFunc(func(it Item) Decision {
+		if is4kHdPair(it) {
+			return Ignore(reason4kHdPair)
+		}
+		return Keep
+	}))
+	RegisterReason(reason4kHdPair, ReasonInfo{
+		Title:       "4K+HD Pairs",
+		Description: "Items with exactly one 4K and one HD (1080/720) version were not counted as duplicates.",
+	})
+	// reports written before the HD-ish fallback used this reason
+	RegisterReason("4k+1080_pair", ReasonInfo{
+		Title:       "4K+1080 Pairs",
+		Description: "Items with exactly one 4K and one 1080 version were not counted as duplicates.",
+	})
+
+	RegisterPolicy("ignore-3d-2d", "Ignore 3D+2D pair", PolicyFunc(func(it Item) Decision {
+		if is3d2dPair(it) {
+			return Ignore(reason3d2dPair)
+		}
+		return Keep
+	}))
+	RegisterReason(reason3d2dPair, ReasonInfo{
+		Title:       "3D+2D Pairs",
+		Description: "Items with exactly one 3D and one 2D version were not counted as duplicates.",
+	})
+
+	RegisterPolicy("ignore-hdr-sdr", "Ignore HDR+SDR pair", PolicyFunc(func(it Item) Decision {
+		if isHDRSDRPair(it) {
+			return Ignore(reasonHDRSDRPair)
+		}
+		return Keep
+	}))
+	RegisterReason(reasonHDRSDRPair, ReasonInfo{
+		Title:       "HDR+SDR Pairs",
+		Description: "Items with exactly one HDR (HDR10, HLG or Dolby Vision) and one SDR version were not counted as duplicates.",
+	})
+
+	RegisterReason(reasonExtraVersion, ReasonInfo{
+		Title:       "Extras",
+		Description: "Versions whose filename/folder matched Plex’s Extras conventions.",
+	})
+}
+
+// is4kHdPair is true when there are exactly two versions and they are:
+// one 4K (2160) + one HD-ish (1080 or 720, including mislabels).
+func is4kHdPair(it Item) bool {
+	if len(it.Versions) != 2 {
+		return false
+	}
+	v1, v2 := it.Versions[0], it.Versions[1]
+	is4k1 := normalizeResKey(v1) == "2160"
+	is4k2 := normalizeResKey(v2) == "2160"
+
+	// One must be 4K, the other must be HD-ish
+	if is4k1 && isHDVersion(v2) {
+		return true
+	}
+	if is4k2 && isHDVersion(v1) {
+		return true
+	}
+	return false
+}
+
+// is3d2dPair is true when there are exactly two versions and only one is 3D.
+func is3d2dPair(it Item) bool {
+	if len(it.Versions) != 2 {
+		return false
+	}
+	return is3DVersion(it.Versions[0]) != is3DVersion(it.Versions[1])
+}
+
+// isHDRSDRPair is true when there are exactly two versions and only one is HDR.
+func isHDRSDRPair(it Item) bool {
+	if len(it.Versions) != 2 {
+		return false
+	}
+	return versionIsHDR(it.Versions[0]) != versionIsHDR(it.Versions[1])
+}
+
+// Filename tokens used for stereoscopic releases (case-insensitive, whole tokens)
+var stereoTokens = map[string]struct{}{
+	"3d": {}, "sbs": {}, "hsbs": {}, "fsbs": {}, "hou": {}, "htab": {}, "mvc": {},
+}
+
+// is3DVersion looks for 3D tokens in the version's part filenames.
+func is3DVersion(v Version) bool {
+	for _, tok := range partTokens(v) {
+		if _, ok := stereoTokens[tok]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Filename tokens used for HDR releases (case-insensitive, whole tokens)
+var hdrTokens = map[string]struct{}{
+	"hdr": {}, "hdr10": {}, "hdr10+": {}, "hdr10plus": {}, "hlg": {}, "dv": {}, "dovi": {},
+}
+
+// versionIsHDR reports whether the version is an HDR/Dolby Vision encode:
+// from its video stream when known, else from filename tokens.
+func versionIsHDR(v Version) bool {
+	if r := versionDynamicRange(v); r != "" {
+		return r != rangeSDR
+	}
+	for _, tok := range partTokens(v) {
+		if _, ok := hdrTokens[tok]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// editionTag returns the Plex edition tag ({edition-...}) from the
+// version's part filenames or their folder, or "" if there is none.
+func editionTag(v Version) string {
+	for _, p := range v.Parts {
+		s := p.File
+		i := strings.Index(strings.ToLower(s), "{edition-")
+		if i == -1 {
+			continue
+		}
+		rest := s[i+len("{edition-"):]
+		if j := strings.IndexByte(rest, '}'); j > 0 {
+			return strings.TrimSpace(rest[:j])
+		}
+	}
+	return ""
+}
+
+// partTokens splits the version's part basenames (without extension) into
+// lower-case tokens on common release-name separators.
+func partTokens(v Version) []string {
+	var toks []string
+	for _, p := range v.Parts {
+		s := strings.ReplaceAll(p.File, "\\", "/")
+		base := strings.ToLower(s[strings.LastIndexByte(s, '/')+1:])
+		if dot := strings.LastIndexByte(base, '.'); dot > 0 {
+			base = base[:dot]
+		}
+		toks = append(toks, strings.FieldsFunc(base, func(r rune) bool {
+			return r == '.' || r == ' ' || r == '_' || r == '-' || r == '[' || r == ']' || r == '(' || r == ')' || r == '{' || r == '}'
+		})...)
+	}
+	return toks
+}
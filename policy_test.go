@@ -0,0 +1,50 @@
+package main
+
+import "testing"
+
+func TestParsePolicies_Compose(t *testing.T) {
+	ps, err := ParsePolicies("ignore-4k-1080, ignore-3d-2d")
+	if err != nil {
+		t.Fatalf("ParsePolicies: %v", err)
+	}
+
+	pair4k := Item{Versions: []Version{{VideoResolution: "2160"}, {VideoResolution: "1080"}}}
+	if d := ps.Decide(pair4k); !d.Ignore || d.Reason != reason4kHdPair {
+		t.Fatalf("expected 4k+hd pair ignored, got %+v", d)
+	}
+
+	pair3d := Item{Versions: []Version{
+		{VideoResolution: "1080", Parts: []PartOut{{File: "/m/Avatar (2009) 3D HSBS.mkv"}}},
+		{VideoResolution: "1080", Parts: []PartOut{{File: "/m/Avatar (2009).mkv"}}},
+	}}
+	if d := ps.Decide(pair3d); !d.Ignore || d.Reason != reason3d2dPair {
+		t.Fatalf("expected 3d+2d pair ignored, got %+v", d)
+	}
+
+	dup := Item{Versions: []Version{{VideoResolution: "1080"}, {VideoResolution: "1080"}}}
+	if d := ps.Decide(dup); d.Ignore {
+		t.Fatalf("did not expect plain duplicate to be ignored, got %+v", d)
+	}
+}
+
+func TestParsePolicies_Unknown(t *testing.T) {
+	if _, err := ParsePolicies("plex,nope"); err == nil {
+		t.Fatalf("expected error for unknown policy")
+	}
+}
+
+func TestRegisterPolicy_Custom(t *testing.T) {
+	RegisterPolicy("test-ignore-all", "Ignore everything", PolicyFunc(func(Item) Decision { return Ignore("test") }))
+	defer delete(policyRegistry, "test-ignore-all")
+
+	ps, err := ParsePolicies("plex,test-ignore-all")
+	if err != nil {
+		t.Fatalf("ParsePolicies: %v", err)
+	}
+	if d := ps.Decide(Item{}); !d.Ignore || d.Reason != "test" {
+		t.Fatalf("expected custom policy to apply, got %+v", d)
+	}
+	if ps.Label() != "Plex (all multi-version) + Ignore everything" {
+		t.Fatalf("unexpected label %q", ps.Label())
+	}
+}
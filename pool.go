@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"sync"
+)
+
+// runPool calls fn(ctx, i) for every i in [0, n) using at most `workers`
+// goroutines. Callers store results by index, so output order matches input
+// order no matter which worker finishes first. Once ctx is done no new calls
+// are started and ctx.Err() is returned after in-flight calls return.
+func runPool(ctx context.Context, workers, n int, fn func(ctx context.Context, i int)) error {
+	if workers < 1 {
+		workers = 1
+	}
+	if workers > n {
+		workers = n
+	}
+
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				fn(ctx, i)
+			}
+		}()
+	}
+
+feed:
+	for i := 0; i < n; i++ {
+		select {
+		case <-ctx.Done():
+			break feed
+		case jobs <- i:
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	return ctx.Err()
+}
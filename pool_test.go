@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRunPool_OrderAndBound(t *testing.T) {
+	const n = 50
+	out := make([]int, n)
+	var inFlight, peak int32
+	err := runPool(context.Background(), 4, n, func(ctx context.Context, i int) {
+		cur := atomic.AddInt32(&inFlight, 1)
+		for {
+			p := atomic.LoadInt32(&peak)
+			if cur <= p || atomic.CompareAndSwapInt32(&peak, p, cur) {
+				break
+			}
+		}
+		time.Sleep(time.Millisecond)
+		out[i] = i * i
+		atomic.AddInt32(&inFlight, -1)
+	})
+	if err != nil {
+		t.Fatalf("runPool: %v", err)
+	}
+	for i, v := range out {
+		if v != i*i {
+			t.Fatalf("out[%d] = %d, want %d", i, v, i*i)
+		}
+	}
+	if peak > 4 {
+		t.Fatalf("expected at most 4 concurrent calls, saw %d", peak)
+	}
+}
+
+func TestRunPool_Cancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	var calls int32
+	err := runPool(ctx, 2, 1000, func(ctx context.Context, i int) {
+		if atomic.AddInt32(&calls, 1) == 5 {
+			cancel()
+		}
+	})
+	if err != context.Canceled {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if calls >= 1000 {
+		t.Fatalf("expected cancellation to stop feeding work, got %d calls", calls)
+	}
+}
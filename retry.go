@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// retryBaseDelay is the backoff before the first retry; it doubles per attempt.
+const retryBaseDelay = 500 * time.Millisecond
+
+// transientError wraps a request failure that is worth retrying.
+// after is the server's Retry-After hint (0 if none).
+type transientError struct {
+	err   error
+	after time.Duration
+}
+
+func (e *transientError) Error() string { return e.err.Error() }
+func (e *transientError) Unwrap() error { return e.err }
+
+// retryableStatus reports whether an HTTP status is worth retrying.
+func retryableStatus(code int) bool {
+	return code == http.StatusTooManyRequests || code >= 500
+}
+
+// retryableRead reports whether an error reading a response body is worth
+// retrying: the connection broke or timed out partway through. A body that
+// arrived whole but isn't valid XML is not.
+func retryableRead(err error) bool {
+	var se *xml.SyntaxError
+	if errors.As(err, &se) {
+		return false
+	}
+	var ne net.Error
+	return errors.As(err, &ne) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) || errors.Is(err, context.DeadlineExceeded)
+}
+
+// retryDelay returns how long to wait before retry number attempt+1.
+// A Retry-After hint wins; otherwise exponential backoff with jitter in
+// [d/2, d]. Either way the wait is capped at maxWait (if > 0).
+func retryDelay(attempt int, after, maxWait time.Duration) time.Duration {
+	d := after
+	if d <= 0 {
+		d = retryBaseDelay << min(attempt, 16)
+		d = d/2 + rand.N(d/2+1)
+	}
+	if maxWait > 0 && d > maxWait {
+		d = maxWait
+	}
+	return d
+}
+
+// parseRetryAfter parses a Retry-After header (delay-seconds or HTTP-date).
+// Returns 0 if the header is missing or unparseable.
+func parseRetryAfter(v string, now time.Time) time.Duration {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(v); err == nil {
+		if secs < 0 {
+			return 0
+		}
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil && t.After(now) {
+		return t.Sub(now)
+	}
+	return 0
+}
@@ -0,0 +1,314 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"slices"
+	"strings"
+)
+
+// RuleFile is the -policy-file format: an ordered list of rules describing
+// which sets of versions are intentional (ignore) or worth a closer look (flag).
+type RuleFile struct {
+	Rules []Rule `json:"rules"`
+}
+
+// Rule matches an item by its versions. At least one of Versions, All or Any
+// must be set; when several are set all of them must match.
+type Rule struct {
+	Name   string `json:"name"`
+	Action string `json:"action"` // "ignore" or "flag"
+	Reason string `json:"reason"` // IgnoredItem.Reason / flag reason; defaults to Name
+
+	// Versions matches items with exactly len(Versions) versions, each
+	// matcher paired with a different version (e.g. one 4K HDR + one 1080).
+	Versions []VersionMatcher `json:"versions,omitempty"`
+	All      *VersionMatcher  `json:"all,omitempty"` // every version matches
+	Any      *VersionMatcher  `json:"any,omitempty"` // at least one version matches
+}
+
+// VersionMatcher matches a single version. Empty fields match anything;
+// list fields match if any entry matches (case-insensitive).
+type VersionMatcher struct {
+	Resolution []string `json:"resolution,omitempty"` // normalizeResKey values: 2160, 1080, 720, 480, unknown
+	VideoCodec []string `json:"video_codec,omitempty"`
+	AudioCodec []string `json:"audio_codec,omitempty"`
+	Container  []string `json:"container,omitempty"`
+	HDR        *bool    `json:"hdr,omitempty"`
+	MinBitrate int      `json:"min_bitrate,omitempty"` // kbps, inclusive
+	MaxBitrate int      `json:"max_bitrate,omitempty"` // kbps, inclusive
+	PathGlob   string   `json:"path_glob,omitempty"`   // path.Match on any part file; basename only if no '/'
+	Edition    *string  `json:"edition,omitempty"`     // Version.Edition; "" matches versions without one
+	Library    []string `json:"library,omitempty"`     // section title or ID; cross-library groups only
+
+	// From the deep fetch's streams (without them only "atmos": false matches)
+	DynamicRange     []string `json:"dynamic_range,omitempty"` // sdr, hdr10, hlg, dolby_vision
+	MinBitDepth      int      `json:"min_bit_depth,omitempty"`
+	AudioLanguage    []string `json:"audio_language,omitempty"`     // any audio track
+	MinAudioChannels int      `json:"min_audio_channels,omitempty"` // any audio track
+	Atmos            *bool    `json:"atmos,omitempty"`              // any audio track is Atmos
+	SubtitleLanguage []string `json:"subtitle_language,omitempty"`  // any subtitle track
+}
+
+// Rule actions
+const (
+	ruleActionIgnore = "ignore"
+	ruleActionFlag   = "flag"
+)
+
+var validResKeys = map[string]bool{"2160": true, "1080": true, "720": true, "480": true, "unknown": true}
+
+// LoadRuleFile reads and validates a rule file.
+func LoadRuleFile(filename string) (*RuleFile, error) {
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	var rf RuleFile
+	if err := dec.Decode(&rf); err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	if err := rf.validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	return &rf, nil
+}
+
+// validate checks every rule and fills in defaults.
+func (rf *RuleFile) validate() error {
+	if len(rf.Rules) == 0 {
+		return errors.New("no rules defined")
+	}
+	seen := map[string]bool{}
+	for i := range rf.Rules {
+		r := &rf.Rules[i]
+		where := fmt.Sprintf("rule %d", i+1)
+		if r.Name == "" {
+			return fmt.Errorf("%s: name is required", where)
+		}
+		where = fmt.Sprintf("rule %q", r.Name)
+		if seen[r.Name] {
+			return fmt.Errorf("%s: duplicate name", where)
+		}
+		seen[r.Name] = true
+
+		r.Action = strings.ToLower(strings.TrimSpace(r.Action))
+		if r.Action != ruleActionIgnore && r.Action != ruleActionFlag {
+			return fmt.Errorf("%s: action must be %q or %q, got %q", where, ruleActionIgnore, ruleActionFlag, r.Action)
+		}
+		if r.Reason == "" {
+			r.Reason = r.Name
+		}
+		if len(r.Versions) == 0 && r.All == nil && r.Any == nil {
+			return fmt.Errorf("%s: needs at least one of versions, all, any", where)
+		}
+
+		ms := append([]*VersionMatcher{}, r.All, r.Any)
+		for j := range r.Versions {
+			ms = append(ms, &r.Versions[j])
+		}
+		for _, m := range ms {
+			if m == nil {
+				continue
+			}
+			if err := m.validate(); err != nil {
+				return fmt.Errorf("%s: %w", where, err)
+			}
+		}
+	}
+	return nil
+}
+
+func (m *VersionMatcher) validate() error {
+	for _, r := range m.Resolution {
+		if !validResKeys[strings.ToLower(r)] {
+			return fmt.Errorf("unknown resolution %q (use 2160, 1080, 720, 480 or unknown)", r)
+		}
+	}
+	for _, r := range m.DynamicRange {
+		if !dynamicRanges[strings.ToLower(r)] {
+			return fmt.Errorf("unknown dynamic_range %q (use sdr, hdr10, hlg or dolby_vision)", r)
+		}
+	}
+	if m.MinBitDepth < 0 || m.MinAudioChannels < 0 {
+		return errors.New("min_bit_depth and min_audio_channels must not be negative")
+	}
+	if m.MinBitrate < 0 || m.MaxBitrate < 0 || (m.MaxBitrate > 0 && m.MinBitrate > m.MaxBitrate) {
+		return fmt.Errorf("invalid bitrate range %d..%d", m.MinBitrate, m.MaxBitrate)
+	}
+	if m.PathGlob != "" {
+		if _, err := path.Match(m.PathGlob, ""); err != nil {
+			return fmt.Errorf("bad path_glob %q: %w", m.PathGlob, err)
+		}
+	}
+	return nil
+}
+
+// Match reports whether the version satisfies every set field.
+func (m *VersionMatcher) Match(v Version) bool {
+	if len(m.Resolution) > 0 && !containsFold(m.Resolution, normalizeResKey(v)) {
+		return false
+	}
+	if len(m.VideoCodec) > 0 && !containsFold(m.VideoCodec, v.VideoCodec) {
+		return false
+	}
+	if len(m.AudioCodec) > 0 && !containsFold(m.AudioCodec, v.AudioCodec) {
+		return false
+	}
+	if len(m.Container) > 0 && !containsFold(m.Container, v.Container) {
+		return false
+	}
+	if m.HDR != nil && *m.HDR != versionIsHDR(v) {
+		return false
+	}
+	if len(m.DynamicRange) > 0 && !containsFold(m.DynamicRange, versionDynamicRange(v)) {
+		return false
+	}
+	if m.MinBitDepth > 0 && (v.Video == nil || v.Video.BitDepth < m.MinBitDepth) {
+		return false
+	}
+	if !m.matchAudio(v) {
+		return false
+	}
+	if len(m.SubtitleLanguage) > 0 && !slices.ContainsFunc(v.Subtitles, func(s SubtitleStream) bool {
+		return containsFold(m.SubtitleLanguage, s.Language)
+	}) {
+		return false
+	}
+	if m.MinBitrate > 0 && v.Bitrate < m.MinBitrate {
+		return false
+	}
+	if m.MaxBitrate > 0 && v.Bitrate > m.MaxBitrate {
+		return false
+	}
+	if m.PathGlob != "" && !anyPartMatches(v, m.PathGlob) {
+		return false
+	}
+	if m.Edition != nil && !strings.EqualFold(*m.Edition, v.Edition) {
+		return false
+	}
+	if len(m.Library) > 0 && !containsFold(m.Library, v.Library) && !containsFold(m.Library, v.SectionID) {
+		return false
+	}
+	return true
+}
+
+// matchAudio checks the audio fields; each may be met by a different track.
+func (m *VersionMatcher) matchAudio(v Version) bool {
+	if len(m.AudioLanguage) > 0 && !slices.ContainsFunc(v.Audio, func(a AudioStream) bool {
+		return containsFold(m.AudioLanguage, a.Language)
+	}) {
+		return false
+	}
+	if m.MinAudioChannels > 0 && !slices.ContainsFunc(v.Audio, func(a AudioStream) bool {
+		return a.Channels >= m.MinAudioChannels
+	}) {
+		return false
+	}
+	if m.Atmos != nil && *m.Atmos != slices.ContainsFunc(v.Audio, func(a AudioStream) bool { return a.Atmos }) {
+		return false
+	}
+	return true
+}
+
+// Match reports whether the rule applies to the item.
+func (r *Rule) Match(it Item) bool {
+	if len(r.Versions) > 0 && !matchVersionSet(r.Versions, it.Versions) {
+		return false
+	}
+	if r.All != nil {
+		for _, v := range it.Versions {
+			if !r.All.Match(v) {
+				return false
+			}
+		}
+	}
+	if r.Any != nil {
+		found := false
+		for _, v := range it.Versions {
+			if r.Any.Match(v) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// matchVersionSet pairs each matcher with a distinct version (simple
+// backtracking; items rarely have more than a handful of versions).
+func matchVersionSet(ms []VersionMatcher, vs []Version) bool {
+	if len(ms) != len(vs) {
+		return false
+	}
+	used := make([]bool, len(vs))
+	var try func(i int) bool
+	try = func(i int) bool {
+		if i == len(ms) {
+			return true
+		}
+		for j, v := range vs {
+			if !used[j] && ms[i].Match(v) {
+				used[j] = true
+				if try(i + 1) {
+					return true
+				}
+				used[j] = false
+			}
+		}
+		return false
+	}
+	return try(0)
+}
+
+// Decide applies the rules in order: the first matching "ignore" rule wins;
+// every matching "flag" rule before it is reported as a flag.
+func (rf *RuleFile) Decide(it Item) Decision {
+	var d Decision
+	for i := range rf.Rules {
+		r := &rf.Rules[i]
+		if !r.Match(it) {
+			continue
+		}
+		if r.Action == ruleActionIgnore {
+			d.Ignore, d.Reason, d.Rule = true, r.Reason, r.Name
+			return d
+		}
+		d.Flags = append(d.Flags, RuleFlag{Rule: r.Name, Reason: r.Reason})
+	}
+	return d
+}
+
+// anyPartMatches matches a glob against the version's part files. Patterns
+// without a '/' are matched against the basename only.
+func anyPartMatches(v Version, glob string) bool {
+	for _, p := range v.Parts {
+		f := strings.ReplaceAll(p.File, "\\", "/")
+		if !strings.Contains(glob, "/") {
+			f = path.Base(f)
+		}
+		if ok, _ := path.Match(glob, f); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// containsFold reports whether list contains s, ignoring case.
+func containsFold(list []string, s string) bool {
+	for _, x := range list {
+		if strings.EqualFold(strings.TrimSpace(x), s) {
+			return true
+		}
+	}
+	return false
+}
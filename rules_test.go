@@ -0,0 +1,107 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const sampleRules = `{
+  "rules": [
+    {
+      "name": "uhd-hdr-plus-remux",
+      "action": "ignore",
+      "reason": "4k_hdr+1080_remux",
+      "versions": [
+        {"resolution": ["2160"], "hdr": true},
+        {"resolution": ["1080"], "container": ["mkv"], "min_bitrate": 20000}
+      ]
+    },
+    {
+      "name": "low-bitrate",
+      "action": "flag",
+      "reason": "contains a sub-2Mbps encode",
+      "any": {"max_bitrate": 2000}
+    },
+    {
+      "name": "samples",
+      "action": "ignore",
+      "any": {"path_glob": "*[Ss]ample*"}
+    }
+  ]
+}`
+
+func writeRules(t *testing.T, body string) string {
+	t.Helper()
+	fn := filepath.Join(t.TempDir(), "rules.json")
+	if err := os.WriteFile(fn, []byte(body), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return fn
+}
+
+func TestRuleFile_Decide(t *testing.T) {
+	rf, err := LoadRuleFile(writeRules(t, sampleRules))
+	if err != nil {
+		t.Fatalf("LoadRuleFile: %v", err)
+	}
+
+	set := Item{Versions: []Version{
+		{VideoResolution: "1080", Container: "mkv", Bitrate: 30000, Parts: []PartOut{{File: "/m/Film (2020) Remux.mkv"}}},
+		{VideoResolution: "4k", Container: "mkv", Bitrate: 50000, Parts: []PartOut{{File: "/m/Film (2020) 2160p HDR.mkv"}}},
+	}}
+	d := rf.Decide(set)
+	if !d.Ignore || d.Rule != "uhd-hdr-plus-remux" || d.Reason != "4k_hdr+1080_remux" {
+		t.Fatalf("expected remux set ignored by rule, got %+v", d)
+	}
+
+	lowBitrate := Item{Versions: []Version{
+		{VideoResolution: "1080", Bitrate: 8000, Parts: []PartOut{{File: "/m/A.mkv"}}},
+		{VideoResolution: "720", Bitrate: 1500, Parts: []PartOut{{File: "/m/A.avi"}}},
+	}}
+	d = rf.Decide(lowBitrate)
+	if d.Ignore || len(d.Flags) != 1 || d.Flags[0].Rule != "low-bitrate" {
+		t.Fatalf("expected kept item flagged by low-bitrate, got %+v", d)
+	}
+
+	sample := Item{Versions: []Version{
+		{Bitrate: 8000, Parts: []PartOut{{File: "/m/B/B-Sample.mkv"}}},
+		{Bitrate: 8000, Parts: []PartOut{{File: "/m/B/B.mkv"}}},
+	}}
+	d = rf.Decide(sample)
+	if !d.Ignore || d.Reason != "samples" {
+		t.Fatalf("expected reason to default to rule name, got %+v", d)
+	}
+}
+
+func TestLoadRuleFile_Validation(t *testing.T) {
+	cases := map[string]string{
+		"bad action":     `{"rules":[{"name":"x","action":"delete","all":{}}]}`,
+		"no matcher":     `{"rules":[{"name":"x","action":"ignore"}]}`,
+		"bad resolution": `{"rules":[{"name":"x","action":"ignore","any":{"resolution":["4k"]}}]}`,
+		"bad glob":       `{"rules":[{"name":"x","action":"ignore","any":{"path_glob":"["}}]}`,
+		"unknown field":  `{"rules":[{"name":"x","action":"ignore","any":{"codec":["hevc"]}}]}`,
+		"duplicate name": `{"rules":[{"name":"x","action":"flag","all":{}},{"name":"x","action":"flag","all":{}}]}`,
+	}
+	for name, body := range cases {
+		if _, err := LoadRuleFile(writeRules(t, body)); err == nil {
+			t.Errorf("%s: expected validation error", name)
+		}
+	}
+}
+
+func TestBuildPolicy_RulesBeforeDupPolicy(t *testing.T) {
+	fn := writeRules(t, `{"rules":[{"name":"any-4k","action":"ignore","reason":"has_4k","any":{"resolution":["2160"]}}]}`)
+	ps, err := BuildPolicy(Options{DupPolicy: "ignore-4k-1080", PolicyFile: fn})
+	if err != nil {
+		t.Fatalf("BuildPolicy: %v", err)
+	}
+	it := Item{Versions: []Version{{VideoResolution: "2160"}, {VideoResolution: "1080"}}}
+	if d := ps.Decide(it); d.Reason != "has_4k" || d.Rule != "any-4k" {
+		t.Fatalf("expected rule file to win, got %+v", d)
+	}
+	if !strings.Contains(ps.Label(), "rules.json") {
+		t.Fatalf("expected label to mention the rule file, got %q", ps.Label())
+	}
+}
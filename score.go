@@ -0,0 +1,206 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ScoreModel weights the quality signals used to pick the version to keep.
+// Each signal is scored 0..1 and a version's score is the weighted sum.
+type ScoreModel struct {
+	Resolution float64
+	Codec      float64
+	Bitrate    float64
+	Audio      float64
+	Container  float64
+	HDR        float64
+	Size       float64
+}
+
+// DefaultScoreModel favors resolution and HDR, then bitrate and codec.
+var DefaultScoreModel = ScoreModel{
+	Resolution: 40,
+	HDR:        15,
+	Bitrate:    15,
+	Codec:      10,
+	Audio:      10,
+	Container:  5,
+	Size:       5,
+}
+
+// ParseScoreWeights overrides DefaultScoreModel with "name=weight" pairs,
+// e.g. "resolution=50,size=0". An empty string returns the defaults.
+func ParseScoreWeights(s string) (ScoreModel, error) {
+	m := DefaultScoreModel
+	fields := map[string]*float64{
+		"resolution": &m.Resolution,
+		"codec":      &m.Codec,
+		"bitrate":    &m.Bitrate,
+		"audio":      &m.Audio,
+		"container":  &m.Container,
+		"hdr":        &m.HDR,
+		"size":       &m.Size,
+	}
+	for _, kv := range strings.Split(s, ",") {
+		kv = strings.TrimSpace(kv)
+		if kv == "" {
+			continue
+		}
+		k, v, ok := strings.Cut(kv, "=")
+		if !ok {
+			return m, fmt.Errorf("score weight %q: expected name=weight", kv)
+		}
+		dst, known := fields[strings.ToLower(strings.TrimSpace(k))]
+		if !known {
+			return m, fmt.Errorf("unknown score weight %q (use resolution, codec, bitrate, audio, container, hdr, size)", k)
+		}
+		w, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		if err != nil || w < 0 || math.IsInf(w, 0) || math.IsNaN(w) {
+			return m, fmt.Errorf("score weight %q: weight must be a number >= 0", kv)
+		}
+		*dst = w
+	}
+	return m, nil
+}
+
+// Relative quality tables (0..1). Unknown values score 0.
+var (
+	resolutionScores = map[string]float64{"2160": 1, "1080": 0.75, "720": 0.5, "480": 0.25}
+	codecScores      = map[string]float64{
+		"av1": 1, "hevc": 0.9, "h265": 0.9, "vp9": 0.8, "h264": 0.6, "avc": 0.6,
+		"vc1": 0.3, "mpeg4": 0.3, "xvid": 0.3, "divx": 0.3, "mpeg2video": 0.2, "wmv3": 0.2,
+	}
+	audioScores = map[string]float64{
+		"truehd": 1, "dca-ma": 0.95, "flac": 0.95, "pcm": 0.9, "dca": 0.8, "eac3": 0.7,
+		"ac3": 0.6, "opus": 0.55, "aac": 0.5, "vorbis": 0.45, "mp3": 0.3, "mp2": 0.2,
+	}
+	containerScores = map[string]float64{"mkv": 1, "mp4": 0.9, "m4v": 0.9, "mov": 0.7, "ts": 0.5, "avi": 0.4, "wmv": 0.3}
+)
+
+// versionBytes sums the part sizes of a version.
+func versionBytes(v Version) int64 {
+	var n int64
+	for _, p := range v.Parts {
+		n += p.Size
+	}
+	return n
+}
+
+// partIsGhost is true when verification ran on the part and found it missing
+// or unreadable. Parts of items that weren't deep-fetched are unknown, not ghosts.
+func partIsGhost(p PartOut, verify bool) bool {
+	return verify && !p.Unverified && !p.VerifiedOnDisk
+}
+
+// versionIsGhost is true when verification ran and any part is missing or unreadable.
+func versionIsGhost(v Version, verify bool) bool {
+	for _, p := range v.Parts {
+		if partIsGhost(p, verify) {
+			return true
+		}
+	}
+	return false
+}
+
+// scoreVersions scores every version of an item. Bitrate and size are
+// relative to the best version of the same item.
+func scoreVersions(m ScoreModel, vs []Version) []float64 {
+	var maxBitrate int
+	var maxBytes int64
+	for _, v := range vs {
+		maxBitrate = max(maxBitrate, v.Bitrate)
+		maxBytes = max(maxBytes, versionBytes(v))
+	}
+	scores := make([]float64, len(vs))
+	for i, v := range vs {
+		s := m.Resolution*resolutionScores[normalizeResKey(v)] +
+			m.Codec*codecScores[strings.ToLower(v.VideoCodec)] +
+			m.Audio*audioScores[strings.ToLower(v.AudioCodec)] +
+			m.Container*containerScores[strings.ToLower(v.Container)]
+		if versionIsHDR(v) {
+			s += m.HDR
+		}
+		if maxBitrate > 0 {
+			s += m.Bitrate * float64(v.Bitrate) / float64(maxBitrate)
+		}
+		if maxBytes > 0 {
+			s += m.Size * float64(versionBytes(v)) / float64(maxBytes)
+		}
+		scores[i] = math.Round(s*100) / 100
+	}
+	return scores
+}
+
+// recommend picks the version to keep and lists the rest as removal
+// candidates. Versions missing on disk are never picked if another exists.
+// Scores are written back into it.Versions.
+func recommend(it *Item, m ScoreModel, verify bool) *Recommendation {
+	if len(it.Versions) < 2 {
+		return nil
+	}
+	scores := scoreVersions(m, it.Versions)
+	order := make([]int, len(it.Versions))
+	for i := range order {
+		order[i] = i
+		it.Versions[i].Score = scores[i]
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		ga, gb := versionIsGhost(it.Versions[order[a]], verify), versionIsGhost(it.Versions[order[b]], verify)
+		if ga != gb {
+			return !ga
+		}
+		return scores[order[a]] > scores[order[b]]
+	})
+
+	keep := it.Versions[order[0]]
+	rec := &Recommendation{Keep: keep.ID}
+	for _, i := range order[1:] {
+		v := it.Versions[i]
+		c := RemovalCandidate{
+			VersionID: v.ID,
+			Score:     v.Score,
+			Bytes:     versionBytes(v),
+			Reasons:   removalReasons(keep, v, verify),
+		}
+		rec.CandidatesForRemoval = append(rec.CandidatesForRemoval, c)
+		rec.ReclaimableBytes += c.Bytes
+	}
+	return rec
+}
+
+// removalReasons explains why v lost to keep, strongest signals first.
+func removalReasons(keep, v Version, verify bool) []string {
+	var rs []string
+	if versionIsGhost(v, verify) {
+		rs = append(rs, "missing or unreadable on disk")
+	}
+	if kr, vr := normalizeResKey(keep), normalizeResKey(v); resolutionScores[vr] < resolutionScores[kr] {
+		rs = append(rs, fmt.Sprintf("lower resolution (%s vs %s)", vr, kr))
+	}
+	if versionIsHDR(keep) && !versionIsHDR(v) {
+		rs = append(rs, "no HDR")
+	}
+	if codecScores[strings.ToLower(v.VideoCodec)] < codecScores[strings.ToLower(keep.VideoCodec)] {
+		rs = append(rs, fmt.Sprintf("less efficient video codec (%s vs %s)", v.VideoCodec, keep.VideoCodec))
+	}
+	if v.Bitrate < keep.Bitrate {
+		rs = append(rs, fmt.Sprintf("lower bitrate (%d vs %d kbps)", v.Bitrate, keep.Bitrate))
+	}
+	if audioScores[strings.ToLower(v.AudioCodec)] < audioScores[strings.ToLower(keep.AudioCodec)] {
+		rs = append(rs, fmt.Sprintf("weaker audio codec (%s vs %s)", v.AudioCodec, keep.AudioCodec))
+	}
+	if containerScores[strings.ToLower(v.Container)] < containerScores[strings.ToLower(keep.Container)] {
+		rs = append(rs, fmt.Sprintf("less preferred container (%s vs %s)", v.Container, keep.Container))
+	}
+	if len(rs) == 0 {
+		if v.Score < keep.Score {
+			rs = append(rs, fmt.Sprintf("lower overall score (%.2f vs %.2f)", v.Score, keep.Score))
+		} else {
+			rs = append(rs, "same quality as the kept version")
+		}
+	}
+	return rs
+}
@@ -0,0 +1,56 @@
+package main
+
+import "testing"
+
+func TestRecommend_KeepsBest(t *testing.T) {
+	it := Item{Versions: []Version{
+		{ID: "hd", VideoResolution: "1080", VideoCodec: "h264", AudioCodec: "ac3", Container: "mkv", Bitrate: 10000,
+			Parts: []PartOut{{File: "/m/Film.1080p.mkv", Size: 8 << 30, VerifiedOnDisk: true}}},
+		{ID: "uhd", VideoResolution: "4k", VideoCodec: "hevc", AudioCodec: "truehd", Container: "mkv", Bitrate: 50000,
+			Parts: []PartOut{{File: "/m/Film.2160p.HDR.mkv", Size: 40 << 30, VerifiedOnDisk: true}}},
+		{ID: "sd", VideoResolution: "sd", VideoCodec: "mpeg4", AudioCodec: "mp3", Container: "avi", Bitrate: 1500,
+			Parts: []PartOut{{File: "/m/Film.avi", Size: 1 << 30, VerifiedOnDisk: true}}},
+	}}
+	rec := recommend(&it, DefaultScoreModel, true)
+	if rec == nil || rec.Keep != "uhd" {
+		t.Fatalf("expected to keep the 4K version, got %+v", rec)
+	}
+	if len(rec.CandidatesForRemoval) != 2 || rec.CandidatesForRemoval[0].VersionID != "hd" {
+		t.Fatalf("expected hd then sd as removal candidates, got %+v", rec.CandidatesForRemoval)
+	}
+	if rec.ReclaimableBytes != 9<<30 {
+		t.Fatalf("expected 9 GiB reclaimable, got %d", rec.ReclaimableBytes)
+	}
+	if it.Versions[1].Score <= it.Versions[0].Score {
+		t.Fatalf("expected scores written back to versions: %+v", it.Versions)
+	}
+}
+
+func TestRecommend_SkipsGhosts(t *testing.T) {
+	it := Item{Versions: []Version{
+		{ID: "gone", VideoResolution: "2160", Parts: []PartOut{{File: "/m/a.mkv", Size: 100}}},
+		{ID: "here", VideoResolution: "1080", Parts: []PartOut{{File: "/m/b.mkv", Size: 50, VerifiedOnDisk: true}}},
+	}}
+	rec := recommend(&it, DefaultScoreModel, true)
+	if rec.Keep != "here" {
+		t.Fatalf("expected the version present on disk to be kept, got %q", rec.Keep)
+	}
+	if rec.CandidatesForRemoval[0].Reasons[0] != "missing or unreadable on disk" {
+		t.Fatalf("expected ghost reason first, got %v", rec.CandidatesForRemoval[0].Reasons)
+	}
+}
+
+func TestParseScoreWeights(t *testing.T) {
+	m, err := ParseScoreWeights("resolution=0, size=100")
+	if err != nil {
+		t.Fatalf("ParseScoreWeights: %v", err)
+	}
+	if m.Resolution != 0 || m.Size != 100 || m.Codec != DefaultScoreModel.Codec {
+		t.Fatalf("unexpected model %+v", m)
+	}
+	for _, bad := range []string{"resolution", "speed=1", "size=-1", "size=abc"} {
+		if _, err := ParseScoreWeights(bad); err == nil {
+			t.Errorf("expected error for %q", bad)
+		}
+	}
+}
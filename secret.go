@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"regexp"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Plex tokens are full account credentials. They can come from -token, from
+// a file (-token-file) or from a helper command (-token-cmd, e.g. `pass
+// plex/token` or `secret-tool lookup service plex`), and must never show up
+// in logs, error messages or reports: every token a Client uses is
+// registered here and redact() masks it wherever it appears.
+
+// tokenCmdTimeout bounds how long -token-cmd may run (it may prompt, e.g. for a GPG passphrase).
+const tokenCmdTimeout = 2 * time.Minute
+
+// redacted replaces secrets in output.
+const redacted = "REDACTED"
+
+var secrets struct {
+	sync.RWMutex
+	list []string
+}
+
+// registerSecret makes redact mask s from now on. Very short values are
+// ignored; masking them would mangle unrelated text.
+func registerSecret(s string) {
+	if len(s) < 4 {
+		return
+	}
+	secrets.Lock()
+	defer secrets.Unlock()
+	for _, have := range secrets.list {
+		if have == s {
+			return
+		}
+	}
+	secrets.list = append(secrets.list, s)
+}
+
+// tokenParam matches a token passed in a query string, registered or not.
+var tokenParam = regexp.MustCompile(`(?i)(x-plex-token=)[^&\s"']+`)
+
+// redact masks registered secrets and X-Plex-Token query values in s.
+func redact(s string) string {
+	secrets.RLock()
+	for _, sec := range secrets.list {
+		s = strings.ReplaceAll(s, sec, redacted)
+	}
+	secrets.RUnlock()
+	return tokenParam.ReplaceAllString(s, "${1}"+redacted)
+}
+
+// redactURL returns rawURL without credentials: user info and an
+// X-Plex-Token query parameter are dropped. Anything unparseable is
+// passed through redact.
+func redactURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return redact(rawURL)
+	}
+	u.User = nil
+	if q := u.Query(); u.RawQuery != "" {
+		for k := range q {
+			if strings.EqualFold(k, "X-Plex-Token") {
+				q.Del(k)
+			}
+		}
+		u.RawQuery = q.Encode()
+	}
+	return redact(u.String())
+}
+
+// resolveToken fills o.Token from -token-file or -token-cmd. At most one
+// token source may be set; an empty token is left for the caller to reject.
+func resolveToken(ctx context.Context, o *Options) error {
+	if err := checkTokenSources(*o); err != nil {
+		return err
+	}
+	switch {
+	case o.TokenFile != "":
+		tok, err := readTokenFile(o.TokenFile)
+		if err != nil {
+			return err
+		}
+		o.Token = tok
+	case o.TokenCmd != "":
+		tok, err := runTokenCmd(ctx, o.TokenCmd)
+		if err != nil {
+			return err
+		}
+		o.Token = tok
+	}
+	return nil
+}
+
+// checkTokenSources rejects options that name more than one token source.
+// applySettings fills them from a single level, so this only fires for two
+// sources given at the same level (e.g. both as flags).
+func checkTokenSources(o Options) error {
+	n := 0
+	for _, v := range []string{o.Token, o.TokenFile, o.TokenCmd} {
+		if v != "" {
+			n++
+		}
+	}
+	if n > 1 {
+		return errors.New("give only one of -token, -token-file and -token-cmd (at the same level: flags, GOPLEXR_*/PLEX_TOKEN, or the settings file)")
+	}
+	return nil
+}
+
+// readTokenFile reads a token from the first line of path. It warns when
+// the file is readable by other users.
+func readTokenFile(path string) (string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("token file: %w", err)
+	}
+	if fi, err := os.Stat(path); err == nil && runtime.GOOS != "windows" && fi.Mode().Perm()&0o077 != 0 {
+		fmt.Fprintf(os.Stderr, "WARN: token file %s is accessible by other users (mode %s); chmod 600 it\n", path, fi.Mode().Perm())
+	}
+	tok, _, _ := strings.Cut(string(b), "\n")
+	tok = strings.TrimSpace(tok)
+	if tok == "" {
+		return "", fmt.Errorf("token file %s is empty", path)
+	}
+	return tok, nil
+}
+
+// runTokenCmd runs command with the system shell and returns the first line
+// of its stdout. The helper's stderr goes to ours (so it can prompt); its
+// stdout is never included in errors.
+func runTokenCmd(ctx context.Context, command string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, tokenCmdTimeout)
+	defer cancel()
+
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", command)
+	} else {
+		cmd = exec.CommandContext(ctx, "/bin/sh", "-c", command)
+	}
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = os.Stderr
+	cmd.Stdin = os.Stdin
+	if err := cmd.Run(); err != nil {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return "", fmt.Errorf("token command timed out after %s", tokenCmdTimeout)
+		}
+		return "", fmt.Errorf("token command: %w", err)
+	}
+	tok, _, _ := strings.Cut(stdout.String(), "\n")
+	tok = strings.TrimSpace(tok)
+	if tok == "" {
+		return "", errors.New("token command printed nothing")
+	}
+	return tok, nil
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRedact(t *testing.T) {
+	registerSecret("s3cr3t-token-abc")
+	registerSecret("abc") // too short to mask
+	got := redact("GET /x?X-Plex-Token=other&a=1 failed with s3cr3t-token-abc")
+	if strings.Contains(got, "s3cr3t") || strings.Contains(got, "other") || !strings.Contains(got, "a=1") {
+		t.Errorf("redact = %q", got)
+	}
+
+	cases := map[string]string{
+		"http://plex:32400/?X-Plex-Token=zzz": "http://plex:32400/",
+		"https://user:pw@plex:32400/base?a=b": "https://plex:32400/base?a=b",
+		"http://plex:32400":                   "http://plex:32400",
+	}
+	for in, want := range cases {
+		if got := redactURL(in); got != want {
+			t.Errorf("redactURL(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestResolveToken(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(fn, []byte("  from-file \nsecond line\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	o := Options{TokenFile: fn}
+	if err := resolveToken(context.Background(), &o); err != nil || o.Token != "from-file" {
+		t.Errorf("token file: token = %q, err = %v", o.Token, err)
+	}
+
+	o = Options{Token: "x", TokenFile: fn}
+	if err := resolveToken(context.Background(), &o); err == nil {
+		t.Errorf("expected an error for two token sources")
+	}
+	empty := filepath.Join(t.TempDir(), "empty")
+	_ = os.WriteFile(empty, []byte("\n"), 0o600)
+	if err := resolveToken(context.Background(), &Options{TokenFile: empty}); err == nil {
+		t.Errorf("expected an error for an empty token file")
+	}
+
+	if runtime.GOOS == "windows" {
+		return
+	}
+	o = Options{TokenCmd: "echo from-cmd"}
+	if err := resolveToken(context.Background(), &o); err != nil || o.Token != "from-cmd" {
+		t.Errorf("token cmd: token = %q, err = %v", o.Token, err)
+	}
+	for _, cmd := range []string{"exit 3", "true"} {
+		if err := resolveToken(context.Background(), &Options{TokenCmd: cmd}); err == nil {
+			t.Errorf("%q: expected an error", cmd)
+		}
+	}
+}
+
+func TestClient_RedactsToken(t *testing.T) {
+	const token = "tok-echoed-back-123"
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "bad token "+r.Header.Get("X-Plex-Token"), http.StatusUnauthorized)
+	}))
+	defer ts.Close()
+
+	pc, err := NewClient(Options{BaseURL: ts.URL + "/?X-Plex-Token=" + token, Token: token, Timeout: 5 * time.Second})
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	if strings.Contains(pc.BaseURL(), token) {
+		t.Errorf("BaseURL leaks the token: %s", pc.BaseURL())
+	}
+	_, err = pc.DiscoverSections(context.Background(), false)
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	if strings.Contains(err.Error(), token) || strings.Contains(newScanError("1", "", err).Message, token) {
+		t.Errorf("error leaks the token: %v", err)
+	}
+}
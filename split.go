@@ -0,0 +1,256 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Split duplicates are separate items (different rating keys) in one section
+// that are really the same title: a second match, an unmatched copy, etc.
+// Plex's duplicate=1 filter can't see them, so they're found by listing the
+// whole section and joining items on their GUIDs, normalized title+year and
+// part files.
+
+// fetchAllListings lists every item of each section in parallel. A failed
+// section is recorded in errs and left nil; an empty one is non-nil.
+func fetchAllListings(ctx context.Context, pc *Client, o Options, sections []Directory, errs *[]ScanError) ([][]Video, error) {
+	all := make([][]Video, len(sections))
+	fetchErrs := make([]error, len(sections))
+	err := runPool(ctx, o.Concurrency, len(sections), func(ctx context.Context, i int) {
+		all[i], fetchErrs[i] = pc.FetchAllItems(ctx, sections[i].Key, sections[i].Type)
+		if fetchErrs[i] == nil && all[i] == nil {
+			all[i] = []Video{}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	for i, e := range fetchErrs {
+		if e != nil {
+			*errs = append(*errs, newScanError(sections[i].Key, "", e))
+		}
+	}
+	return all, nil
+}
+
+// findSplitDuplicates groups the items of one section listing that share a
+// GUID, an external ID or a normalized title+year (show+episode for episodes).
+// With editionAware, items are only joined with items of the same edition:
+// a separate Extended item next to the theatrical one is not a duplicate.
+func findSplitDuplicates(sec Directory, vids []Video, editionAware bool) []SplitGroup {
+	items := make([]Item, len(vids))
+	byEdition := map[string][]int{}
+	var editions []string
+	for i := range vids {
+		items[i] = listedItem(&vids[i])
+		e := ""
+		if editionAware {
+			e = strings.ToLower(regularEdition(fallback(strings.TrimSpace(vids[i].EditionTitle), itemEdition(items[i]))))
+		}
+		if _, ok := byEdition[e]; !ok {
+			editions = append(editions, e)
+		}
+		byEdition[e] = append(byEdition[e], i)
+	}
+
+	var groups []SplitGroup
+	for _, e := range editions {
+		idx := byEdition[e]
+		keys := make([][]string, len(idx))
+		for j, i := range idx {
+			keys[j] = matchKeys(&vids[i])
+		}
+		for _, g := range joinByKeys(keys) {
+			sg := SplitGroup{
+				SectionID:    sec.Key,
+				SectionTitle: sec.Title,
+				MatchedOn:    g.shared,
+			}
+			for _, j := range g.members {
+				sg.Items = append(sg.Items, items[idx[j]])
+			}
+			groups = append(groups, sg)
+		}
+	}
+	sort.SliceStable(groups, func(a, b int) bool {
+		return strings.ToLower(itemLabel(groups[a].Items[0])) < strings.ToLower(itemLabel(groups[b].Items[0]))
+	})
+	return groups
+}
+
+// listedItem builds an Item (with versions) from a listing entry.
+func listedItem(v *Video) Item {
+	it := newItem(v, v)
+	it.ExternalIDs = externalIDs(v)
+	for _, m := range v.Media {
+		it.Versions = append(it.Versions, newVersion(m, v.EditionTitle))
+	}
+	return it
+}
+
+// keyGroup is a set of entries (indexes) joined by at least one shared key.
+type keyGroup struct {
+	members []int
+	shared  []string // keys held by more than one member, sorted
+}
+
+// joinByKeys joins entries that share any key (transitively) and returns the
+// groups with two or more members, in order of their first member.
+func joinByKeys(keys [][]string) []keyGroup {
+	parent := make([]int, len(keys))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+
+	owner := map[string]int{}
+	count := map[string]int{}
+	for i, ks := range keys {
+		for _, k := range ks {
+			count[k]++
+			if j, ok := owner[k]; ok {
+				if a, b := find(i), find(j); a != b {
+					parent[max(a, b)] = min(a, b)
+				}
+				continue
+			}
+			owner[k] = i
+		}
+	}
+
+	idx := map[int]int{}
+	var groups []keyGroup
+	for i := range keys {
+		root := find(i)
+		gi, ok := idx[root]
+		if !ok {
+			gi = len(groups)
+			idx[root] = gi
+			groups = append(groups, keyGroup{})
+		}
+		groups[gi].members = append(groups[gi].members, i)
+	}
+
+	var out []keyGroup
+	for _, g := range groups {
+		if len(g.members) < 2 {
+			continue
+		}
+		seen := map[string]bool{}
+		for _, i := range g.members {
+			for _, k := range keys[i] {
+				if count[k] > 1 && !seen[k] {
+					seen[k] = true
+					g.shared = append(g.shared, k)
+				}
+			}
+		}
+		sort.Strings(g.shared)
+		out = append(out, g)
+	}
+	return out
+}
+
+// matchKeys returns the keys an item can be joined on: its Plex GUID and
+// external IDs (skipping local/unmatched agents), its normalized title and
+// the files of its parts.
+func matchKeys(v *Video) []string {
+	var keys []string
+	seen := map[string]bool{}
+	add := func(k string) {
+		if k != "" && !seen[k] {
+			seen[k] = true
+			keys = append(keys, k)
+		}
+	}
+	add(normalizeGuid(v.Guid))
+	for _, id := range externalIDs(v) {
+		add(id)
+	}
+	add(titleKey(v))
+	for _, m := range v.Media {
+		for _, p := range m.Part {
+			add(fileKey(p.File))
+		}
+	}
+	return keys
+}
+
+// fileKey is "file:<path>" with the path cleaned (and, for Windows paths,
+// lowercased with forward slashes), so two items whose parts point at the
+// same file are joined whatever their metadata says.
+func fileKey(file string) string {
+	file = strings.TrimSpace(file)
+	if file == "" {
+		return ""
+	}
+	if windowsPath(file) {
+		file = strings.ToLower(strings.ReplaceAll(file, `\`, "/"))
+	}
+	return "file:" + path.Clean(file)
+}
+
+// externalIDs returns the item's external Guid children (imdb://, tmdb://, tvdb://).
+func externalIDs(v *Video) []string {
+	var ids []string
+	for _, g := range v.Guids {
+		if id := normalizeGuid(g.ID); id != "" {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// normalizeGuid drops agent options ("?lang=en") and returns "" for GUIDs
+// that don't identify a title (local media, the "none" agent).
+func normalizeGuid(g string) string {
+	g, _, _ = strings.Cut(strings.TrimSpace(g), "?")
+	if g == "" || strings.HasPrefix(g, "local://") || strings.Contains(g, "agents.none://") {
+		return ""
+	}
+	return strings.ToLower(g)
+}
+
+// titleKey is "title:<normalized title> (<year>)" for movies and
+// "episode:<normalized show> sXXeYY" for episodes; "" if there's not enough
+// to go on (no year, no episode numbers).
+func titleKey(v *Video) string {
+	if v.Type == "episode" {
+		show := normalizeTitle(v.GrandparentTitle)
+		if show == "" || v.ParentIndex == 0 && v.Index == 0 {
+			return ""
+		}
+		return fmt.Sprintf("episode:%s s%02de%02d", show, v.ParentIndex, v.Index)
+	}
+	t := normalizeTitle(v.Title)
+	if t == "" || v.Year == 0 {
+		return ""
+	}
+	return fmt.Sprintf("title:%s (%d)", t, v.Year)
+}
+
+// normalizeTitle lowercases a title and reduces it to letters and digits
+// separated by single spaces ("Spider-Man: Far From Home" -> "spider man far from home").
+// A leading "the"/"a"/"an" is dropped.
+func normalizeTitle(t string) string {
+	words := strings.FieldsFunc(strings.ToLower(t), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	if len(words) > 1 {
+		switch words[0] {
+		case "the", "a", "an":
+			words = words[1:]
+		}
+	}
+	return strings.Join(words, " ")
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestNormalizeTitle(t *testing.T) {
+	cases := map[string]string{
+		"Spider-Man: Far From Home": "spider man far from home",
+		"The Matrix":                "matrix",
+		"  Heat ":                   "heat",
+		"The":                       "the",
+		"Amélie":                    "amélie",
+	}
+	for in, want := range cases {
+		if got := normalizeTitle(in); got != want {
+			t.Errorf("normalizeTitle(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestNormalizeGuid(t *testing.T) {
+	cases := map[string]string{
+		"plex://movie/5d776b":                         "plex://movie/5d776b",
+		"com.plexapp.agents.imdb://tt0113277?lang=en": "com.plexapp.agents.imdb://tt0113277",
+		"local://1234":                                "",
+		"com.plexapp.agents.none://abc":               "",
+		"":                                            "",
+	}
+	for in, want := range cases {
+		if got := normalizeGuid(in); got != want {
+			t.Errorf("normalizeGuid(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestFindSplitDuplicates(t *testing.T) {
+	vids := []Video{
+		{RatingKey: "1", Type: "movie", Title: "Heat", Year: 1995, Guid: "plex://movie/a", Guids: []Guid{{ID: "imdb://tt0113277"}}},
+		{RatingKey: "2", Type: "movie", Title: "Heat", Year: 1995, Guid: "local://2"},                                                   // unmatched copy, title+year
+		{RatingKey: "3", Type: "movie", Title: "Heat (1995)", Year: 0, Guid: "plex://movie/b", Guids: []Guid{{ID: "imdb://tt0113277"}}}, // other match, same imdb
+		{RatingKey: "4", Type: "movie", Title: "Heat", Year: 1986, Guid: "plex://movie/c"},                                              // different film
+		{RatingKey: "5", Type: "movie", Title: "Unmatched", Guid: "local://5"},
+		{RatingKey: "6", Type: "movie", Title: "Unmatched", Guid: "local://6"}, // no year: not joined
+	}
+	groups := findSplitDuplicates(Directory{Key: "1", Title: "Movies"}, vids, true)
+	if len(groups) != 1 {
+		t.Fatalf("groups = %d, want 1: %+v", len(groups), groups)
+	}
+	g := groups[0]
+	var rks []string
+	for _, it := range g.Items {
+		rks = append(rks, it.RatingKey)
+	}
+	if !reflect.DeepEqual(rks, []string{"1", "2", "3"}) {
+		t.Errorf("items = %v, want [1 2 3]", rks)
+	}
+	want := []string{"imdb://tt0113277", "title:heat (1995)"}
+	if !reflect.DeepEqual(g.MatchedOn, want) {
+		t.Errorf("matched_on = %v, want %v", g.MatchedOn, want)
+	}
+	if g.SectionID != "1" || g.SectionTitle != "Movies" {
+		t.Errorf("section = %s/%s", g.SectionID, g.SectionTitle)
+	}
+}
+
+func TestFindSplitDuplicates_SameFile(t *testing.T) {
+	part := func(file string) []Media { return []Media{{ID: "m", Part: []Part{{File: file}}}} }
+	vids := []Video{
+		{RatingKey: "1", Type: "movie", Title: "Heat", Year: 1995, Guid: "plex://movie/a", Media: part("/movies/Heat (1995)/Heat.mkv")},
+		{RatingKey: "2", Type: "movie", Title: "Heat.1995.1080p", Guid: "local://2", Media: part("/movies//Heat (1995)/./Heat.mkv")}, // mismatched, same file
+		{RatingKey: "3", Type: "movie", Title: "Alien", Year: 1979, Media: part(`D:\Movies\Alien.mkv`)},
+		{RatingKey: "4", Type: "movie", Title: "Alien.1979", Media: part(`d:\movies\ALIEN.mkv`)},
+		{RatingKey: "5", Type: "movie", Title: "Other", Media: part("/movies/Heat (1995)/Heat.avi")},
+	}
+	groups := findSplitDuplicates(Directory{Key: "1", Title: "Movies"}, vids, true)
+	if len(groups) != 2 {
+		t.Fatalf("groups = %d, want 2: %+v", len(groups), groups)
+	}
+	want := [][]string{{"file:d:/movies/alien.mkv"}, {"file:/movies/Heat (1995)/Heat.mkv"}}
+	for i, g := range groups {
+		if len(g.Items) != 2 || !reflect.DeepEqual(g.MatchedOn, want[i]) {
+			t.Errorf("group %d: %d items, matched_on = %v, want %v", i, len(g.Items), g.MatchedOn, want[i])
+		}
+	}
+}
+
+func TestFindSplitDuplicates_Episodes(t *testing.T) {
+	vids := []Video{
+		{RatingKey: "10", Type: "episode", Title: "Pilot", GrandparentTitle: "The Office", ParentIndex: 1, Index: 1},
+		{RatingKey: "11", Type: "episode", Title: "Episode 1", GrandparentTitle: "Office", ParentIndex: 1, Index: 1},
+		{RatingKey: "12", Type: "episode", Title: "Pilot", GrandparentTitle: "The Office", ParentIndex: 1, Index: 2},
+	}
+	groups := findSplitDuplicates(Directory{Key: "2", Title: "Shows"}, vids, true)
+	if len(groups) != 1 || len(groups[0].Items) != 2 {
+		t.Fatalf("groups = %+v, want one group of 2", groups)
+	}
+	if got := groups[0].MatchedOn; !reflect.DeepEqual(got, []string{"episode:office s01e01"}) {
+		t.Errorf("matched_on = %v", got)
+	}
+}
+
+const allItemsXML = `<?xml version="1.0"?>
+<MediaContainer size="3" totalSize="3">
+  <Video ratingKey="100" type="movie" title="Dup Movie" year="2020" guid="plex://movie/x">
+    <Media id="m1" videoResolution="1080"><Part id="p1" file="/movies/Dup Movie (2020)/a.mkv" size="1000" /></Media>
+    <Guid id="tmdb://42" />
+  </Video>
+  <Video ratingKey="200" type="movie" title="Dup Movie" year="2020" guid="plex://movie/y">
+    <Media id="m2" videoResolution="2160"><Part id="p2" file="/movies/Dup Movie (2020) copy/b.mkv" size="2000" /></Media>
+    <Guid id="tmdb://42" />
+  </Video>
+  <Video ratingKey="300" type="movie" title="Other" year="2021" guid="plex://movie/z" />
+</MediaContainer>`
+
+func TestCollectRun_SplitDups(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/library/sections", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(sectionsXML))
+	})
+	mux.HandleFunc("/library/sections/1/all", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("duplicate") == "1" {
+			_, _ = w.Write([]byte(`<MediaContainer size="0" totalSize="0"></MediaContainer>`))
+			return
+		}
+		if r.URL.Query().Get("includeGuids") != "1" {
+			t.Errorf("full listing without includeGuids: %s", r.URL.RawQuery)
+		}
+		_, _ = w.Write([]byte(allItemsXML))
+	})
+
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	o := Options{BaseURL: ts.URL, Token: "fake", SectionsCSV: "1", SplitDups: true, Timeout: 5 * time.Second}
+	pc, err := NewClient(o)
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	out, err := RunCollection(context.Background(), pc, o)
+	if err != nil {
+		t.Fatalf("RunCollection: %v", err)
+	}
+	if out.Summary.SplitDuplicateGroups != 1 || len(out.SplitDuplicates) != 1 {
+		t.Fatalf("split groups = %d/%d, want 1", out.Summary.SplitDuplicateGroups, len(out.SplitDuplicates))
+	}
+	g := out.SplitDuplicates[0]
+	if len(g.Items) != 2 || g.Items[1].Versions[0].Parts[0].Size != 2000 {
+		t.Errorf("unexpected group: %+v", g)
+	}
+	if !reflect.DeepEqual(g.Items[0].ExternalIDs, []string{"tmdb://42"}) {
+		t.Errorf("external ids = %v", g.Items[0].ExternalIDs)
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"sort"
+	"strings"
+)
+
+// Storage accounting sums the part sizes Plex recorded for the duplicate
+// items: everything their versions hold, what the keep-best recommendations
+// would free and what sits in ghost parts, per library and overall, broken
+// down by resolution, video codec and root folder.
+
+// storageTally accumulates StorageStats item by item.
+type storageTally struct {
+	stats StorageStats
+	res   map[string]*StorageBucket
+	codec map[string]*StorageBucket
+	root  map[string]*StorageBucket
+}
+
+func newStorageTally() *storageTally {
+	return &storageTally{
+		res:   map[string]*StorageBucket{},
+		codec: map[string]*StorageBucket{},
+		root:  map[string]*StorageBucket{},
+	}
+}
+
+// add counts the parts of a duplicate item. locs are its section's folders,
+// used to find each part's root.
+func (t *storageTally) add(it Item, locs []Location, verify bool) {
+	remove := map[string]bool{}
+	if it.Recommendation != nil {
+		for _, c := range it.Recommendation.CandidatesForRemoval {
+			remove[c.VersionID] = true
+		}
+	}
+	for _, v := range it.Versions {
+		res := normalizeResKey(v)
+		codec := fallback(strings.ToLower(v.VideoCodec), "unknown")
+		for _, p := range v.Parts {
+			b := StorageBucket{Parts: 1, Bytes: p.Size}
+			if remove[v.ID] {
+				b.ReclaimableBytes = p.Size
+			}
+			if partIsGhost(p, verify) {
+				b.GhostBytes = p.Size
+			}
+			t.stats.DuplicateBytes += b.Bytes
+			t.stats.ReclaimableBytes += b.ReclaimableBytes
+			t.stats.GhostBytes += b.GhostBytes
+			addBucket(t.res, res, b)
+			addBucket(t.codec, codec, b)
+			addBucket(t.root, storageRoot(p.File, locs), b)
+		}
+	}
+}
+
+func addBucket(m map[string]*StorageBucket, key string, b StorageBucket) {
+	acc, ok := m[key]
+	if !ok {
+		acc = &StorageBucket{Key: key}
+		m[key] = acc
+	}
+	acc.Parts += b.Parts
+	acc.Bytes += b.Bytes
+	acc.ReclaimableBytes += b.ReclaimableBytes
+	acc.GhostBytes += b.GhostBytes
+}
+
+// result returns the totals with each breakdown sorted by bytes, largest first.
+func (t *storageTally) result() StorageStats {
+	s := t.stats
+	s.ByResolution = sortedBuckets(t.res)
+	s.ByCodec = sortedBuckets(t.codec)
+	s.ByRoot = sortedBuckets(t.root)
+	return s
+}
+
+func sortedBuckets(m map[string]*StorageBucket) []StorageBucket {
+	out := make([]StorageBucket, 0, len(m))
+	for _, b := range m {
+		out = append(out, *b)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Bytes != out[j].Bytes {
+			return out[i].Bytes > out[j].Bytes
+		}
+		return out[i].Key < out[j].Key
+	})
+	return out
+}
+
+// storageRoot returns the root a part file is stored under: the longest
+// section folder holding it, else the first two folders of its path (on a
+// NAS usually the mount, e.g. /mnt/disk1 or D:\Movies).
+func storageRoot(file string, locs []Location) string {
+	root := ""
+	for _, l := range locs {
+		if p := strings.TrimRight(l.Path, `/\`); underDir(file, p) && len(p) > len(root) {
+			root = p
+		}
+	}
+	if root != "" {
+		return root
+	}
+	sep := "/"
+	if windowsPath(file) {
+		sep = `\`
+	}
+	comps := strings.Split(plexDir(file), sep)
+	n := 2
+	if comps[0] == "" {
+		n = 3 // leading separator
+	}
+	if len(comps) > n {
+		comps = comps[:n]
+	}
+	return fallback(strings.Join(comps, sep), "unknown")
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestStorageRoot(t *testing.T) {
+	locs := []Location{{Path: "/data/movies"}, {Path: "/data/movies/4k/"}}
+	cases := map[string]string{
+		"/data/movies/Heat (1995)/Heat.mkv":    "/data/movies",
+		"/data/movies/4k/Heat (1995)/Heat.mkv": "/data/movies/4k",
+		"/data/movies2/Heat (1995)/Heat.mkv":   "/data/movies2", // whole components only
+		"/mnt/disk1/old/Heat.mkv":              "/mnt/disk1",
+		`D:\Movies\Heat (1995)\Heat.mkv`:       `D:\Movies`,
+		"/Heat.mkv":                            "/",
+		"":                                     "unknown",
+	}
+	for in, want := range cases {
+		if got := storageRoot(in, locs); got != want {
+			t.Errorf("storageRoot(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func storageItem() Item {
+	return Item{
+		Versions: []Version{
+			{ID: "1", VideoResolution: "4k", VideoCodec: "HEVC", Parts: []PartOut{{File: "/data/movies/Heat/Heat.2160p.mkv", Size: 400, VerifiedOnDisk: true}}},
+			{ID: "2", VideoResolution: "1080", VideoCodec: "h264", Parts: []PartOut{
+				{File: "/mnt/disk2/Heat/Heat.cd1.mkv", Size: 100, VerifiedOnDisk: true},
+				{File: "/mnt/disk2/Heat/Heat.cd2.mkv", Size: 50},
+			}},
+		},
+		Recommendation: &Recommendation{Keep: "1", CandidatesForRemoval: []RemovalCandidate{{VersionID: "2", Bytes: 150}}},
+	}
+}
+
+func TestStorageTally(t *testing.T) {
+	tally := newStorageTally()
+	tally.add(storageItem(), []Location{{Path: "/data/movies"}}, true)
+	s := tally.result()
+
+	if s.DuplicateBytes != 550 || s.ReclaimableBytes != 150 || s.GhostBytes != 50 {
+		t.Errorf("totals = %d/%d/%d, want 550/150/50", s.DuplicateBytes, s.ReclaimableBytes, s.GhostBytes)
+	}
+	wantRes := []StorageBucket{
+		{Key: "2160", Parts: 1, Bytes: 400},
+		{Key: "1080", Parts: 2, Bytes: 150, ReclaimableBytes: 150, GhostBytes: 50},
+	}
+	if !reflect.DeepEqual(s.ByResolution, wantRes) {
+		t.Errorf("by_resolution = %+v", s.ByResolution)
+	}
+	if len(s.ByCodec) != 2 || s.ByCodec[0].Key != "hevc" || s.ByCodec[1].Key != "h264" {
+		t.Errorf("by_codec = %+v", s.ByCodec)
+	}
+	if len(s.ByRoot) != 2 || s.ByRoot[0].Key != "/data/movies" || s.ByRoot[1].Key != "/mnt/disk2" {
+		t.Errorf("by_root = %+v", s.ByRoot)
+	}
+
+	// parts of an item that wasn't deep-fetched are unknown, not ghosts
+	it := storageItem()
+	for i := range it.Versions[1].Parts {
+		it.Versions[1].Parts[i].Unverified = true
+	}
+	tally = newStorageTally()
+	tally.add(it, nil, true)
+	if s := tally.result(); s.GhostBytes != 0 || s.DuplicateBytes != 550 {
+		t.Errorf("unverified parts: ghost %d, duplicate %d", s.GhostBytes, s.DuplicateBytes)
+	}
+
+	// without verification nothing is a ghost
+	tally = newStorageTally()
+	tally.add(storageItem(), nil, false)
+	if s := tally.result(); s.GhostBytes != 0 || s.ByRoot[0].Key != "/data/movies" {
+		t.Errorf("verify off: %+v", s)
+	}
+}
+
+func TestRenderHTML_Storage(t *testing.T) {
+	tally := newStorageTally()
+	tally.add(storageItem(), nil, true)
+	s := tally.result()
+	out := Output{Summary: Summary{
+		Storage:   s,
+		Libraries: []LibrarySummary{{SectionID: "1", SectionTitle: "Movies", Storage: s}},
+	}}
+	fn := filepath.Join(t.TempDir(), "storage.html")
+	if err := RenderHTML(out, true, false, fn); err != nil {
+		t.Fatalf("RenderHTML: %v", err)
+	}
+	b, _ := os.ReadFile(fn)
+	html := string(b)
+	for _, want := range []string{"<h2>Storage</h2>", "Storage breakdown", "<th>Root folder</th>", "<code>/mnt/disk2</code>", "<code>hevc</code>"} {
+		if !strings.Contains(html, want) {
+			t.Errorf("report missing %q", want)
+		}
+	}
+}
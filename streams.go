@@ -0,0 +1,179 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// A deep fetch returns each part's streams: the video track with its bit
+// depth and color metadata, the audio tracks and the subtitles. They tell
+// an HDR10 or Dolby Vision version from an SDR one (filename tokens only
+// guess), and an Atmos track from stereo.
+
+// Stream types in Stream.StreamType
+const (
+	streamVideo    = 1
+	streamAudio    = 2
+	streamSubtitle = 3
+)
+
+// Dynamic ranges in VideoStream.DynamicRange
+const (
+	rangeSDR         = "sdr"
+	rangeHDR10       = "hdr10"
+	rangeHLG         = "hlg"
+	rangeDolbyVision = "dolby_vision"
+)
+
+var dynamicRanges = map[string]bool{rangeSDR: true, rangeHDR10: true, rangeHLG: true, rangeDolbyVision: true}
+
+// newStreams fills in the version's video, audio and subtitle streams from
+// the first part that has any (the parts of a stacked version share them).
+func newStreams(ver *Version, m Media) {
+	for _, p := range m.Part {
+		if len(p.Stream) == 0 {
+			continue
+		}
+		for _, s := range p.Stream {
+			switch s.StreamType {
+			case streamVideo:
+				if ver.Video == nil {
+					ver.Video = newVideoStream(s)
+				}
+			case streamAudio:
+				ver.Audio = append(ver.Audio, AudioStream{
+					Codec:    s.Codec,
+					Profile:  s.Profile,
+					Channels: s.Channels,
+					Layout:   s.AudioChannelLayout,
+					Language: fallback(s.LanguageCode, s.Language),
+					Title:    s.Title,
+					Default:  s.Default == 1,
+					Atmos:    containsFoldAny("atmos", s.Title, s.DisplayTitle, s.ExtendedDisplayTitle),
+				})
+			case streamSubtitle:
+				ver.Subtitles = append(ver.Subtitles, SubtitleStream{
+					Codec:    s.Codec,
+					Language: fallback(s.LanguageCode, s.Language),
+					Title:    s.Title,
+					Forced:   s.Forced == 1,
+					SDH:      s.HearingImpaired == 1 || containsFoldAny("sdh", s.Title, s.DisplayTitle, s.ExtendedDisplayTitle),
+					External: s.Key != "",
+				})
+			}
+		}
+		return
+	}
+}
+
+func newVideoStream(s Stream) *VideoStream {
+	vs := &VideoStream{
+		Codec:          s.Codec,
+		BitDepth:       s.BitDepth,
+		ColorTransfer:  s.ColorTrc,
+		ColorPrimaries: s.ColorPrimaries,
+	}
+	if s.DOVIPresent == 1 {
+		vs.DOVIProfile = s.DOVIProfile
+	}
+	switch trc := strings.ToLower(s.ColorTrc); {
+	case s.DOVIPresent == 1:
+		vs.DynamicRange = rangeDolbyVision
+	case trc == "smpte2084":
+		vs.DynamicRange = rangeHDR10
+	case trc == "arib-std-b67":
+		vs.DynamicRange = rangeHLG
+	case trc != "" || s.BitDepth == 8:
+		vs.DynamicRange = rangeSDR
+	}
+	return vs
+}
+
+// containsFoldAny reports whether any of ss contains sub, ignoring case.
+func containsFoldAny(sub string, ss ...string) bool {
+	for _, s := range ss {
+		if strings.Contains(strings.ToLower(s), sub) {
+			return true
+		}
+	}
+	return false
+}
+
+// versionDynamicRange is the version's dynamic range from its video
+// stream, or "" when the streams weren't fetched or don't say.
+func versionDynamicRange(v Version) string {
+	if v.Video == nil {
+		return ""
+	}
+	return v.Video.DynamicRange
+}
+
+// dynamicRangeLabel is how the HTML report shows a dynamic range.
+func dynamicRangeLabel(r string) string {
+	switch r {
+	case rangeSDR:
+		return "SDR"
+	case rangeHDR10:
+		return "HDR10"
+	case rangeHLG:
+		return "HLG"
+	case rangeDolbyVision:
+		return "Dolby Vision"
+	}
+	return r
+}
+
+// audioLabel is a short description of an audio track, e.g. "ENG TRUEHD 7.1 Atmos".
+func audioLabel(a AudioStream) string {
+	var parts []string
+	if a.Language != "" {
+		parts = append(parts, strings.ToUpper(a.Language))
+	}
+	if a.Codec != "" {
+		parts = append(parts, strings.ToUpper(a.Codec))
+	}
+	switch {
+	case a.Layout != "":
+		parts = append(parts, strings.TrimSuffix(strings.TrimSuffix(a.Layout, "(side)"), "(back)"))
+	case a.Channels > 0:
+		parts = append(parts, channelsLabel(a.Channels))
+	}
+	if a.Atmos {
+		parts = append(parts, "Atmos")
+	}
+	return strings.Join(parts, " ")
+}
+
+// channelsLabel turns a channel count into the usual layout name.
+func channelsLabel(n int) string {
+	switch n {
+	case 1:
+		return "mono"
+	case 2:
+		return "stereo"
+	case 6:
+		return "5.1"
+	case 8:
+		return "7.1"
+	}
+	return fmt.Sprintf("%dch", n)
+}
+
+// subtitleLabel is a short description of a subtitle track, e.g. "eng (forced)".
+func subtitleLabel(s SubtitleStream) string {
+	l := fallback(s.Language, "und")
+	var tags []string
+	if s.Forced {
+		tags = append(tags, "forced")
+	}
+	if s.SDH {
+		tags = append(tags, "SDH")
+	}
+	if s.External {
+		tags = append(tags, "external")
+	}
+	if len(tags) > 0 {
+		l += " (" + strings.Join(tags, ", ") + ")"
+	}
+	return l
+}
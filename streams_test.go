@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/xml"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const streamsXML = `<MediaContainer>
+  <Video ratingKey="100" title="Dune" year="2021">
+    <Media id="1" videoResolution="4k" videoCodec="hevc" audioCodec="truehd" container="mkv">
+      <Part id="11" file="/m/Dune (2021)/Dune.2160p.mkv" size="100">
+        <Stream id="1" streamType="1" codec="hevc" bitDepth="10" colorTrc="smpte2084" colorPrimaries="bt2020" DOVIPresent="1" DOVIProfile="8" />
+        <Stream id="2" streamType="2" codec="truehd" channels="8" audioChannelLayout="7.1(side)" languageCode="eng" language="English" default="1" extendedDisplayTitle="English (TrueHD Atmos 7.1)" />
+        <Stream id="3" streamType="2" codec="ac3" channels="2" languageCode="fra" language="Français" />
+        <Stream id="4" streamType="3" codec="srt" languageCode="eng" forced="1" />
+        <Stream id="5" streamType="3" codec="srt" languageCode="eng" title="English SDH" key="/library/streams/5" />
+      </Part>
+    </Media>
+    <Media id="2" videoResolution="4k" videoCodec="hevc" audioCodec="ac3" container="mkv">
+      <Part id="21" file="/m/Dune (2021)/Dune.HDR.Tonemapped.mkv" size="50">
+        <Stream id="6" streamType="1" codec="hevc" bitDepth="8" colorTrc="bt709" />
+        <Stream id="7" streamType="2" codec="ac3" channels="6" languageCode="eng" />
+      </Part>
+    </Media>
+  </Video>
+</MediaContainer>`
+
+func streamsItem(t *testing.T) Item {
+	t.Helper()
+	var mc mediaContainer
+	if err := xml.Unmarshal([]byte(streamsXML), &mc); err != nil {
+		t.Fatal(err)
+	}
+	v := mc.Video[0]
+	it := newItem(&v, &v)
+	for _, m := range v.Media {
+		it.Versions = append(it.Versions, newVersion(m, v.EditionTitle))
+	}
+	return it
+}
+
+func TestNewVersion_Streams(t *testing.T) {
+	it := streamsItem(t)
+	dv, sdr := it.Versions[0], it.Versions[1]
+
+	if v := dv.Video; v == nil || v.DynamicRange != rangeDolbyVision || v.DOVIProfile != 8 || v.BitDepth != 10 || v.ColorTransfer != "smpte2084" {
+		t.Errorf("video = %+v", dv.Video)
+	}
+	if len(dv.Audio) != 2 || !dv.Audio[0].Atmos || !dv.Audio[0].Default || dv.Audio[0].Channels != 8 || dv.Audio[1].Language != "fra" {
+		t.Errorf("audio = %+v", dv.Audio)
+	}
+	if got := audioLabel(dv.Audio[0]); got != "ENG TRUEHD 7.1 Atmos" {
+		t.Errorf("audioLabel = %q", got)
+	}
+	if len(dv.Subtitles) != 2 || !dv.Subtitles[0].Forced || !dv.Subtitles[1].SDH || !dv.Subtitles[1].External {
+		t.Errorf("subtitles = %+v", dv.Subtitles)
+	}
+
+	// the stream says SDR even though the file name says HDR
+	if sdr.Video.DynamicRange != rangeSDR || versionIsHDR(sdr) || !versionIsHDR(dv) {
+		t.Errorf("HDR detection: dv=%v sdr=%v (%+v)", versionIsHDR(dv), versionIsHDR(sdr), sdr.Video)
+	}
+	// without streams, file name tokens still decide
+	if !versionIsHDR(Version{Parts: []PartOut{{File: "/m/Dune.2021.2160p.HDR10.mkv"}}}) {
+		t.Errorf("file name fallback lost")
+	}
+}
+
+func TestPolicy_IgnoreHDRSDR(t *testing.T) {
+	ps, err := ParsePolicies("ignore-hdr-sdr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	it := streamsItem(t)
+	if d := ps.Decide(it); !d.Ignore || d.Reason != reasonHDRSDRPair {
+		t.Errorf("HDR+SDR pair: %+v", d)
+	}
+	it.Versions[1] = it.Versions[0]
+	if d := ps.Decide(it); d.Ignore {
+		t.Errorf("two HDR versions ignored: %+v", d)
+	}
+}
+
+func TestVersionMatcher_Streams(t *testing.T) {
+	it := streamsItem(t)
+	yes := true
+	cases := []struct {
+		m    VersionMatcher
+		want [2]bool
+	}{
+		{VersionMatcher{DynamicRange: []string{"dolby_vision", "hdr10"}}, [2]bool{true, false}},
+		{VersionMatcher{MinBitDepth: 10}, [2]bool{true, false}},
+		{VersionMatcher{AudioLanguage: []string{"FRA"}}, [2]bool{true, false}},
+		{VersionMatcher{MinAudioChannels: 6}, [2]bool{true, true}},
+		{VersionMatcher{Atmos: &yes}, [2]bool{true, false}},
+		{VersionMatcher{SubtitleLanguage: []string{"eng"}}, [2]bool{true, false}},
+	}
+	for i, c := range cases {
+		for j, v := range it.Versions {
+			if got := c.m.Match(v); got != c.want[j] {
+				t.Errorf("case %d, version %d: Match = %v", i, j, got)
+			}
+		}
+	}
+	if err := (&VersionMatcher{DynamicRange: []string{"hdr11"}}).validate(); err == nil {
+		t.Errorf("unknown dynamic_range accepted")
+	}
+}
+
+func TestRenderHTML_Streams(t *testing.T) {
+	it := streamsItem(t)
+	out := Output{Sections: []SectionResult{{SectionID: "1", SectionTitle: "Movies", Type: "movie", Items: []Item{it}}}}
+	fn := filepath.Join(t.TempDir(), "streams.html")
+	if err := RenderHTML(out, false, false, fn); err != nil {
+		t.Fatalf("RenderHTML: %v", err)
+	}
+	b, _ := os.ReadFile(fn)
+	s := string(b)
+	for _, want := range []string{"Dolby Vision</span>", "10-bit", "ENG TRUEHD 7.1 Atmos", "eng (forced)", "eng (SDH, external)", ">SDR</span>"} {
+		if !strings.Contains(s, want) {
+			t.Errorf("report missing %q", want)
+		}
+	}
+}